@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// payHandler returns a testEnv for cfg and the routes main would serve over it.
+func payHandler(t *testing.T, cfg config.Config) (*testEnv, http.Handler) {
+	t.Helper()
+	env := newTestEnv(t, cfg)
+	return env, env.handler(t, cfg)
+}
+
+func TestPayHandlerSuccess(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	var res providers.PaymentResponse
+	decode(t, rec, &res)
+	if res.Status != "SUCCESS" || res.ReferenceID != "REF-TXN-1" || res.IsIdempotent {
+		t.Errorf("response %+v, want a new SUCCESS", res)
+	}
+	for header, want := range map[string]string{
+		idempotencyStatusHeader: idempotencyStatusNew,
+		providerHeader:          "MTN",
+		attemptsHeader:          "1",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if status, _ := env.store.GetStatus(context.Background(), cache.TxnKey{TransactionID: "TXN-1"}); status != cache.StatusCompleted {
+		t.Errorf("stored status %q, want %s", status, cache.StatusCompleted)
+	}
+}
+
+func TestPayHandlerReplaysACompletedPayment(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+
+	first := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first: status %d, want 200", first.Code)
+	}
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replay: status %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	var res providers.PaymentResponse
+	decode(t, rec, &res)
+	if !res.IsIdempotent || res.ReferenceID != "REF-TXN-1" {
+		t.Errorf("replay %+v, want the original result marked idempotent", res)
+	}
+	if got := rec.Header().Get(idempotencyStatusHeader); got != idempotencyStatusReplayed {
+		t.Errorf("%s = %q, want %q", idempotencyStatusHeader, got, idempotencyStatusReplayed)
+	}
+	// A replay says nothing about a provider call, because none was made
+	if got := rec.Header().Get(providerHeader); got != "" {
+		t.Errorf("%s = %q on a replay, want none", providerHeader, got)
+	}
+	if calls := env.mtn.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want 1", calls)
+	}
+}
+
+func TestPayHandlerRejectsAReusedKeyWithDifferentParameters(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10)); rec.Code != http.StatusOK {
+		t.Fatalf("first: status %d, want 200", rec.Code)
+	}
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 20))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422 (body %s)", rec.Code, rec.Body)
+	}
+	var res ErrorResponse
+	decode(t, rec, &res)
+	if res.Code != codeIdempotencyKeyReused {
+		t.Errorf("code %s, want %s", res.Code, codeIdempotencyKeyReused)
+	}
+	if calls := env.mtn.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want 1", calls)
+	}
+}
+
+func TestPayHandlerInProgress(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+
+	// Another request (or instance) holds the claim
+	if _, err := env.store.CheckOrSetInProgress(context.Background(), cache.TxnKey{TransactionID: "TXN-1"}); err != nil {
+		t.Fatalf("CheckOrSetInProgress: %v", err)
+	}
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != env.a.InProgressStatus {
+		t.Fatalf("status %d, want %d (body %s)", rec.Code, env.a.InProgressStatus, rec.Body)
+	}
+	var res ErrorResponse
+	decode(t, rec, &res)
+	if res.Code != codeTransactionInProgress || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("code %s, Retry-After %q; want %s with a Retry-After", res.Code, rec.Header().Get("Retry-After"), codeTransactionInProgress)
+	}
+	if calls := env.mtn.calls.Load(); calls != 0 {
+		t.Errorf("provider called %d times, want 0", calls)
+	}
+}
+
+func TestPayHandlerProviderFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       int
+		deadLetter bool
+	}{
+		{"declined", providers.ErrDeclined, http.StatusPaymentRequired, false},
+		{"insufficient funds", providers.ErrInsufficientFunds, http.StatusPaymentRequired, false},
+		{"provider error", providers.ErrProviderInternal, http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, h := payHandler(t, testConfig())
+			env.mtn.err = tt.err
+
+			rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			var res providers.PaymentResponse
+			decode(t, rec, &res)
+			if res.Status != "FAILED" || rec.Header().Get(providerHeader) != "MTN" {
+				t.Errorf("response %+v from %q, want MTN's FAILED response", res, rec.Header().Get(providerHeader))
+			}
+			letter, err := env.store.GetDeadLetter(context.Background(), cache.TxnKey{TransactionID: "TXN-1"})
+			if err != nil {
+				t.Fatalf("GetDeadLetter: %v", err)
+			}
+			if got := letter != nil; got != tt.deadLetter {
+				t.Errorf("dead-lettered = %v, want %v", got, tt.deadLetter)
+			}
+		})
+	}
+}
+
+func TestPayHandlerProviderErrorWithoutAResponse(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+	env.mtn.process = func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+		return nil, providers.ErrProviderInternal
+	}
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502 (body %s)", rec.Code, rec.Body)
+	}
+	var res ErrorResponse
+	decode(t, rec, &res)
+	if res.Code != codeProviderError {
+		t.Errorf("code %s, want %s", res.Code, codeProviderError)
+	}
+}
+
+func TestPayHandlerTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.ProviderTimeout = config.Duration(20 * time.Millisecond)
+	env, h := payHandler(t, cfg)
+	env.mtn.process = func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status %d, want 504 (body %s)", rec.Code, rec.Body)
+	}
+	var res providers.PaymentResponse
+	decode(t, rec, &res)
+	if res.Status != "TIMEOUT" {
+		t.Errorf("status %q, want TIMEOUT", res.Status)
+	}
+	// The outcome is unknown, so the payment is kept for reprocessing
+	if letter, _ := env.store.GetDeadLetter(context.Background(), cache.TxnKey{TransactionID: "TXN-1"}); letter == nil {
+		t.Error("timed-out payment was not dead-lettered")
+	}
+}
+
+func TestPayHandlerFallsBackWhenTheBreakerIsOpen(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+	env.tripBreaker(t, "MTN")
+	before := env.mtn.calls.Load()
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	var res providers.PaymentResponse
+	decode(t, rec, &res)
+	if res.ProviderName != "AIRTEL_MONEY" || rec.Header().Get(providerHeader) != "AIRTEL" {
+		t.Errorf("served by %s (%s), want the AIRTEL fallback", res.ProviderName, rec.Header().Get(providerHeader))
+	}
+	if calls := env.mtn.calls.Load() - before; calls != 0 {
+		t.Errorf("MTN called %d times with its breaker open, want 0", calls)
+	}
+}
+
+func TestPayHandlerBreakerOpenOnEveryProvider(t *testing.T) {
+	env, h := payHandler(t, testConfig())
+	env.tripBreaker(t, "MTN")
+	env.tripBreaker(t, "AIRTEL")
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503 (body %s)", rec.Code, rec.Body)
+	}
+	var res ErrorResponse
+	decode(t, rec, &res)
+	if res.Code != codeProviderUnavailable || res.RetryAfterSeconds < 1 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("response %+v, Retry-After %q; want %s with a retry time", res, rec.Header().Get("Retry-After"), codeProviderUnavailable)
+	}
+	// Nothing was charged, so the client may retry the same TransactionID
+	if status, _ := env.store.GetStatus(context.Background(), cache.TxnKey{TransactionID: "TXN-1"}); status == cache.StatusCompleted {
+		t.Errorf("stored status %q after a 503", status)
+	}
+}
+
+func TestPayHandlerRejectsBeforeCallingAProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      providers.PaymentRequest
+		want     int
+		wantCode string
+	}{
+		{"zero amount", payment("TXN-1", 0), http.StatusBadRequest, codeValidationFailed},
+		{"negative amount", payment("TXN-1", -5), http.StatusBadRequest, codeValidationFailed},
+		{"missing currency", providers.PaymentRequest{TransactionID: "TXN-1", Amount: 10}, http.StatusBadRequest, codeValidationFailed},
+		{"unsupported currency", providers.PaymentRequest{TransactionID: "TXN-1", Amount: 10, Currency: "USD"}, http.StatusUnprocessableEntity, codeUnsupportedCurrency},
+		{"unknown provider", providers.PaymentRequest{TransactionID: "TXN-1", Amount: 10, Currency: "ZAR", ProviderKey: "VODA-123"}, http.StatusNotFound, codeProviderNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, h := payHandler(t, testConfig())
+
+			rec := do(t, h, "POST", "/v1/pay", tt.req)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			var res ErrorResponse
+			decode(t, rec, &res)
+			if res.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", res.Code, tt.wantCode)
+			}
+			if calls := env.mtn.calls.Load() + env.airtel.calls.Load(); calls != 0 {
+				t.Errorf("providers called %d times, want 0", calls)
+			}
+			// A rejected request never holds the key
+			if status, _ := env.store.GetStatus(context.Background(), cache.TxnKey{TransactionID: "TXN-1"}); status != "" {
+				t.Errorf("stored status %q, want none", status)
+			}
+		})
+	}
+}
+
+// benchmarkPayHandler returns /v1/pay over instant stub providers, with logging
+// silenced so the benchmark measures the handler rather than log output.
+func benchmarkPayHandler(b *testing.B) (*testEnv, http.Handler) {
+	b.Helper()
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(logger) })
+
+	cfg := testConfig()
+	env := newTestEnv(b, cfg)
+	return env, env.handler(b, cfg)
+}
+
+func BenchmarkPayHandlerNewPayments(b *testing.B) {
+	_, h := benchmarkPayHandler(b)
+	b.ReportAllocs()
+
+	i := 0
+	for b.Loop() {
+		i++
+		if rec := do(b, h, "POST", "/v1/pay", payment(fmt.Sprintf("TXN-%d", i), 10)); rec.Code != http.StatusOK {
+			b.Fatalf("status %d, body %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func BenchmarkPayHandlerReplays(b *testing.B) {
+	_, h := benchmarkPayHandler(b)
+	if rec := do(b, h, "POST", "/v1/pay", payment("TXN-1", 10)); rec.Code != http.StatusOK {
+		b.Fatalf("first payment: status %d, body %s", rec.Code, rec.Body)
+	}
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if rec := do(b, h, "POST", "/v1/pay", payment("TXN-1", 10)); rec.Code != http.StatusOK {
+			b.Fatalf("status %d, body %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func BenchmarkPayHandlerNewPaymentsParallel(b *testing.B) {
+	_, h := benchmarkPayHandler(b)
+	b.ReportAllocs()
+
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := fmt.Sprintf("TXN-%d", n.Add(1))
+			if rec := do(b, h, "POST", "/v1/pay", payment(id, 10)); rec.Code != http.StatusOK {
+				b.Errorf("status %d, body %s", rec.Code, rec.Body)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errAsyncQueueFull is returned by asyncPayments.Submit when every worker is busy
+// and the backlog is full.
+var errAsyncQueueFull = errors.New("async payment backlog full")
+
+// transactionStatusURL is where clients poll a transaction's status.
+func transactionStatusURL(transactionID string) string {
+	return "/v1/transactions/" + url.PathEscape(transactionID)
+}
+
+// AsyncPayHandler accepts a payment and processes it in the background, so the
+// client doesn't hold a connection open through provider latency and retries.
+// Validation, routing and the idempotency check happen up front, with the same
+// responses as /v1/pay; an accepted payment is IN_PROGRESS until the provider call
+// completes (COMPLETED on success). Poll the status URL, or set a CallbackURL.
+// Until a worker picks it up, POST <status URL>/cancel cancels it.
+// POST /v1/pay/async -> 202 with the status URL, 503 if the backlog is full.
+func (a *Aggregator) AsyncPayHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	req, ok := a.decodePayment(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "POST /v1/pay/async", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(paymentAttributes(req.TransactionID, req.Amount, req.Currency)...))
+	defer span.End()
+
+	// Pending until a worker picks it up, so it can still be cancelled (see CancelHandler)
+	p, out := a.admitPayment(ctx, req, cache.StagePending)
+	if p == nil {
+		writeOutcome(w, out)
+		return
+	}
+
+	if err := a.Async.Submit(ctx, p); err != nil {
+		// Nothing will process it, so give the key back for the client's retry
+		if delErr := a.Store.Delete(ctx, p.key); delErr != nil && !errors.Is(delErr, cache.ErrNotInProgress) {
+			slog.WarnContext(ctx, "failed to release key for rejected async payment", "transaction_id", req.TransactionID, "error", delErr)
+		}
+		slog.WarnContext(ctx, "async payment rejected", "transaction_id", req.TransactionID, "error", err)
+		w.Header().Set("Retry-After", "1")
+		body := apiError(codeServiceBusy, "Service Unavailable", "Too many payments are being processed. Please retry shortly.")
+		body.RetryAfterSeconds = 1
+		writeError(w, http.StatusServiceUnavailable, body)
+		return
+	}
+
+	statusURL := transactionStatusURL(req.TransactionID)
+	w.Header().Set("Location", statusURL)
+	writeJSON(w, http.StatusAccepted, transactionStatus{
+		TransactionID: req.TransactionID,
+		Status:        cache.StatusInProgress,
+		StatusURL:     statusURL,
+	})
+}
+
+// asyncJob is one admitted payment waiting for a worker.
+type asyncJob struct {
+	ctx       context.Context // The submitting request's values (tenant, request ID, trace), without its cancellation
+	payment   *admittedPayment
+	stopLease func() // Stops the heartbeat that keeps the claim alive while the job waits
+}
+
+// asyncPayments runs admitted payments on a fixed pool of workers, fed from a
+// bounded backlog, so background work can't grow without limit.
+type asyncPayments struct {
+	a       *Aggregator
+	timeout time.Duration // Deadline for each payment, like an HTTP request's
+	jobs    chan asyncJob
+	wg      sync.WaitGroup
+
+	// stop aborts in-progress payments once Close gives up on draining
+	stopCtx context.Context
+	stop    context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+// newAsyncPayments starts cfg.Workers workers. Call Close to stop them.
+func newAsyncPayments(a *Aggregator, cfg config.AsyncConfig, timeout time.Duration) *asyncPayments {
+	stopCtx, stop := context.WithCancel(context.Background())
+	p := &asyncPayments{
+		a:       a,
+		timeout: timeout,
+		jobs:    make(chan asyncJob, cfg.QueueSize),
+		stopCtx: stopCtx,
+		stop:    stop,
+	}
+	for range cfg.Workers {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Submit hands an admitted payment to the pool without blocking. It fails with
+// errAsyncQueueFull when the backlog is full. From here until a worker dispatches
+// it, the payment's lease is kept alive (see keepLease), however long the backlog.
+func (p *asyncPayments) Submit(ctx context.Context, payment *admittedPayment) error {
+	ctx = context.WithoutCancel(ctx)
+	job := asyncJob{ctx: ctx, payment: payment, stopLease: p.a.keepLease(ctx, payment.key, payment.req.TransactionID)}
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		job.stopLease()
+		return errAsyncQueueFull
+	}
+}
+
+// Close stops accepting payments and waits for the backlog to be processed,
+// cancelling whatever is still running once ctx expires. Payments cut short keep
+// their IN_PROGRESS claim until it expires, like an interrupted /v1/pay.
+func (p *asyncPayments) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() { close(p.jobs) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.stop()
+		<-done
+		return fmt.Errorf("async payments not drained: %w", ctx.Err())
+	}
+}
+
+func (p *asyncPayments) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.run(job)
+	}
+}
+
+// run processes one payment under its own deadline, aborted early by shutdown.
+func (p *asyncPayments) run(job asyncJob) {
+	if p.stopCtx.Err() != nil {
+		job.stopLease()
+		slog.WarnContext(job.ctx, "dropping async payment on shutdown", "transaction_id", job.payment.req.TransactionID)
+		return
+	}
+	ctx, cancel := context.WithTimeout(job.ctx, p.timeout)
+	defer cancel()
+	stopped := context.AfterFunc(p.stopCtx, cancel)
+	defer stopped()
+
+	// Dispatch renews the lease and executePayment keeps it from there
+	ok := p.dispatch(ctx, job.payment)
+	job.stopLease()
+	if !ok {
+		return
+	}
+	out := p.a.executePayment(ctx, job.payment)
+	// The deadline may have passed; the outcome still has to be recorded
+	p.recordFailure(context.WithoutCancel(ctx), job.payment, out)
+	slog.InfoContext(ctx, "async payment finished", "transaction_id", job.payment.req.TransactionID, "status", out.StatusCode)
+}
+
+// recordFailure keeps a declined or failed payment's outcome under its key as FAILED,
+// whatever CacheFailures says: the client polling the status URL has no other way to
+// learn it. A success is already recorded by executePayment; a timeout's outcome is
+// unknown, so its claim is left to expire and a retry can run it again.
+func (p *asyncPayments) recordFailure(ctx context.Context, payment *admittedPayment, out paymentOutcome) {
+	if out.StatusCode == http.StatusGatewayTimeout {
+		return
+	}
+	var res *providers.PaymentResponse
+	switch body := out.Body.(type) {
+	case *providers.PaymentResponse:
+		if body.Status == "SUCCESS" {
+			return
+		}
+		res = body
+	case *ErrorResponse:
+		// A provider error without a structured response
+		if out.StatusCode != http.StatusPaymentRequired && out.StatusCode != http.StatusBadGateway {
+			return
+		}
+		res = &providers.PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: payment.providerName,
+			Message:      cmp.Or(body.Message, body.Error),
+		}
+	default:
+		return
+	}
+	if err := p.a.Store.SetFailed(cache.WithTerminalFailure(ctx), payment.key, res); err != nil {
+		slog.WarnContext(ctx, "failed to record async payment failure", "transaction_id", payment.req.TransactionID, "error", err)
+	}
+}
+
+// dispatch ends the payment's pending stage, so it can no longer be cancelled, and
+// reports whether it should go ahead. A payment cancelled while it waited is
+// dropped here. So is one whose stage can't be checked: it might be cancelled. So
+// is one whose claim is gone: the key may belong to a retry by now.
+func (p *asyncPayments) dispatch(ctx context.Context, payment *admittedPayment) bool {
+	req := payment.req
+	err := p.a.Store.Dispatch(ctx, payment.key)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, cache.ErrCancelled):
+		p.a.reportOutcome(ctx, req.TransactionID, payment.providerName, outcomeCancelled, payment.start)
+		p.a.recordAudit(ctx, req, payment.providerName, outcomeCancelled, nil)
+		p.a.notifyCompletion(ctx, req, &providers.PaymentResponse{
+			Status:       cache.StatusCancelled,
+			ReferenceID:  "N/A",
+			ProviderName: payment.providerName,
+			Message:      "The payment was cancelled before it was processed.",
+		})
+		return false
+	case errors.Is(err, cache.ErrNotInProgress):
+		// The lease lapsed despite the heartbeat (the store was unreachable for a whole
+		// lease) or an operator cleared the key. Nothing to release: it isn't ours.
+		slog.WarnContext(ctx, "async payment lost its claim while pending, dropping it", "transaction_id", req.TransactionID)
+		return false
+	}
+	slog.ErrorContext(ctx, "failed to dispatch async payment, dropping it", "transaction_id", req.TransactionID, "error", err)
+	if err := p.a.Store.Delete(ctx, payment.key); err != nil && !errors.Is(err, cache.ErrNotInProgress) {
+		slog.WarnContext(ctx, "failed to release key for dropped async payment", "transaction_id", req.TransactionID, "error", err)
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsyncPaymentStatusURLIsPolledWithTheSameKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	rec := do(t, h, "POST", "/v1/pay/async", payment("TXN-1", 10), "X-API-Key", "shop-key")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("pay: status %d, want 202 (body %s)", rec.Code, rec.Body)
+	}
+	var accepted transactionStatus
+	decode(t, rec, &accepted)
+	if accepted.StatusURL != "/v1/transactions/TXN-1" || rec.Header().Get("Location") != accepted.StatusURL {
+		t.Fatalf("statusURL %q, Location %q; want both /v1/transactions/TXN-1", accepted.StatusURL, rec.Header().Get("Location"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := do(t, h, "GET", accepted.StatusURL, nil, "X-API-Key", "shop-key")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("poll: status %d, want 200 (body %s)", rec.Code, rec.Body)
+		}
+		var status transactionStatus
+		decode(t, rec, &status)
+		if status.Status == cache.StatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still %s, want %s", status.Status, cache.StatusCompleted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if rec := do(t, h, "GET", accepted.StatusURL, nil, "X-API-Key", "other-key"); rec.Code != http.StatusNotFound {
+		t.Errorf("poll with another client's key: status %d, want 404", rec.Code)
+	}
+}
+
+func TestAsyncPaymentFailureIsPolledAsFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		process func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error)
+		want    string // In the polled message
+	}{
+		{"declined", func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+			return &providers.PaymentResponse{Status: providers.StatusDeclined, ReferenceID: "N/A", Message: "Insufficient funds."}, providers.ErrInsufficientFunds
+		}, "Insufficient funds."},
+		{"provider error without a response", func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+			return nil, providers.ErrProviderInternal
+		}, providers.ErrProviderInternal.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Failures aren't cached for sync payments, but an async client can only poll
+			cfg := testConfig()
+			cfg.Idempotency.CacheFailures = false
+			env := newTestEnv(t, cfg)
+			env.mtn.process = tt.process
+			h := env.handler(t, cfg)
+
+			rec := do(t, h, "POST", "/v1/pay/async", payment("TXN-1", 10))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("pay: status %d, want 202 (body %s)", rec.Code, rec.Body)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				rec := do(t, h, "GET", "/v1/transactions/TXN-1", nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("poll: status %d, want 200 (body %s)", rec.Code, rec.Body)
+				}
+				var status transactionStatus
+				decode(t, rec, &status)
+				if status.Status == cache.StatusFailed {
+					if !strings.Contains(status.Message, tt.want) {
+						t.Errorf("message %q, want it to contain %q", status.Message, tt.want)
+					}
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("still %s, want %s", status.Status, cache.StatusFailed)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestAsyncPaymentKeepsItsClaimWhileWaitingForAWorker(t *testing.T) {
+	cfg := testConfig()
+	cfg.Async.Workers = 1
+	cfg.Idempotency.InProgressTTL = config.Duration(150 * time.Millisecond)
+	env := newTestEnv(t, cfg)
+	// Each payment holds the only worker for a few leases
+	env.mtn.process = func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+		time.Sleep(500 * time.Millisecond)
+		return &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "REF-" + req.TransactionID}, nil
+	}
+	h := env.handler(t, cfg)
+
+	for _, id := range []string{"TXN-1", "TXN-2"} {
+		if rec := do(t, h, "POST", "/v1/pay/async", payment(id, 10)); rec.Code != http.StatusAccepted {
+			t.Fatalf("pay %s: status %d, want 202 (body %s)", id, rec.Code, rec.Body)
+		}
+	}
+
+	// Well past TXN-2's lease, while it is still in the backlog: a retry must not claim it again
+	time.Sleep(400 * time.Millisecond)
+	if rec := do(t, h, "POST", "/v1/pay/async", payment("TXN-2", 10)); rec.Code == http.StatusAccepted {
+		t.Fatalf("retry of a waiting payment: status 202, want it still claimed")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := env.store.GetStatus(context.Background(), cache.TxnKey{TransactionID: "TXN-2"})
+		if err != nil {
+			t.Fatalf("GetStatus: %v", err)
+		}
+		if status == cache.StatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TXN-2 still %q, want %s", status, cache.StatusCompleted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := env.mtn.calls.Load(); calls != 2 {
+		t.Errorf("provider called %d times, want once per payment", calls)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends a payment attempt to the audit log. Failures are logged
+// but never fail the payment itself.
+func (a *Aggregator) recordAudit(ctx context.Context, req providers.PaymentRequest, providerName, outcome string, res *providers.PaymentResponse) {
+	if a.Audit == nil {
+		return
+	}
+
+	rec := cache.AuditRecord{
+		Timestamp:     time.Now().UTC(),
+		Tenant:        tenantFromContext(ctx),
+		TransactionID: req.TransactionID,
+		Provider:      providerName,
+		Amount:        req.Amount,
+		AmountMinor:   req.AmountMinor,
+		Currency:      req.Currency,
+		Outcome:       outcome,
+	}
+	if res != nil {
+		rec.ReferenceID = res.ReferenceID
+		rec.Fee = res.Fee
+	}
+
+	if err := a.Audit.AppendAudit(ctx, rec); err != nil {
+		slog.WarnContext(ctx, "failed to write audit record", "transaction_id", req.TransactionID, "error", err)
+	}
+}
+
+// AuditHandler returns recent audit records for one UTC day: the caller's own
+// tenant's, or every tenant's for an admin client (see requireAdmin).
+// GET /v1/audit?date=2025-01-31&limit=100 (date defaults to today, limit to 100)
+func (a *Aggregator) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	if a.Audit == nil {
+		writeJSON(w, http.StatusNotImplemented, messageResponse{Error: "Audit log is not enabled"})
+		return
+	}
+
+	day := time.Now().UTC()
+	if v := r.URL.Query().Get("date"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, messageResponse{
+				Error:   "Invalid date",
+				Message: "date must be formatted as YYYY-MM-DD.",
+			})
+			return
+		}
+		day = parsed
+	}
+
+	limit := defaultAuditLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			writeJSON(w, http.StatusBadRequest, messageResponse{
+				Error:   "Invalid limit",
+				Message: "limit must be between 1 and " + strconv.Itoa(maxAuditLimit) + ".",
+			})
+			return
+		}
+		limit = n
+	}
+
+	// The day's list holds every tenant's records; read as far back as allowed to
+	// find limit of the caller's own
+	admin := isAdmin(r.Context())
+	fetch := limit
+	if !admin {
+		fetch = maxAuditLimit
+	}
+	records, err := a.Audit.ListAudit(r.Context(), day, fetch)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read audit log", "error", err)
+		writeJSON(w, http.StatusInternalServerError, messageResponse{Error: "Failed to read audit log"})
+		return
+	}
+	if !admin {
+		tenant := tenantFromContext(r.Context())
+		records = slices.DeleteFunc(records, func(rec cache.AuditRecord) bool { return rec.Tenant != tenant })
+		if len(records) > limit {
+			records = records[len(records)-limit:]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"slices"
+	"testing"
+)
+
+func TestAuditListsOnlyTheCallersRecords(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key", "ops": "ops-key"}
+	cfg.Auth.AdminClients = []string{"ops"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	do(t, h, "POST", "/v1/pay", payment("TXN-SHOP", 10), "X-API-Key", "shop-key")
+	do(t, h, "POST", "/v1/pay", payment("TXN-OTHER", 10), "X-API-Key", "other-key")
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+		ids     []string
+	}{
+		{"no API key", nil, http.StatusUnauthorized, nil},
+		{"client", []string{"X-API-Key", "shop-key"}, http.StatusOK, []string{"TXN-SHOP"}},
+		{"client claiming another tenant", []string{"X-API-Key", "shop-key", "X-Tenant-ID", "other"}, http.StatusOK, []string{"TXN-SHOP"}},
+		{"admin client", []string{"X-API-Key", "ops-key"}, http.StatusOK, []string{"TXN-SHOP", "TXN-OTHER"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "GET", "/v1/audit", nil, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var records []cache.AuditRecord
+			decode(t, rec, &records)
+			var ids []string
+			for _, r := range records {
+				ids = append(ids, r.TransactionID)
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("records for %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// apiKeyHeader is the alternative to "Authorization: Bearer <key>".
+const apiKeyHeader = "X-API-Key"
+
+type clientIDKey struct{}
+
+// withClientID returns a copy of ctx carrying the authenticated client's identifier.
+func withClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, id)
+}
+
+// clientIDFromContext returns the authenticated client's identifier, or "" if there is none.
+func clientIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey{}).(string)
+	return id
+}
+
+type adminKey struct{}
+
+// isAdmin reports whether the request was made by an operator client (see withAdmin).
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// withAdmin marks requests from one of the admins clients as operator requests;
+// it runs after requireAPIKey has identified the client. With no API keys configured
+// there is no one to tell apart, so every request is marked.
+func withAdmin(keys map[string]string, admins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if len(keys) == 0 || slices.Contains(admins, clientIDFromContext(ctx)) {
+			ctx = context.WithValue(ctx, adminKey{}, true)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin rejects with 403 any request withAdmin didn't mark as an operator's.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r.Context()) {
+			slog.WarnContext(r.Context(), "rejected non-admin API key", "path", r.URL.Path, "client", clientIDFromContext(r.Context()))
+			w.Header().Set("Content-Type", "application/json")
+			writeJSON(w, http.StatusForbidden, messageResponse{
+				Error:   "Forbidden",
+				Message: "This endpoint requires an operator API key.",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIKey rejects requests without a valid API key: 401 when no key is
+// sent, 403 when it doesn't match. The matching client identifier is stored in
+// the request context for logging. With no keys configured it passes everything through.
+func requireAPIKey(keys map[string]string, next http.Handler) http.Handler {
+	if len(keys) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := apiKeyFromRequest(r)
+		if presented == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="payments"`)
+			writeJSON(w, http.StatusUnauthorized, messageResponse{
+				Error:   "Unauthorized",
+				Message: "An API key is required as a Bearer token or in the X-API-Key header.",
+			})
+			return
+		}
+
+		client, ok := matchAPIKey(keys, presented)
+		if !ok {
+			slog.WarnContext(r.Context(), "rejected invalid API key", "path", r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			writeJSON(w, http.StatusForbidden, messageResponse{
+				Error:   "Forbidden",
+				Message: "The API key is not valid.",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withClientID(r.Context(), client)))
+	})
+}
+
+// apiKeyFromRequest returns the key from a Bearer Authorization header, falling back to X-API-Key.
+func apiKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get(apiKeyHeader))
+}
+
+// matchAPIKey finds the client owning key. Every configured key is compared in
+// constant time so response timing doesn't reveal how close a guess was.
+func matchAPIKey(keys map[string]string, key string) (client string, ok bool) {
+	for id, candidate := range keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			client, ok = id, true
+		}
+	}
+	return client, ok
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"strconv"
+)
+
+// Authorizations and captures get their own idempotency key namespaces, so the same
+// TransactionID can move through authorize -> capture without the keys colliding.
+const (
+	authKeyPrefix    = "auth:"
+	captureKeyPrefix = "capture:"
+)
+
+// captureRequest is the body of POST /v1/capture.
+type captureRequest struct {
+	TransactionID string  // The authorization's TransactionID
+	Amount        float64 // Amount to capture
+}
+
+// AuthorizeHandler places a hold for a payment without moving money; capture it
+// later with CaptureHandler. A repeated TransactionID replays the stored authorization.
+// POST /v1/authorize -> 200 AUTHORIZED, 425 (InProgressStatus) while the same authorization is in progress.
+func (a *Aggregator) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req providers.PaymentRequest
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, messageResponse{
+			Error:   "Invalid Request Body",
+			Message: err.Error(),
+		})
+		return
+	}
+	req.Currency = providers.NormalizeCurrency(req.Currency)
+	req.Country = providers.NormalizeCountry(req.Country)
+	req.NormalizeAmount()
+	err := a.TransactionIDs.check(req.TransactionID)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, messageResponse{
+			Error:   "Validation Failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Same routing as payments, minus fallback: a hold must be captured where it was placed
+	providerName := providerNameFromKey(req.ProviderKey)
+	if req.ProviderKey == "" {
+		providerName = a.CurrencyRoutes[req.Currency]
+	}
+	if providerName != "" {
+		providerName = a.regional(providerName, req.Country)
+	}
+	if !a.Currencies[req.Currency] || providerName == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":               "Unsupported Currency",
+			"message":             fmt.Sprintf("Currency %s is not accepted.", req.Currency),
+			"supportedCurrencies": a.supportedCurrencies(),
+		})
+		return
+	}
+	provider, ok := a.Providers[providerName]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, messageResponse{Error: fmt.Sprintf("Provider %s not found", providerName)})
+		return
+	}
+	if limit := a.amountLimit(providerName); !limit.Allows(req.Amount) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "Amount Out Of Range",
+			"message": fmt.Sprintf("Provider %s accepts amounts %s.", providerName, limit),
+			"limits":  limit,
+		})
+		return
+	}
+
+	// --- AUTHORIZATION IDEMPOTENCY CHECK ---
+	key := txnKey(ctx, authKeyPrefix+req.TransactionID)
+	req.IdempotencyKey = key.String()
+	state, err := a.Store.CheckOrSetInProgress(ctx, key)
+	if err != nil {
+		// Like refunds, holds fail closed: without the key a double hold can't be ruled out
+		slog.ErrorContext(ctx, "authorization idempotency check failed", "transaction_id", req.TransactionID, "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, messageResponse{
+			Error:   "Service Unavailable",
+			Message: "Authorizations are temporarily unavailable. Please retry.",
+		})
+		return
+	}
+	switch state {
+	case cache.StateInProgress:
+		seconds := a.leaseRetryAfter(ctx, key)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeJSON(w, a.InProgressStatus, map[string]interface{}{
+			"error":             "Authorization in progress",
+			"message":           fmt.Sprintf("An authorization with this ID is currently being processed. Retry in %d seconds.", seconds),
+			"retryAfterSeconds": seconds,
+		})
+		return
+	case cache.StateCompleted:
+		stored, err := a.Store.GetResult(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored authorization", "transaction_id", req.TransactionID, "error", err)
+		}
+		if stored == nil {
+			writeJSON(w, http.StatusConflict, messageResponse{
+				Error:   "Duplicate transaction ID detected",
+				Message: "This transaction ID has already been authorized.",
+			})
+			return
+		}
+		stored.IsIdempotent = true
+		w.Header().Set(idempotencyStatusHeader, idempotencyStatusReplayed)
+		writeJSON(w, http.StatusOK, stored)
+		return
+	}
+
+	slog.InfoContext(ctx, "starting authorization", "transaction_id", req.TransactionID, "provider", providerName, "amount", req.Amount)
+	result, err := a.callGuarded(ctx, providerName, func(ctx context.Context) (interface{}, error) {
+		return provider.Authorize(ctx, req)
+	})
+	res, _ := result.(*providers.PaymentResponse)
+	if err == nil && res.Status != providers.StatusAuthorized {
+		err = fmt.Errorf("authorization %s", res.Status)
+	}
+
+	if err != nil {
+		// Release the key so the authorization can be retried
+		if delErr := a.Store.Delete(ctx, key); delErr != nil {
+			slog.WarnContext(ctx, "failed to release authorization key", "transaction_id", req.TransactionID, "error", delErr)
+		}
+		slog.ErrorContext(ctx, "authorization failed", "transaction_id", req.TransactionID, "provider", providerName, "error", err)
+
+		var body interface{}
+		if res != nil {
+			body = res
+		}
+		a.writeCallError(w, providerName, err, body)
+		return
+	}
+
+	// The stored authorization is what capture consumes, so it must be saved before COMPLETED
+	if err := a.Store.SetResult(ctx, key, res); err != nil {
+		slog.WarnContext(ctx, "failed to store authorization", "transaction_id", req.TransactionID, "error", err)
+	}
+	if err := a.Store.SetCompleted(ctx, key); err != nil {
+		slog.WarnContext(ctx, "failed to mark authorization completed", "transaction_id", req.TransactionID, "error", err)
+	}
+	slog.InfoContext(ctx, "authorization succeeded", "transaction_id", req.TransactionID, "provider", providerName, "authorization_id", res.ReferenceID)
+
+	w.Header().Set(idempotencyStatusHeader, idempotencyStatusNew)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// CaptureHandler settles a hold placed by AuthorizeHandler through the provider that
+// placed it. Each authorization can be captured once.
+// POST /v1/capture -> 200, 409 if the authorization is unknown or already captured.
+func (a *Aggregator) CaptureHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req captureRequest
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, messageResponse{
+			Error:   "Invalid Request Body",
+			Message: err.Error(),
+		})
+		return
+	}
+	if req.TransactionID == "" || req.Amount <= 0 {
+		writeJSON(w, http.StatusBadRequest, messageResponse{
+			Error:   "Validation Failed",
+			Message: "TransactionID is required and Amount must be greater than zero",
+		})
+		return
+	}
+
+	// Only a completed authorization with a stored hold reference can be captured
+	authKey := txnKey(ctx, authKeyPrefix+req.TransactionID)
+	status, err := a.Store.GetStatus(ctx, authKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read authorization status", "transaction_id", req.TransactionID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, messageResponse{Error: "Failed to read authorization status"})
+		return
+	}
+	var auth *providers.PaymentResponse
+	if status == cache.StatusCompleted {
+		auth, err = a.Store.GetResult(ctx, authKey)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored authorization", "transaction_id", req.TransactionID, "error", err)
+		}
+	}
+	if auth == nil {
+		writeJSON(w, http.StatusConflict, messageResponse{
+			Error:   "Authorization Not Found",
+			Message: fmt.Sprintf("No authorization %s is available to capture.", req.TransactionID),
+		})
+		return
+	}
+
+	providerName, ok := a.providerKeyByName(auth.ProviderName)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, messageResponse{Error: fmt.Sprintf("Provider %s not found", auth.ProviderName)})
+		return
+	}
+	provider := a.Providers[providerName]
+
+	// --- CAPTURE IDEMPOTENCY CHECK ---
+	captureKey := txnKey(ctx, captureKeyPrefix+req.TransactionID)
+	state, err := a.Store.CheckOrSetInProgress(ctx, captureKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "capture idempotency check failed", "transaction_id", req.TransactionID, "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, messageResponse{
+			Error:   "Service Unavailable",
+			Message: "Captures are temporarily unavailable. Please retry.",
+		})
+		return
+	}
+	switch state {
+	case cache.StateInProgress:
+		seconds := a.leaseRetryAfter(ctx, captureKey)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeJSON(w, a.InProgressStatus, map[string]interface{}{
+			"error":             "Capture in progress",
+			"message":           fmt.Sprintf("A capture for this authorization is currently being processed. Retry in %d seconds.", seconds),
+			"retryAfterSeconds": seconds,
+		})
+		return
+	case cache.StateCompleted:
+		writeJSON(w, http.StatusConflict, messageResponse{
+			Error:   "Already Captured",
+			Message: fmt.Sprintf("Authorization %s has already been captured.", req.TransactionID),
+		})
+		return
+	}
+
+	slog.InfoContext(ctx, "starting capture", "transaction_id", req.TransactionID, "provider", providerName, "amount", req.Amount)
+	result, err := a.callGuarded(ctx, providerName, func(ctx context.Context) (interface{}, error) {
+		return provider.Capture(ctx, providers.CaptureRequest{
+			TransactionID:   req.TransactionID,
+			AuthorizationID: auth.ReferenceID,
+			Amount:          req.Amount,
+		})
+	})
+	res, _ := result.(*providers.PaymentResponse)
+	if err == nil && res.Status != "SUCCESS" {
+		err = fmt.Errorf("capture %s", res.Status)
+	}
+
+	if err != nil {
+		// Release the key so the capture can be retried
+		if delErr := a.Store.Delete(ctx, captureKey); delErr != nil {
+			slog.WarnContext(ctx, "failed to release capture key", "transaction_id", req.TransactionID, "error", delErr)
+		}
+		slog.ErrorContext(ctx, "capture failed", "transaction_id", req.TransactionID, "provider", providerName, "error", err)
+
+		var body interface{}
+		if res != nil {
+			body = res
+		}
+		a.writeCallError(w, providerName, err, body)
+		return
+	}
+
+	if err := a.Store.SetResult(ctx, captureKey, res); err != nil {
+		slog.WarnContext(ctx, "failed to store capture result", "transaction_id", req.TransactionID, "error", err)
+	}
+	if err := a.Store.SetCompleted(ctx, captureKey); err != nil {
+		slog.WarnContext(ctx, "failed to mark capture completed", "transaction_id", req.TransactionID, "error", err)
+	}
+	slog.InfoContext(ctx, "capture succeeded", "transaction_id", req.TransactionID, "provider", providerName, "reference_id", res.ReferenceID)
+
+	writeJSON(w, http.StatusOK, res)
+}
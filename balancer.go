@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"payment-gateway-aggregator/providers"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// weightedPicker chooses among candidates in proportion to their weights.
+// It owns its random source so a fixed seed gives a repeatable sequence.
+type weightedPicker struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// newWeightedPicker returns a picker seeded with seed, or with the current time when seed is 0.
+func newWeightedPicker(seed uint64) *weightedPicker {
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return &weightedPicker{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// pick returns one of candidates, chosen by weight. Candidates with no positive
+// weight are never chosen; ok is false when none are left.
+func (p *weightedPicker) pick(candidates []string, weights map[string]int) (choice string, ok bool) {
+	total := 0
+	for _, c := range candidates {
+		if w := weights[c]; w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+
+	p.mu.Lock()
+	n := p.rand.IntN(total)
+	p.mu.Unlock()
+
+	for _, c := range candidates {
+		w := weights[c]
+		if w <= 0 {
+			continue
+		}
+		if n < w {
+			return c, true
+		}
+		n -= w
+	}
+	return "", false
+}
+
+// balance spreads unpinned payments across the providers on the default provider's
+// route: to the one with the lowest recent latency when AdaptiveRouting is on,
+// otherwise by their configured weights. Providers whose circuit is Open, or whose amount
+// limits or daily cap exclude the payment, are left out. Returns the default unchanged when
+// neither applies or no provider is eligible.
+func (a *Aggregator) balance(ctx context.Context, req providers.PaymentRequest, defaultName string) string {
+	adaptive := a.AdaptiveRouting && a.Latency != nil
+	if !adaptive && (a.Weights == nil || a.picker == nil) {
+		return defaultName
+	}
+
+	var healthy []string
+	for _, candidate := range a.routeFor(defaultName) {
+		if _, ok := a.Providers[candidate]; !ok {
+			continue
+		}
+		if breaker, ok := a.Breakers[candidate]; ok && breaker.State() == gobreaker.StateOpen {
+			continue
+		}
+		if !a.amountLimit(candidate).Allows(req.Amount) || !a.withinDailyCap(ctx, candidate, req.Amount) {
+			continue
+		}
+		healthy = append(healthy, candidate)
+	}
+
+	if adaptive {
+		chosen, ok := a.Latency.fastest(healthy)
+		if !ok {
+			return defaultName
+		}
+		avg, _ := a.Latency.average(chosen)
+		slog.InfoContext(ctx, "provider selected by latency", "transaction_id", req.TransactionID, "provider", chosen, "default", defaultName, "latency_ms", avg.Milliseconds())
+		return chosen
+	}
+
+	chosen, ok := a.picker.pick(healthy, a.Weights)
+	if !ok {
+		return defaultName
+	}
+	slog.InfoContext(ctx, "provider selected by weight", "transaction_id", req.TransactionID, "provider", chosen, "default", defaultName, "weight", a.Weights[chosen])
+	return chosen
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway-aggregator/providers"
+	"sync"
+)
+
+// batchItemResult is the outcome of one payment inside a batch.
+type batchItemResult struct {
+	TransactionID string      `json:"transactionID"`
+	StatusCode    int         `json:"statusCode"`
+	Result        interface{} `json:"result"`
+}
+
+// BatchPayHandler accepts a JSON array of payments and processes them concurrently,
+// each through the same validation, idempotency, and circuit breaker path as /v1/pay.
+// Partial failure is expected: the response is 200 with one result per item, in order.
+// POST /v1/pay/batch
+func (a *Aggregator) BatchPayHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var reqs []providers.PaymentRequest
+	if err := decoder.Decode(&reqs); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, apiError(codeBodyTooLarge,
+				"Request Body Too Large", fmt.Sprintf("Request body must not exceed %d bytes.", tooLarge.Limit)))
+			return
+		}
+
+		writeError(w, http.StatusBadRequest, apiError(codeInvalidBody, "Invalid Request Body", err.Error()))
+		return
+	}
+
+	if len(reqs) == 0 || len(reqs) > a.Batch.MaxItems {
+		writeError(w, http.StatusBadRequest, apiError(codeInvalidBatchSize, "Invalid Batch Size",
+			fmt.Sprintf("A batch must contain between 1 and %d payments.", a.Batch.MaxItems)))
+		return
+	}
+
+	results := make([]batchItemResult, len(reqs))
+
+	// Bounded worker pool: never more than Batch.Workers provider calls in flight per batch
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(a.Batch.Workers, len(reqs)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var out paymentOutcome
+				if err := a.TransactionIDs.check(reqs[i].TransactionID); err != nil {
+					out = paymentOutcome{StatusCode: http.StatusBadRequest, Body: apiError(codeValidationFailed, "Validation Failed", err.Error())}
+				} else {
+					out = a.pay(r.Context(), reqs[i])
+				}
+				results[i] = batchItemResult{
+					TransactionID: reqs[i].TransactionID,
+					StatusCode:    out.StatusCode,
+					Result:        out.Body,
+				}
+			}
+		}()
+	}
+	for i := range reqs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, results)
+}
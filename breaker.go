@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// breakerOpenings remembers when each breaker last opened and how long it stays open,
+// so a 503 can tell clients when the breaker may next allow a trial request.
+type breakerOpenings struct {
+	mu       sync.Mutex
+	openedAt map[string]time.Time
+	timeout  map[string]time.Duration
+}
+
+var openings = &breakerOpenings{
+	openedAt: make(map[string]time.Time),
+	timeout:  make(map[string]time.Duration),
+}
+
+// onStateChange records the time a breaker transitions to Open.
+func (b *breakerOpenings) onStateChange(name string, from, to gobreaker.State) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if to == gobreaker.StateOpen {
+		b.openedAt[name] = time.Now()
+	} else {
+		delete(b.openedAt, name)
+	}
+}
+
+// retryAfter returns how long until the named breaker's open period ends (0 if it isn't open).
+func (b *breakerOpenings) retryAfter(name string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	opened, ok := b.openedAt[name]
+	if !ok {
+		return 0
+	}
+	remaining := b.timeout[name] - time.Since(opened)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// BreakerEvent describes one circuit breaker transition.
+type BreakerEvent struct {
+	Name string // Breaker name, e.g. "MTN-Breaker"
+	From gobreaker.State
+	To   gobreaker.State
+	At   time.Time
+}
+
+// BreakerListener is notified of breaker transitions. Listeners run inside the
+// breaker's state change, so they must return quickly and hand slow work
+// (a chat notification, a webhook) off to another goroutine.
+type BreakerListener func(BreakerEvent)
+
+// breakerEvents fans transitions out to the registered listeners.
+type breakerEvents struct {
+	mu        sync.RWMutex
+	listeners []BreakerListener
+}
+
+// Subscribe registers l for every future breaker transition.
+func (e *breakerEvents) Subscribe(l BreakerListener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, l)
+}
+
+// publish logs and counts the transition, then passes it to each listener.
+func (e *breakerEvents) publish(event BreakerEvent) {
+	breakerTransitionsTotal.WithLabelValues(event.Name, event.From.String(), event.To.String()).Inc()
+
+	attrs := []any{"breaker", event.Name, "from", event.From.String(), "to", event.To.String()}
+	if event.To == gobreaker.StateOpen {
+		slog.Warn("circuit breaker opened", attrs...)
+	} else {
+		slog.Info("circuit breaker state changed", attrs...)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, l := range e.listeners {
+		l(event)
+	}
+}
+
+// retryAfterSeconds rounds d up to whole seconds for a Retry-After header (minimum 1).
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// ErrorClassifier reports whether err is the provider's fault, and so counts
+// towards opening its breaker.
+type ErrorClassifier func(err error) bool
+
+// isProviderFault is the default classifier: everything except business errors
+// (declines, insufficient funds, rejected requests) and calls skipped for lack of
+// time (providers.ErrNoTimeLeft) is the provider's fault.
+func isProviderFault(err error) bool {
+	return !providers.IsBusinessError(err) && !errors.Is(err, providers.ErrNoTimeLeft)
+}
+
+// isAnyError counts every error, for providers configured with countBusinessErrors.
+func isAnyError(err error) bool {
+	return true
+}
+
+// newBreaker builds a named circuit breaker from cfg (Using ReadyToTrip for failure rate logic)
+// and publishes its initial state to the circuit_breaker_state gauge. Every transition
+// is published to events. Only errors its ErrorClassifier blames on the provider count
+// as failures.
+func newBreaker(name string, cfg config.BreakerConfig, events *breakerEvents) *gobreaker.CircuitBreaker {
+	var classify ErrorClassifier = isProviderFault
+	if cfg.CountBusinessErrors {
+		classify = isAnyError
+	}
+
+	settings := gobreaker.Settings{
+		Name:        name,
+		MaxRequests: cfg.MaxRequests,
+		Timeout:     time.Duration(cfg.Timeout),
+		Interval:    time.Duration(cfg.Interval),
+
+		// THIS IS THE CORRECT FIELD: Determines when to open the circuit (Closed -> Open).
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			// Ensure we have a minimum number of requests to start calculating the ratio
+			if counts.Requests < cfg.MinRequests {
+				return false
+			}
+
+			// Calculate the failure ratio using TotalFailures since the last clear/reset
+			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+
+			// Return true (OPEN the circuit) if the failure ratio reaches the threshold
+			return failureRatio >= cfg.FailureRatio
+		},
+
+		// This function defines what an error means: only the ones the classifier blames on the
+		// provider are failures, so a wave of legitimate declines can't take it offline.
+		IsSuccessful: func(err error) bool {
+			return err == nil || !classify(err)
+		},
+
+		// Keep the circuit_breaker_state gauge and the open timestamps in sync with every transition,
+		// then let the listeners react
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			recordBreakerState(name, from, to)
+			openings.onStateChange(name, from, to)
+			events.publish(BreakerEvent{Name: name, From: from, To: to, At: time.Now()})
+		},
+	}
+
+	openings.mu.Lock()
+	openings.timeout[name] = time.Duration(cfg.Timeout)
+	openings.mu.Unlock()
+
+	breaker := gobreaker.NewCircuitBreaker(settings)
+
+	// Breakers start Closed; publish that so the gauge exists before the first transition
+	breakerState.WithLabelValues(name).Set(float64(breaker.State()))
+
+	return breaker
+}
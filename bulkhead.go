@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errBulkheadFull is returned instead of calling a provider that already has its
+// maximum number of calls in flight.
+var errBulkheadFull = errors.New("provider at concurrency limit")
+
+// bulkhead caps concurrent calls to one provider, so a slow provider can't pile
+// up goroutines (and connections) before its breaker has seen enough failures to trip.
+type bulkhead chan struct{}
+
+// newBulkhead returns a bulkhead with limit slots. A limit below 1 would turn away
+// every call (or panic), so it is an error.
+func newBulkhead(limit int) (bulkhead, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("max concurrent calls %d: must be at least 1", limit)
+	}
+	return make(bulkhead, limit), nil
+}
+
+// tryAcquire takes a slot without waiting; it reports false when the bulkhead is full.
+// A nil bulkhead is unlimited.
+func (b bulkhead) tryAcquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a slot taken by tryAcquire.
+func (b bulkhead) release() {
+	if b == nil {
+		return
+	}
+	<-b
+}
+
+// isRejection reports whether a provider call was turned away without running:
+// its circuit is open or its bulkhead is full. Either way the next provider on
+// the route may still take the payment.
+func isRejection(err error) bool {
+	return isBreakerRejection(err) || errors.Is(err, errBulkheadFull)
+}
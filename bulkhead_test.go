@@ -0,0 +1,67 @@
+package main
+
+import (
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"testing"
+	"time"
+)
+
+func TestNewBulkheadRejectsNonPositiveLimits(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		if _, err := newBulkhead(limit); err == nil {
+			t.Errorf("newBulkhead(%d): no error", limit)
+		}
+	}
+}
+
+func TestBulkheadRejectsCallsOverTheLimit(t *testing.T) {
+	const limit = 3
+	b, err := newBulkhead(limit)
+	if err != nil {
+		t.Fatalf("newBulkhead: %v", err)
+	}
+	for i := range limit {
+		if !b.tryAcquire() {
+			t.Fatalf("call %d of %d rejected", i+1, limit)
+		}
+	}
+
+	start := time.Now()
+	if b.tryAcquire() {
+		t.Fatalf("call %d admitted over a limit of %d", limit+1, limit)
+	}
+	if waited := time.Since(start); waited > 10*time.Millisecond {
+		t.Errorf("rejection took %s, want it immediate", waited)
+	}
+
+	b.release()
+	if !b.tryAcquire() {
+		t.Error("slot not reusable after release")
+	}
+}
+
+func TestNilBulkheadIsUnlimited(t *testing.T) {
+	var b bulkhead
+	for range 1000 {
+		if !b.tryAcquire() {
+			t.Fatal("nil bulkhead rejected a call")
+		}
+	}
+	b.release()
+}
+
+func TestNewAggregatorRejectsZeroMaxConcurrent(t *testing.T) {
+	cfg := testConfig()
+	pc := cfg.Providers["MTN"]
+	pc.MaxConcurrent = 0
+	cfg.Providers["MTN"] = pc
+
+	_, err := NewAggregator(cfg, Dependencies{
+		Store:     cache.NewMemoryStore(cache.Options{}),
+		Providers: map[string]providers.PaymentProvider{"MTN": newStubProvider("MTN_MOMO")},
+	})
+	if err == nil {
+		t.Fatal("NewAggregator accepted MaxConcurrent 0")
+	}
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// AuditRetention is how long each day's audit list is kept before Redis expires it.
+const AuditRetention = 90 * 24 * time.Hour
+
+// AuditRecord is one append-only entry describing a payment attempt, kept for
+// reconciliation and dispute handling.
+type AuditRecord struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Tenant        string    `json:"tenant,omitempty"` // Empty for single-tenant deployments
+	TransactionID string    `json:"transactionID"`
+	Provider      string    `json:"provider"`
+	Amount        float64   `json:"amount"`
+	AmountMinor   int64     `json:"amountMinor,omitempty"`
+	Currency      string    `json:"currency"`
+	Outcome       string    `json:"outcome"`
+	ReferenceID   string    `json:"referenceID,omitempty"`
+	Fee           float64   `json:"fee,omitempty"`
+}
+
+// AuditStore persists and reads back AuditRecords, grouped per UTC day.
+type AuditStore interface {
+	AppendAudit(ctx context.Context, rec AuditRecord) error
+	// ListAudit returns up to limit of the most recent records for the given day, oldest first.
+	ListAudit(ctx context.Context, day time.Time, limit int) ([]AuditRecord, error)
+}
+
+// auditKey returns the per-day list key, e.g. "audit:2025-01-31".
+func auditKey(day time.Time) string {
+	return "audit:" + day.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// DeadLetterRetention is how long the dead-letter store is kept once nothing new
+// has been added to it.
+const DeadLetterRetention = 30 * 24 * time.Hour
+
+// DefaultDeadLetterLimit is used when Options.DeadLetterLimit is zero.
+const DefaultDeadLetterLimit = 1000
+
+// The dead-letter store: entries as JSON by transaction key, and an index ordering
+// them by failure time. The shared hash tag keeps both in one cluster slot, so
+// they can be updated together.
+const (
+	deadLetterEntriesKey = "{deadletter}:entries"
+	deadLetterIndexKey   = "{deadletter}:index"
+)
+
+// DeadLetter is a payment that failed after every retry and fallback, kept so it
+// can be investigated and reprocessed by hand.
+type DeadLetter struct {
+	Key       TxnKey                   `json:"key"`
+	Request   providers.PaymentRequest `json:"request"`
+	Provider  string                   `json:"provider"`   // The provider whose failure decided the outcome
+	Status    int                      `json:"httpStatus"` // The status the client was sent, e.g. 502 or 504
+	Error     string                   `json:"error"`      // The last error
+	RequestID string                   `json:"requestID,omitempty"`
+	FailedAt  time.Time                `json:"failedAt"`
+}
+
+// DeadLetterStore holds DeadLetters, at most one per transaction. Once it holds
+// Options.DeadLetterLimit entries, adding one drops the oldest.
+type DeadLetterStore interface {
+	// AddDeadLetter stores d, replacing any earlier entry for the same transaction.
+	AddDeadLetter(ctx context.Context, d DeadLetter) error
+	// ListDeadLetters returns up to limit entries, most recent failure first.
+	ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	// GetDeadLetter returns the entry for key, or (nil, nil) when there is none.
+	GetDeadLetter(ctx context.Context, key TxnKey) (*DeadLetter, error)
+	// RemoveDeadLetter deletes the entry for key, if any.
+	RemoveDeadLetter(ctx context.Context, key TxnKey) error
+}
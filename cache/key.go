@@ -0,0 +1,35 @@
+package cache
+
+// TxnKey identifies a transaction in the idempotency store. The same
+// TransactionID under two tenants is two distinct transactions.
+type TxnKey struct {
+	Tenant        string // Empty for single-tenant deployments
+	TransactionID string
+}
+
+// String returns the store key: "txn:<id>" without a tenant, "txn:<tenant>:<id>" with one.
+// Keeping the tenant-less form unchanged means existing keys stay valid.
+func (k TxnKey) String() string {
+	if k.Tenant == "" {
+		return "txn:" + k.TransactionID
+	}
+	return "txn:" + k.Tenant + ":" + k.TransactionID
+}
+
+// resultKey is where the replayable PaymentResponse for the transaction is kept.
+// SetFailed writes it in one MULTI with the status key, so the hash tag puts both
+// in the same Redis Cluster slot: "{txn:<id>}" hashes exactly as "txn:<id>" does
+// (as long as the tenant and TransactionID contain no braces of their own).
+func (k TxnKey) resultKey() string {
+	return "{" + k.String() + "}:result"
+}
+
+// fingerprintKey holds the request fingerprint the transaction was first seen with.
+func (k TxnKey) fingerprintKey() string {
+	return k.String() + ":fingerprint"
+}
+
+// queuedKey marks a transaction as waiting in the payment queue.
+func (k TxnKey) queuedKey() string {
+	return k.String() + ":queued"
+}
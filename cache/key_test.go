@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+// clusterSlot is the Redis Cluster slot of key: CRC16 (XMODEM) of its hash tag,
+// or of the whole key without one, modulo 16384.
+func clusterSlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+func TestClusterSlot(t *testing.T) {
+	// Values from the Redis Cluster specification and CLUSTER KEYSLOT
+	if got := clusterSlot("123456789"); got != 0x31C3%16384 {
+		t.Errorf("slot %d, want %d", got, 0x31C3%16384)
+	}
+	if clusterSlot("{user1000}.following") != clusterSlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag in different slots")
+	}
+}
+
+func TestResultKeySharesTheStatusKeySlot(t *testing.T) {
+	keys := []TxnKey{
+		{TransactionID: "TXN-1"},
+		{TransactionID: "550e8400-e29b-41d4-a716-446655440000"},
+		{Tenant: "shop", TransactionID: "TXN-1"},
+		{Tenant: "other", TransactionID: "TXN-2"},
+	}
+	for _, key := range keys {
+		if status, result := clusterSlot(key.String()), clusterSlot(key.resultKey()); status != result {
+			t.Errorf("%s: status key in slot %d, result key %q in slot %d", key, status, key.resultKey(), result)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// memoryEntry is a single stored value with its expiry deadline.
+type memoryEntry struct {
+	value     string
+	stage     string // InProgressInfo.Stage of an IN_PROGRESS entry
+	result    *providers.PaymentResponse
+	expiresAt time.Time
+}
+
+// MemoryStore implements the IdempotencyStore interface in process memory.
+// It mirrors RedisStore's IN_PROGRESS/COMPLETED semantics and TTLs, and is meant
+// for tests and local development where running Redis is inconvenient.
+// State is not shared between instances, so it gives no cross-instance dedup.
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]memoryEntry
+	audit    map[string][]AuditRecord // Keyed like the Redis audit lists
+	queue    []QueuedPayment          // Deferred payments, oldest first
+	dead     []DeadLetter             // Dead letters, oldest failure first
+	usage    map[string]Usage         // Keyed like the Redis usage hashes
+	disabled map[string]bool          // Providers taken offline by an operator
+	opts     Options
+}
+
+// NewMemoryStore creates an empty in-memory store. Zero fields in opts use the default TTLs.
+func NewMemoryStore(opts Options) *MemoryStore {
+	return &MemoryStore{
+		entries:  make(map[string]memoryEntry),
+		audit:    make(map[string][]AuditRecord),
+		usage:    make(map[string]Usage),
+		disabled: make(map[string]bool),
+		opts:     opts.withDefaults(),
+	}
+}
+
+// get returns the live entry for key, evicting it if it has expired. Callers must hold m.mu.
+func (m *MemoryStore) get(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if !time.Now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+// CheckOrSetInProgress has the same contract as RedisStore.CheckOrSetInProgress.
+// The check and the set happen under one lock, which gives the same atomicity as the Redis script.
+func (m *MemoryStore) CheckOrSetInProgress(ctx context.Context, key TxnKey) (TxnState, error) {
+	return m.CheckOrSetInProgressWithInfo(ctx, key, InProgressInfo{})
+}
+
+// CheckOrSetInProgressWithInfo has the same contract as RedisStore.CheckOrSetInProgressWithInfo.
+// There is no external store to inspect here, so only info's Stage is kept.
+func (m *MemoryStore) CheckOrSetInProgressWithInfo(ctx context.Context, key TxnKey, info InProgressInfo) (TxnState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.get(key.String()); ok {
+		switch e.value {
+		case StatusCompleted:
+			return StateCompleted, nil
+		case StatusFailed:
+			return StateFailed, nil
+		case StatusCancelled:
+			return StateCancelled, nil
+		}
+		return StateInProgress, nil
+	}
+
+	m.entries[key.String()] = memoryEntry{value: StatusInProgress, stage: info.Stage, expiresAt: time.Now().Add(m.opts.InProgressTTL)}
+	return StateNew, nil
+}
+
+// SetCompleted sets the transaction status to COMPLETED with a long expiry.
+func (m *MemoryStore) SetCompleted(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key.String()] = memoryEntry{value: StatusCompleted, expiresAt: time.Now().Add(completedTTL(ctx, m.opts.CompletedTTL))}
+	return nil
+}
+
+// SetFailed has the same contract as RedisStore.SetFailed.
+func (m *MemoryStore) SetFailed(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *res
+	expiresAt := time.Now().Add(failedTTL(ctx, m.opts))
+	m.entries[key.resultKey()] = memoryEntry{result: &stored, expiresAt: expiresAt}
+	m.entries[key.String()] = memoryEntry{value: StatusFailed, expiresAt: expiresAt}
+	return nil
+}
+
+// CheckCompleted checks if a transaction is already set to COMPLETED.
+func (m *MemoryStore) CheckCompleted(ctx context.Context, key TxnKey) (bool, error) {
+	status, err := m.GetStatus(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return status == StatusCompleted, nil
+}
+
+// GetStatus returns the raw status stored for a transaction, or "" if there is none.
+func (m *MemoryStore) GetStatus(ctx context.Context, key TxnKey) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	if !ok {
+		return "", nil
+	}
+	return e.value, nil
+}
+
+// SetResult stores a copy of the successful PaymentResponse with the COMPLETED expiry.
+func (m *MemoryStore) SetResult(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *res
+	m.entries[key.resultKey()] = memoryEntry{result: &stored, expiresAt: time.Now().Add(completedTTL(ctx, m.opts.CompletedTTL))}
+	return nil
+}
+
+// GetResult returns a copy of the stored PaymentResponse, or (nil, nil) if there is none.
+func (m *MemoryStore) GetResult(ctx context.Context, key TxnKey) (*providers.PaymentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.resultKey())
+	if !ok || e.result == nil {
+		return nil, nil
+	}
+	res := *e.result
+	return &res, nil
+}
+
+// MatchFingerprint has the same contract as RedisStore.MatchFingerprint.
+func (m *MemoryStore) MatchFingerprint(ctx context.Context, key TxnKey, fingerprint string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.get(key.fingerprintKey()); ok {
+		return e.value == fingerprint, nil
+	}
+	m.entries[key.fingerprintKey()] = memoryEntry{value: fingerprint, expiresAt: time.Now().Add(completedTTL(ctx, m.opts.CompletedTTL))}
+	return true, nil
+}
+
+// Ping always succeeds; there is no remote backend to reach.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op: the store holds nothing beyond its own memory.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Delete removes a stuck IN_PROGRESS key (or a cached failure) and its fingerprint.
+// COMPLETED keys are never removed.
+func (m *MemoryStore) Delete(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	if !ok || (e.value != StatusInProgress && e.value != StatusFailed) {
+		return ErrNotInProgress
+	}
+	delete(m.entries, key.String())
+	delete(m.entries, key.fingerprintKey())
+	return nil
+}
+
+// RefreshInProgress has the same contract as RedisStore.RefreshInProgress.
+func (m *MemoryStore) RefreshInProgress(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	if !ok || e.value != StatusInProgress {
+		return ErrNotInProgress
+	}
+	e.expiresAt = time.Now().Add(m.opts.InProgressTTL)
+	m.entries[key.String()] = e
+	return nil
+}
+
+// LeaseRemaining has the same contract as RedisStore.LeaseRemaining.
+func (m *MemoryStore) LeaseRemaining(ctx context.Context, key TxnKey) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	if !ok {
+		return 0, nil
+	}
+	return time.Until(e.expiresAt), nil
+}
+
+// SetProviderDisabled records whether an operator has taken provider offline.
+func (m *MemoryStore) SetProviderDisabled(ctx context.Context, provider string, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if disabled {
+		m.disabled[provider] = true
+	} else {
+		delete(m.disabled, provider)
+	}
+	return nil
+}
+
+// IsProviderDisabled reports whether provider has been taken offline.
+func (m *MemoryStore) IsProviderDisabled(ctx context.Context, provider string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.disabled[provider], nil
+}
+
+// Cancel has the same contract as RedisStore.Cancel.
+func (m *MemoryStore) Cancel(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	switch {
+	case !ok:
+		return ErrNotInProgress
+	case e.value == StatusCancelled:
+		return nil
+	case e.value != StatusInProgress || e.stage != StagePending:
+		return ErrNotCancellable
+	}
+	m.entries[key.String()] = memoryEntry{value: StatusCancelled, expiresAt: time.Now().Add(completedTTL(ctx, m.opts.CompletedTTL))}
+	return nil
+}
+
+// Dispatch has the same contract as RedisStore.Dispatch.
+func (m *MemoryStore) Dispatch(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key.String())
+	switch {
+	case ok && e.value == StatusCancelled:
+		return ErrCancelled
+	case !ok || e.value != StatusInProgress || e.stage != StagePending:
+		return ErrNotInProgress
+	}
+	m.entries[key.String()] = memoryEntry{value: StatusInProgress, expiresAt: time.Now().Add(m.opts.InProgressTTL)}
+	return nil
+}
+
+// AddUsage counts one more payment of amount against provider's total for day.
+// Past days are never evicted; there is one small entry per provider per day.
+func (m *MemoryStore) AddUsage(ctx context.Context, provider, day string, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := usageKey(provider, day)
+	u := m.usage[key]
+	u.Count++
+	u.Amount += amount
+	m.usage[key] = u
+	return nil
+}
+
+// GetUsage returns provider's totals for day.
+func (m *MemoryStore) GetUsage(ctx context.Context, provider, day string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.usage[usageKey(provider, day)], nil
+}
+
+// AppendAudit appends a record to the in-memory list for its day.
+func (m *MemoryStore) AppendAudit(ctx context.Context, rec AuditRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := auditKey(rec.Timestamp)
+	m.audit[key] = append(m.audit[key], rec)
+	return nil
+}
+
+// ListAudit returns up to limit of the most recent records for a day, oldest first.
+func (m *MemoryStore) ListAudit(ctx context.Context, day time.Time, limit int) ([]AuditRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := m.audit[auditKey(day)]
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return append([]AuditRecord(nil), records...), nil
+}
+
+// Enqueue appends p to the in-memory payment queue.
+func (m *MemoryStore) Enqueue(ctx context.Context, p QueuedPayment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queue = append(m.queue, p)
+	return nil
+}
+
+// Requeue puts p back at the head of the queue.
+func (m *MemoryStore) Requeue(ctx context.Context, p QueuedPayment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queue = append([]QueuedPayment{p}, m.queue...)
+	return nil
+}
+
+// Dequeue removes and returns the oldest payment; (nil, nil) when the queue is empty.
+func (m *MemoryStore) Dequeue(ctx context.Context) (*QueuedPayment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.queue) == 0 {
+		return nil, nil
+	}
+	p := m.queue[0]
+	m.queue = m.queue[1:]
+	return &p, nil
+}
+
+// IsQueued reports whether the transaction is in the queue. The memory queue is
+// small enough to scan, so it keeps no separate marker.
+func (m *MemoryStore) IsQueued(ctx context.Context, key TxnKey) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.queue {
+		if p.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddDeadLetter stores d in place of any entry for the same transaction, dropping
+// the oldest entries beyond the limit.
+func (m *MemoryStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dead = slices.DeleteFunc(m.dead, func(e DeadLetter) bool { return e.Key == d.Key })
+	m.dead = append(m.dead, d)
+	if excess := len(m.dead) - m.opts.DeadLetterLimit; excess > 0 {
+		m.dead = slices.Delete(m.dead, 0, excess)
+	}
+	return nil
+}
+
+// ListDeadLetters returns up to limit entries, most recent failure first.
+func (m *MemoryStore) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, min(limit, len(m.dead)))
+	for i := len(m.dead) - 1; i >= 0 && len(letters) < limit; i-- {
+		letters = append(letters, m.dead[i])
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns the entry for key, or (nil, nil) when there is none.
+func (m *MemoryStore) GetDeadLetter(ctx context.Context, key TxnKey) (*DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := slices.IndexFunc(m.dead, func(e DeadLetter) bool { return e.Key == key }); i >= 0 {
+		d := m.dead[i]
+		return &d, nil
+	}
+	return nil, nil
+}
+
+// RemoveDeadLetter deletes the entry for key, if any.
+func (m *MemoryStore) RemoveDeadLetter(ctx context.Context, key TxnKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dead = slices.DeleteFunc(m.dead, func(e DeadLetter) bool { return e.Key == key })
+	return nil
+}
@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// ReadPolicy decides how MultiStore answers reads.
+type ReadPolicy int
+
+const (
+	// ReadFirstWins asks the stores in order and returns the first answer that isn't an error.
+	ReadFirstWins ReadPolicy = iota
+	// ReadQuorum asks every store, needs Quorum answers, and returns the most
+	// advanced of them (COMPLETED over IN_PROGRESS over unknown).
+	ReadQuorum
+)
+
+// ParseReadPolicy maps "first" (or "") and "quorum" to a ReadPolicy.
+func ParseReadPolicy(s string) (ReadPolicy, error) {
+	switch s {
+	case "", "first":
+		return ReadFirstWins, nil
+	case "quorum":
+		return ReadQuorum, nil
+	default:
+		return ReadFirstWins, fmt.Errorf("unknown read policy %q (want first or quorum)", s)
+	}
+}
+
+// MultiStore implements IdempotencyStore over several stores, e.g. the old and new
+// Redis during a migration. Writes go to every store concurrently and succeed once
+// Quorum of them do; stores that fail are logged and skipped. Reads follow Policy.
+// The first store is the primary: ReadFirstWins asks it before the others.
+type MultiStore struct {
+	stores []IdempotencyStore
+	quorum int
+	policy ReadPolicy
+}
+
+// NewMultiStore wraps stores (primary first). quorum is clamped to [1, len(stores)].
+func NewMultiStore(policy ReadPolicy, quorum int, stores ...IdempotencyStore) *MultiStore {
+	quorum = max(1, min(quorum, len(stores)))
+	return &MultiStore{stores: stores, quorum: quorum, policy: policy}
+}
+
+// fanOut runs fn against every store concurrently and returns the per-store errors,
+// indexed like m.stores. Failures are logged; an error is returned when fewer than
+// quorum stores succeeded.
+func (m *MultiStore) fanOut(ctx context.Context, op string, fn func(i int, s IdempotencyStore) error) ([]error, error) {
+	errs := make([]error, len(m.stores))
+	var wg sync.WaitGroup
+	for i, s := range m.stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, s)
+		}()
+	}
+	wg.Wait()
+
+	ok := 0
+	for i, err := range errs {
+		if err == nil {
+			ok++
+			continue
+		}
+		slog.WarnContext(ctx, "idempotency store partial failure", "op", op, "store", i, "error", err)
+	}
+	if ok < m.quorum {
+		return errs, fmt.Errorf("%s: %d of %d stores succeeded, need %d: %w", op, ok, len(m.stores), m.quorum, errors.Join(errs...))
+	}
+	return errs, nil
+}
+
+// CheckOrSetInProgress has the same contract as RedisStore.CheckOrSetInProgress.
+func (m *MultiStore) CheckOrSetInProgress(ctx context.Context, key TxnKey) (TxnState, error) {
+	return m.CheckOrSetInProgressWithInfo(ctx, key, InProgressInfo{})
+}
+
+// CheckOrSetInProgressWithInfo claims the transaction in every store and reports the
+// most advanced state any of them holds. If that isn't StateNew, the claims this call
+// just made are released again so they don't linger until the lease expires.
+func (m *MultiStore) CheckOrSetInProgressWithInfo(ctx context.Context, key TxnKey, info InProgressInfo) (TxnState, error) {
+	states := make([]TxnState, len(m.stores))
+	errs, err := m.fanOut(ctx, "claim", func(i int, s IdempotencyStore) error {
+		var err error
+		states[i], err = s.CheckOrSetInProgressWithInfo(ctx, key, info)
+		return err
+	})
+	if err != nil {
+		return StateNew, err
+	}
+
+	state := StateNew
+	for i, st := range states {
+		if errs[i] == nil && st > state {
+			state = st
+		}
+	}
+	if state != StateNew {
+		for i, st := range states {
+			if errs[i] == nil && st == StateNew {
+				if err := m.stores[i].Delete(ctx, key); err != nil {
+					slog.WarnContext(ctx, "failed to release redundant claim", "store", i, "error", err)
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+// SetCompleted marks the transaction COMPLETED in every store.
+func (m *MultiStore) SetCompleted(ctx context.Context, key TxnKey) error {
+	_, err := m.fanOut(ctx, "set completed", func(_ int, s IdempotencyStore) error {
+		return s.SetCompleted(ctx, key)
+	})
+	return err
+}
+
+// SetFailed caches the failure in every store.
+func (m *MultiStore) SetFailed(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+	_, err := m.fanOut(ctx, "set failed", func(_ int, s IdempotencyStore) error {
+		return s.SetFailed(ctx, key, res)
+	})
+	return err
+}
+
+// CheckCompleted checks if a transaction is already set to COMPLETED.
+func (m *MultiStore) CheckCompleted(ctx context.Context, key TxnKey) (bool, error) {
+	status, err := m.GetStatus(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return status == StatusCompleted, nil
+}
+
+// GetStatus returns the status per the read policy; "" if no store knows the transaction.
+func (m *MultiStore) GetStatus(ctx context.Context, key TxnKey) (string, error) {
+	if m.policy == ReadFirstWins {
+		var errs []error
+		for i, s := range m.stores {
+			status, err := s.GetStatus(ctx, key)
+			if err == nil {
+				return status, nil
+			}
+			slog.WarnContext(ctx, "idempotency store partial failure", "op", "get status", "store", i, "error", err)
+			errs = append(errs, err)
+		}
+		return "", fmt.Errorf("get status: no store answered: %w", errors.Join(errs...))
+	}
+
+	statuses := make([]string, len(m.stores))
+	errs, err := m.fanOut(ctx, "get status", func(i int, s IdempotencyStore) error {
+		var err error
+		statuses[i], err = s.GetStatus(ctx, key)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	best := ""
+	for i, status := range statuses {
+		if errs[i] != nil {
+			continue
+		}
+		if status == StatusCompleted || (status != "" && best == "") {
+			best = status
+		}
+	}
+	return best, nil
+}
+
+// SetResult stores the result in every store.
+func (m *MultiStore) SetResult(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+	_, err := m.fanOut(ctx, "set result", func(_ int, s IdempotencyStore) error {
+		return s.SetResult(ctx, key, res)
+	})
+	return err
+}
+
+// GetResult returns the stored result per the read policy, or (nil, nil) if no store has one.
+func (m *MultiStore) GetResult(ctx context.Context, key TxnKey) (*providers.PaymentResponse, error) {
+	if m.policy == ReadFirstWins {
+		var errs []error
+		for i, s := range m.stores {
+			res, err := s.GetResult(ctx, key)
+			if err == nil {
+				return res, nil
+			}
+			slog.WarnContext(ctx, "idempotency store partial failure", "op", "get result", "store", i, "error", err)
+			errs = append(errs, err)
+		}
+		return nil, fmt.Errorf("get result: no store answered: %w", errors.Join(errs...))
+	}
+
+	results := make([]*providers.PaymentResponse, len(m.stores))
+	if _, err := m.fanOut(ctx, "get result", func(i int, s IdempotencyStore) error {
+		var err error
+		results[i], err = s.GetResult(ctx, key)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	for _, res := range results {
+		if res != nil {
+			return res, nil
+		}
+	}
+	return nil, nil
+}
+
+// MatchFingerprint records or compares the fingerprint in every store; a mismatch
+// in any of them is a mismatch.
+func (m *MultiStore) MatchFingerprint(ctx context.Context, key TxnKey, fingerprint string) (bool, error) {
+	matched := make([]bool, len(m.stores))
+	errs, err := m.fanOut(ctx, "match fingerprint", func(i int, s IdempotencyStore) error {
+		var err error
+		matched[i], err = s.MatchFingerprint(ctx, key, fingerprint)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for i, ok := range matched {
+		if errs[i] == nil && !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Ping succeeds when at least Quorum stores are reachable.
+func (m *MultiStore) Ping(ctx context.Context) error {
+	_, err := m.fanOut(ctx, "ping", func(_ int, s IdempotencyStore) error {
+		return s.Ping(ctx)
+	})
+	return err
+}
+
+// Delete removes the IN_PROGRESS key from every store that holds one. It returns
+// ErrNotInProgress only if no store did.
+func (m *MultiStore) Delete(ctx context.Context, key TxnKey) error {
+	deleted := make([]bool, len(m.stores))
+	if _, err := m.fanOut(ctx, "delete", func(i int, s IdempotencyStore) error {
+		err := s.Delete(ctx, key)
+		if errors.Is(err, ErrNotInProgress) {
+			return nil // An answer, not a failure
+		}
+		deleted[i] = err == nil
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, d := range deleted {
+		if d {
+			return nil
+		}
+	}
+	return ErrNotInProgress
+}
+
+// RefreshInProgress extends the IN_PROGRESS lease in every store that holds one. It
+// returns ErrNotInProgress only if no store did.
+func (m *MultiStore) RefreshInProgress(ctx context.Context, key TxnKey) error {
+	refreshed := make([]bool, len(m.stores))
+	if _, err := m.fanOut(ctx, "refresh", func(i int, s IdempotencyStore) error {
+		err := s.RefreshInProgress(ctx, key)
+		if errors.Is(err, ErrNotInProgress) {
+			return nil // An answer, not a failure
+		}
+		refreshed[i] = err == nil
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, r := range refreshed {
+		if r {
+			return nil
+		}
+	}
+	return ErrNotInProgress
+}
+
+// LeaseRemaining returns the longest time left on the key in any store, since the
+// transaction stays blocked until every store lets it go.
+func (m *MultiStore) LeaseRemaining(ctx context.Context, key TxnKey) (time.Duration, error) {
+	remaining := make([]time.Duration, len(m.stores))
+	if _, err := m.fanOut(ctx, "lease remaining", func(i int, s IdempotencyStore) error {
+		var err error
+		remaining[i], err = s.LeaseRemaining(ctx, key)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	for _, d := range remaining {
+		longest = max(longest, d)
+	}
+	return longest, nil
+}
+
+// Cancel cancels the transaction in every store that holds it pending. It succeeds
+// if any store did; otherwise it returns ErrNotCancellable if some store holds the
+// transaction past pending, else ErrNotInProgress.
+func (m *MultiStore) Cancel(ctx context.Context, key TxnKey) error {
+	cancelled := make([]bool, len(m.stores))
+	tooLate := make([]bool, len(m.stores))
+	if _, err := m.fanOut(ctx, "cancel", func(i int, s IdempotencyStore) error {
+		err := s.Cancel(ctx, key)
+		switch {
+		case errors.Is(err, ErrNotInProgress):
+			return nil // An answer, not a failure
+		case errors.Is(err, ErrNotCancellable):
+			tooLate[i] = true
+			return nil
+		}
+		cancelled[i] = err == nil
+		return err
+	}); err != nil {
+		return err
+	}
+	switch {
+	case slices.Contains(cancelled, true):
+		return nil
+	case slices.Contains(tooLate, true):
+		return ErrNotCancellable
+	}
+	return ErrNotInProgress
+}
+
+// Dispatch ends the pending stage in every store. A cancellation recorded in any
+// store wins, so a cancelled payment never reaches a provider.
+func (m *MultiStore) Dispatch(ctx context.Context, key TxnKey) error {
+	dispatched := make([]bool, len(m.stores))
+	cancelled := make([]bool, len(m.stores))
+	if _, err := m.fanOut(ctx, "dispatch", func(i int, s IdempotencyStore) error {
+		err := s.Dispatch(ctx, key)
+		switch {
+		case errors.Is(err, ErrNotInProgress):
+			return nil // An answer, not a failure
+		case errors.Is(err, ErrCancelled):
+			cancelled[i] = true
+			return nil
+		}
+		dispatched[i] = err == nil
+		return err
+	}); err != nil {
+		return err
+	}
+	switch {
+	case slices.Contains(cancelled, true):
+		return ErrCancelled
+	case slices.Contains(dispatched, true):
+		return nil
+	}
+	return ErrNotInProgress
+}
+
+// Close closes every underlying store, even if some of them fail.
+func (m *MultiStore) Close() error {
+	var errs []error
+	for _, s := range m.stores {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
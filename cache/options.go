@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Options tunes how long idempotency keys live and how much the stores keep. Zero
+// fields fall back to the package defaults (InProgressExpiry, CompletedExpiry,
+// FailedExpiry and DefaultDeadLetterLimit).
+type Options struct {
+	// InProgressTTL is the lease on an IN_PROGRESS key; it should outlast the
+	// slowest provider call (retries included) so a live payment can't be re-run.
+	InProgressTTL time.Duration
+	// CompletedTTL is how long COMPLETED keys and stored results are kept for replay.
+	CompletedTTL time.Duration
+	// FailedTTL is how long a failure stored with SetFailed (and its result) is replayed.
+	FailedTTL time.Duration
+	// DeadLetterLimit is the most entries the DeadLetterStore keeps (DefaultDeadLetterLimit when zero).
+	DeadLetterLimit int
+
+	// Redis command retries on network errors, with exponential backoff between
+	// MinRetryBackoff and MaxRetryBackoff. Zero keeps the go-redis defaults
+	// (3 retries, 8ms-512ms) and -1 disables retries or backoff.
+	// Retries never outlive the caller's context: once the request's deadline
+	// passes the command fails with the context error, so a handler's timeout
+	// bounds the total time spent retrying. Keep MaxRetries*MaxRetryBackoff well
+	// under the provider timeout or retries will be cut short.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+type completedTTLKey struct{}
+
+// WithCompletedTTL overrides Options.CompletedTTL for everything stored with the
+// returned context: the COMPLETED key, the result and the fingerprint. It lets a
+// client choose how long its own transaction is replayed.
+func WithCompletedTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, completedTTLKey{}, ttl)
+}
+
+type terminalFailureKey struct{}
+
+// WithTerminalFailure makes SetFailed keep the failure for as long as a completed
+// transaction is kept (CompletedTTL, or the WithCompletedTTL override) instead of
+// FailedTTL. It is for payments whose outcome the client can only learn by polling.
+func WithTerminalFailure(ctx context.Context) context.Context {
+	return context.WithValue(ctx, terminalFailureKey{}, true)
+}
+
+// failedTTL returns how long SetFailed keeps a failure stored with ctx, given the
+// store's options.
+func failedTTL(ctx context.Context, opts Options) time.Duration {
+	if terminal, _ := ctx.Value(terminalFailureKey{}).(bool); terminal {
+		return completedTTL(ctx, opts.CompletedTTL)
+	}
+	return opts.FailedTTL
+}
+
+// completedTTL returns the WithCompletedTTL override in ctx, or def when there is none.
+func completedTTL(ctx context.Context, def time.Duration) time.Duration {
+	if ttl, ok := ctx.Value(completedTTLKey{}).(time.Duration); ok && ttl > 0 {
+		return ttl
+	}
+	return def
+}
+
+// withDefaults returns o with zero fields replaced by the package defaults.
+func (o Options) withDefaults() Options {
+	if o.InProgressTTL <= 0 {
+		o.InProgressTTL = InProgressExpiry
+	}
+	if o.CompletedTTL <= 0 {
+		o.CompletedTTL = CompletedExpiry
+	}
+	if o.FailedTTL <= 0 {
+		o.FailedTTL = FailedExpiry
+	}
+	if o.DeadLetterLimit <= 0 {
+		o.DeadLetterLimit = DefaultDeadLetterLimit
+	}
+	return o
+}
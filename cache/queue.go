@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// QueuedMarkerTTL bounds how long a transaction reports as QUEUED, so a marker
+// whose payment was lost (e.g. the queue was flushed) doesn't linger forever.
+const QueuedMarkerTTL = 24 * time.Hour
+
+// paymentQueueKey is the Redis list holding deferred payments, oldest first.
+const paymentQueueKey = "queue:payments"
+
+// QueuedPayment is a payment accepted while no provider could take it, waiting
+// to be processed once one recovers.
+type QueuedPayment struct {
+	Key        TxnKey                   `json:"key"`
+	Request    providers.PaymentRequest `json:"request"`
+	RequestID  string                   `json:"requestID,omitempty"` // Correlates the eventual processing with the original request
+	EnqueuedAt time.Time                `json:"enqueuedAt"`
+}
+
+// PaymentQueue holds deferred payments, first in, first out. It is only a holding
+// area: whoever drains it must still run each payment through the idempotency
+// store, since the same transaction may be queued twice or retried meanwhile.
+type PaymentQueue interface {
+	Enqueue(ctx context.Context, p QueuedPayment) error
+	// Dequeue removes and returns the oldest payment, or (nil, nil) when the queue is empty.
+	Dequeue(ctx context.Context) (*QueuedPayment, error)
+	// Requeue puts p back at the head, for a payment that still couldn't be processed.
+	Requeue(ctx context.Context, p QueuedPayment) error
+	// IsQueued reports whether the transaction is waiting in the queue.
+	IsQueued(ctx context.Context, key TxnKey) (bool, error)
+}
@@ -2,10 +2,15 @@ package cache
 
 import (
     "context"
+    "encoding/json"
     "errors"
     "fmt"
+    "strconv"
+    "strings"
     "time"
 
+    "payment-gateway-aggregator/providers"
+
     "github.com/redis/go-redis/v9"
 )
 
@@ -13,86 +18,744 @@ import (
 const (
     StatusInProgress = "IN_PROGRESS"
     StatusCompleted  = "COMPLETED"
-    // Use a short, meaningful expiration for the "IN_PROGRESS" key
+    // A definite failure cached for a short while (see SetFailed)
+    StatusFailed     = "FAILED"
+    // Reported for a transaction waiting in the PaymentQueue (never an idempotency state)
+    StatusQueued     = "QUEUED"
+    // An async payment cancelled before it reached a provider (see Cancel)
+    StatusCancelled  = "CANCELLED"
+    // Default expiration for the "IN_PROGRESS" key (see Options.InProgressTTL)
     InProgressExpiry = 10 * time.Second 
-    // Use a long, meaningful expiry for the "COMPLETED" key
+    // Default expiry for the "COMPLETED" key (see Options.CompletedTTL)
     CompletedExpiry  = 24 * time.Hour 
+    // Default expiry for a cached "FAILED" key (see Options.FailedTTL)
+    FailedExpiry     = 30 * time.Second
+)
+
+// InProgressInfo is stored as the IN_PROGRESS value (as JSON) so anyone inspecting
+// Redis can see what a pending transaction is doing, not just that it exists.
+type InProgressInfo struct {
+    Status    string    `json:"status"` // Always StatusInProgress
+    Stage     string    `json:"stage,omitempty"` // StagePending until a provider may be called
+    Provider  string    `json:"provider,omitempty"`
+    Amount    float64   `json:"amount,omitempty"`
+    Currency  string    `json:"currency,omitempty"`
+    StartedAt time.Time `json:"startedAt"`
+}
+
+// StagePending marks an IN_PROGRESS transaction that was accepted but not yet
+// handed to a provider (an async payment waiting for a worker). Only a pending
+// transaction can be cancelled; Dispatch ends the stage.
+const StagePending = "PENDING"
+
+// parseStatus returns the status held in a stored value, which is either a bare
+// status constant or an InProgressInfo JSON blob.
+func parseStatus(value string) string {
+    if strings.HasPrefix(value, "{") {
+        var info InProgressInfo
+        if err := json.Unmarshal([]byte(value), &info); err == nil {
+            return info.Status
+        }
+    }
+    return value
+}
+
+// TxnState is the outcome of claiming a transaction with CheckOrSetInProgress.
+type TxnState int
+
+const (
+    StateNew        TxnState = iota // Unknown until now; the caller holds the IN_PROGRESS key
+    StateInProgress                 // Another call is processing it
+    StateCompleted                  // Already finished successfully
+    StateFailed                     // Failed recently, and the failure is cached (see SetFailed)
+    StateCancelled                  // Cancelled before it reached a provider (see Cancel)
 )
 
+func (s TxnState) String() string {
+    switch s {
+    case StateInProgress:
+        return StatusInProgress
+    case StateCompleted:
+        return StatusCompleted
+    case StateFailed:
+        return StatusFailed
+    case StateCancelled:
+        return StatusCancelled
+    default:
+        return "NEW"
+    }
+}
+
+// claimScript returns a TxnState: 2 if the key holds COMPLETED, 3 if it holds FAILED,
+// 4 if it holds CANCELLED, 1 if it holds anything else (IN_PROGRESS), and 0 after
+// setting it to the IN_PROGRESS value.
+// KEYS[1] = txn key, ARGV[1] = COMPLETED, ARGV[2] = IN_PROGRESS value, ARGV[3] = TTL (ms),
+// ARGV[4] = FAILED, ARGV[5] = CANCELLED.
+var claimScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value == ARGV[1] then
+    return 2
+end
+if value == ARGV[4] then
+    return 3
+end
+if value == ARGV[5] then
+    return 4
+end
+if value then
+    return 1
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 0
+`)
+
+// ErrNotInProgress is returned by Delete and RefreshInProgress when the transaction has no IN_PROGRESS key
+// (it is unknown, expired, or already COMPLETED).
+var ErrNotInProgress = errors.New("transaction is not in progress")
+
+// deleteInProgressScript deletes a key only while it still holds IN_PROGRESS (or a
+// cached FAILED), so a transaction that completes concurrently can never be cleared.
+// The value may be the bare status or an InProgressInfo JSON blob.
+// KEYS[1] = txn key, ARGV[1] = IN_PROGRESS, ARGV[2] = FAILED.
+var deleteInProgressScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+    return 0
+end
+if value == ARGV[2] then
+    return redis.call("DEL", KEYS[1])
+end
+if value == ARGV[1] or (string.sub(value, 1, 1) == "{" and cjson.decode(value).status == ARGV[1]) then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// refreshInProgressScript resets the expiry of a key only while it still holds
+// IN_PROGRESS, so a COMPLETED key never has its long expiry cut short.
+// KEYS[1] = txn key, ARGV[1] = IN_PROGRESS, ARGV[2] = TTL (ms).
+var refreshInProgressScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+    return 0
+end
+if value == ARGV[1] or (string.sub(value, 1, 1) == "{" and cjson.decode(value).status == ARGV[1]) then
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// ErrNotCancellable is returned by Cancel when the transaction is past the point of
+// cancelling: a provider may already have it, or it has finished.
+var ErrNotCancellable = errors.New("transaction can no longer be cancelled")
+
+// ErrCancelled is returned by Dispatch when the transaction was cancelled while it waited.
+var ErrCancelled = errors.New("transaction was cancelled")
+
+// cancelScript replaces a pending IN_PROGRESS value with CANCELLED. It returns 1 once
+// the key holds CANCELLED (already so included), 2 if the key holds anything else,
+// and 0 if there is no key.
+// KEYS[1] = txn key, ARGV[1] = IN_PROGRESS, ARGV[2] = PENDING, ARGV[3] = CANCELLED, ARGV[4] = TTL (ms).
+var cancelScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+    return 0
+end
+if value == ARGV[3] then
+    return 1
+end
+if string.sub(value, 1, 1) == "{" then
+    local info = cjson.decode(value)
+    if info.status == ARGV[1] and info.stage == ARGV[2] then
+        redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+        return 1
+    end
+end
+return 2
+`)
+
+// dispatchScript ends the pending stage of an IN_PROGRESS value, renewing its lease.
+// It returns 1 if it did, 2 if the key holds CANCELLED, and 0 otherwise.
+// KEYS[1] = txn key, ARGV[1] = IN_PROGRESS, ARGV[2] = PENDING, ARGV[3] = CANCELLED, ARGV[4] = TTL (ms).
+var dispatchScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value == ARGV[3] then
+    return 2
+end
+if value and string.sub(value, 1, 1) == "{" then
+    local info = cjson.decode(value)
+    if info.status == ARGV[1] and info.stage == ARGV[2] then
+        info.stage = nil
+        redis.call("SET", KEYS[1], cjson.encode(info), "PX", ARGV[4])
+        return 1
+    end
+end
+return 0
+`)
+
+// matchFingerprintScript stores ARGV[1] as the fingerprint if none is held yet, and
+// returns 1 if the held fingerprint matches ARGV[1] (always so on first use), 0 if not.
+// KEYS[1] = fingerprint key, ARGV[1] = fingerprint, ARGV[2] = TTL (ms).
+var matchFingerprintScript = redis.NewScript(`
+local held = redis.call("GET", KEYS[1])
+if not held then
+    redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+    return 1
+end
+if held == ARGV[1] then
+    return 1
+end
+return 0
+`)
+
+// addDeadLetterScript stores an entry and its place in the index, then trims the
+// oldest entries beyond the limit and refreshes the retention.
+// KEYS[1] = index, KEYS[2] = entries, ARGV[1] = transaction key, ARGV[2] = failure
+// time (ms), ARGV[3] = entry JSON, ARGV[4] = limit, ARGV[5] = retention (ms).
+var addDeadLetterScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[3])
+local excess = redis.call("ZCARD", KEYS[1]) - tonumber(ARGV[4])
+if excess > 0 then
+    local oldest = redis.call("ZRANGE", KEYS[1], 0, excess - 1)
+    redis.call("ZREMRANGEBYRANK", KEYS[1], 0, excess - 1)
+    redis.call("HDEL", KEYS[2], unpack(oldest))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+redis.call("PEXPIRE", KEYS[2], ARGV[5])
+return 1
+`)
+
 // IdempotencyStore interface defines the required methods for our cache layer.
 type IdempotencyStore interface {
-    CheckOrSetInProgress(ctx context.Context, transactionID string) (bool, error)
-    SetCompleted(ctx context.Context, transactionID string) error
-    CheckCompleted(ctx context.Context, transactionID string) (bool, error)
+    CheckOrSetInProgress(ctx context.Context, key TxnKey) (TxnState, error)
+    CheckOrSetInProgressWithInfo(ctx context.Context, key TxnKey, info InProgressInfo) (TxnState, error)
+    SetCompleted(ctx context.Context, key TxnKey) error
+    SetFailed(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error
+    CheckCompleted(ctx context.Context, key TxnKey) (bool, error)
+    GetStatus(ctx context.Context, key TxnKey) (string, error)
+    Ping(ctx context.Context) error
+    Delete(ctx context.Context, key TxnKey) error
+    RefreshInProgress(ctx context.Context, key TxnKey) error
+    LeaseRemaining(ctx context.Context, key TxnKey) (time.Duration, error)
+    Cancel(ctx context.Context, key TxnKey) error
+    Dispatch(ctx context.Context, key TxnKey) error
+    SetResult(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error
+    GetResult(ctx context.Context, key TxnKey) (*providers.PaymentResponse, error)
+    MatchFingerprint(ctx context.Context, key TxnKey, fingerprint string) (bool, error)
+    Close() error
 }
 
 // RedisStore implements the IdempotencyStore interface.
+// It works the same against a single node, a Sentinel-managed master, or a Cluster.
 type RedisStore struct {
-    client *redis.Client
+    client redis.UniversalClient
+    opts   Options
 }
 
-// NewRedisStore creates a new Redis client instance.
-func NewRedisStore(addr string, password string, db int) *RedisStore {
+// NewRedisStore creates a new Redis client instance. Zero fields in opts use the default TTLs.
+func NewRedisStore(addr string, password string, db int, opts Options) *RedisStore {
     rdb := redis.NewClient(&redis.Options{
         Addr:     addr,     // e.g., "localhost:6379"
         Password: password, // no password set
         DB:       db,       // use default DB
+
+        MaxRetries:      opts.MaxRetries,
+        MinRetryBackoff: opts.MinRetryBackoff,
+        MaxRetryBackoff: opts.MaxRetryBackoff,
     })
 
-    // In a production environment, you would add logic here to check the connection (rdb.Ping).
-    
+    // The connection is established lazily; callers should Ping at startup to fail fast.
     return &RedisStore{
         client: rdb,
+        opts:   opts.withDefaults(),
     }
 }
 
-// CheckOrSetInProgress checks if a transaction is already COMPLETED or sets it to IN_PROGRESS.
-// Returns (true, nil) if the transaction is a duplicate (COMPLETED or IN_PROGRESS by another call).
-// Returns (false, nil) if the transaction is new and is now marked as IN_PROGRESS.
-// The IN_PROGRESS state uses a short timeout (10s) to prevent deadlocks if the server crashes.
-func (r *RedisStore) CheckOrSetInProgress(ctx context.Context, transactionID string) (bool, error) {
-    key := fmt.Sprintf("txn:%s", transactionID)
+// NewRedisSentinelStore connects to the master named masterName, discovered through
+// the given Sentinel addresses, and follows failovers automatically.
+func NewRedisSentinelStore(masterName string, sentinelAddrs []string, password string, db int, opts Options) *RedisStore {
+    rdb := redis.NewFailoverClient(&redis.FailoverOptions{
+        MasterName:    masterName,
+        SentinelAddrs: sentinelAddrs,
+        Password:      password,
+        DB:            db,
+
+        MaxRetries:      opts.MaxRetries,
+        MinRetryBackoff: opts.MinRetryBackoff,
+        MaxRetryBackoff: opts.MaxRetryBackoff,
+    })
 
-    // Check if the transaction is already COMPLETED
-    completedStatus, err := r.client.Get(ctx, key).Result()
-    if err == nil && completedStatus == StatusCompleted {
-        // Already completed, this is a duplicate request
-        return true, nil
+    // Lazy like NewRedisStore; Ping at startup to fail fast.
+    return &RedisStore{
+        client: rdb,
+        opts:   opts.withDefaults(),
     }
+}
+
+// NewRedisClusterStore connects to a Redis Cluster through any of the seed addrs.
+// Commands that touch several keys at once rely on hash tags to keep them in one
+// slot: a transaction's status and result (see TxnKey.resultKey) and the dead letters.
+func NewRedisClusterStore(addrs []string, password string, opts Options) *RedisStore {
+    rdb := redis.NewClusterClient(&redis.ClusterOptions{
+        Addrs:    addrs,
+        Password: password,
+
+        MaxRetries:      opts.MaxRetries,
+        MinRetryBackoff: opts.MinRetryBackoff,
+        MaxRetryBackoff: opts.MaxRetryBackoff,
+    })
 
-    // Try to set the key to IN_PROGRESS using SET NX (Set if Not eXists)
-    // This atomically checks and sets the value, which is crucial for concurrency.
-    set, err := r.client.SetNX(ctx, key, StatusInProgress, InProgressExpiry).Result()
+    // Lazy like NewRedisStore; Ping at startup to fail fast.
+    return &RedisStore{
+        client: rdb,
+        opts:   opts.withDefaults(),
+    }
+}
+
+// CheckOrSetInProgress atomically claims a transaction. It returns StateNew if the
+// transaction was unknown and is now marked IN_PROGRESS (the caller owns it),
+// StateInProgress if another call holds it, StateCompleted if it already finished,
+// or StateFailed if it failed recently and the failure was cached with SetFailed.
+// The IN_PROGRESS state uses a short timeout (10s by default) to prevent deadlocks if the server crashes.
+func (r *RedisStore) CheckOrSetInProgress(ctx context.Context, key TxnKey) (TxnState, error) {
+    return r.CheckOrSetInProgressWithInfo(ctx, key, InProgressInfo{})
+}
+
+// CheckOrSetInProgressWithInfo behaves like CheckOrSetInProgress, but stores info
+// (provider, amount, ...) as the IN_PROGRESS value.
+func (r *RedisStore) CheckOrSetInProgressWithInfo(ctx context.Context, key TxnKey, info InProgressInfo) (TxnState, error) {
+    info.Status = StatusInProgress
+    if info.StartedAt.IsZero() {
+        info.StartedAt = time.Now().UTC()
+    }
+    value, err := json.Marshal(info)
     if err != nil {
-        return false, fmt.Errorf("redis SETNX error: %w", err)
+        return StateNew, fmt.Errorf("encoding in-progress info: %w", err)
     }
 
-    if !set {
-        // The key already existed (it was IN_PROGRESS by another goroutine/call)
-        return true, errors.New("transaction already in progress")
+    // The COMPLETED check and the IN_PROGRESS set run as one script, so a transaction
+    // completing concurrently is always reported as COMPLETED, never as IN_PROGRESS.
+    state, err := claimScript.Run(ctx, r.client, []string{key.String()},
+        StatusCompleted, value, r.opts.InProgressTTL.Milliseconds(), StatusFailed, StatusCancelled).Int()
+    if err != nil {
+        return StateNew, fmt.Errorf("redis claim error: %w", err)
     }
+    return TxnState(state), nil
+}
 
-    // Key was successfully set, this is a new, valid transaction
-    return false, nil
+// SetCompleted sets the transaction status to COMPLETED with a long expiry (24h by default).
+func (r *RedisStore) SetCompleted(ctx context.Context, key TxnKey) error {
+    return r.client.Set(ctx, key.String(), StatusCompleted, completedTTL(ctx, r.opts.CompletedTTL)).Err()
 }
 
-// SetCompleted sets the transaction status to COMPLETED with a long expiry.
-func (r *RedisStore) SetCompleted(ctx context.Context, transactionID string) error {
-    key := fmt.Sprintf("txn:%s", transactionID)
-    return r.client.Set(ctx, key, StatusCompleted, CompletedExpiry).Err()
+// SetFailed caches a definite failure: res is stored and the status set to FAILED,
+// both with the short FailedTTL (or, under WithTerminalFailure, the completed TTL),
+// so retries within that window replay the failure instead of calling the provider
+// again. Afterwards the transaction can be retried.
+func (r *RedisStore) SetFailed(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+    data, err := json.Marshal(res)
+    if err != nil {
+        return fmt.Errorf("encoding result: %w", err)
+    }
+
+    // Result and status in one round trip; the result goes first so a replay always finds it
+    ttl := failedTTL(ctx, r.opts)
+    pipe := r.client.TxPipeline()
+    pipe.Set(ctx, key.resultKey(), data, ttl)
+    pipe.Set(ctx, key.String(), StatusFailed, ttl)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("redis SET error: %w", err)
+    }
+    return nil
 }
 
 // CheckCompleted checks if a transaction is already set to COMPLETED.
-func (r *RedisStore) CheckCompleted(ctx context.Context, transactionID string) (bool, error) {
-    key := fmt.Sprintf("txn:%s", transactionID)
-    status, err := r.client.Get(ctx, key).Result()
+func (r *RedisStore) CheckCompleted(ctx context.Context, key TxnKey) (bool, error) {
+    status, err := r.GetStatus(ctx, key)
+    if err != nil {
+        return false, err
+    }
+
+    return status == StatusCompleted, nil
+}
+
+// SetResult stores the successful PaymentResponse as JSON so it can be replayed verbatim
+// to any retry of the same transaction. It shares the long COMPLETED expiry.
+func (r *RedisStore) SetResult(ctx context.Context, key TxnKey, res *providers.PaymentResponse) error {
+    data, err := json.Marshal(res)
+    if err != nil {
+        return fmt.Errorf("encoding result: %w", err)
+    }
+    return r.client.Set(ctx, key.resultKey(), data, completedTTL(ctx, r.opts.CompletedTTL)).Err()
+}
+
+// GetResult returns the stored PaymentResponse for a completed transaction.
+// Returns (nil, nil) if no result has been stored.
+func (r *RedisStore) GetResult(ctx context.Context, key TxnKey) (*providers.PaymentResponse, error) {
+    data, err := r.client.Get(ctx, key.resultKey()).Bytes()
 
     if err == redis.Nil {
-        return false, nil // Key not found (not completed)
+        return nil, nil // No stored result
     }
     if err != nil {
-        return false, fmt.Errorf("redis GET error: %w", err)
+        return nil, fmt.Errorf("redis GET error: %w", err)
     }
-    
-    return status == StatusCompleted, nil
-}
\ No newline at end of file
+
+    var res providers.PaymentResponse
+    if err := json.Unmarshal(data, &res); err != nil {
+        return nil, fmt.Errorf("decoding result: %w", err)
+    }
+    return &res, nil
+}
+
+// MatchFingerprint records fingerprint as the request parameters of the transaction the
+// first time it is called, and afterwards reports whether fingerprint matches that first
+// call. The fingerprint is kept for the COMPLETED expiry, so a TransactionID can't be
+// reused for a different payment while it can still be replayed.
+func (r *RedisStore) MatchFingerprint(ctx context.Context, key TxnKey, fingerprint string) (bool, error) {
+    matched, err := matchFingerprintScript.Run(ctx, r.client, []string{key.fingerprintKey()},
+        fingerprint, completedTTL(ctx, r.opts.CompletedTTL).Milliseconds()).Int()
+    if err != nil {
+        return false, fmt.Errorf("redis fingerprint error: %w", err)
+    }
+    return matched == 1, nil
+}
+
+// GetStatus returns the status stored for a transaction (IN_PROGRESS or COMPLETED)
+// using a single GET, so callers can tell IN_PROGRESS, COMPLETED and missing apart.
+// Returns ("", nil) if no key exists for the transaction.
+func (r *RedisStore) GetStatus(ctx context.Context, key TxnKey) (string, error) {
+    status, err := r.client.Get(ctx, key.String()).Result()
+
+    if err == redis.Nil {
+        return "", nil // Key not found (unknown transaction)
+    }
+    if err != nil {
+        return "", fmt.Errorf("redis GET error: %w", err)
+    }
+
+    return parseStatus(status), nil
+}
+
+// Ping checks that the Redis server is reachable.
+func (r *RedisStore) Ping(ctx context.Context) error {
+    if err := r.client.Ping(ctx).Err(); err != nil {
+        return fmt.Errorf("redis PING error: %w", err)
+    }
+    return nil
+}
+
+// Close releases the underlying Redis connection pool.
+func (r *RedisStore) Close() error {
+    return r.client.Close()
+}
+
+// Delete removes a stuck IN_PROGRESS key (or a cached failure) so the transaction can
+// be retried immediately, along with its fingerprint so the retry may carry corrected
+// parameters. COMPLETED keys are never removed; ErrNotInProgress is returned instead.
+func (r *RedisStore) Delete(ctx context.Context, key TxnKey) error {
+    deleted, err := deleteInProgressScript.Run(ctx, r.client, []string{key.String()}, StatusInProgress, StatusFailed).Int()
+    if err != nil {
+        return fmt.Errorf("redis DELETE error: %w", err)
+    }
+    if deleted == 0 {
+        return ErrNotInProgress
+    }
+    if err := r.client.Del(ctx, key.fingerprintKey()).Err(); err != nil {
+        return fmt.Errorf("redis DELETE error: %w", err)
+    }
+    return nil
+}
+
+// RefreshInProgress extends the lease on an IN_PROGRESS key to a full InProgressTTL
+// from now, for a payment still being worked on. It returns ErrNotInProgress if the
+// key has expired or already moved on to COMPLETED.
+func (r *RedisStore) RefreshInProgress(ctx context.Context, key TxnKey) error {
+    refreshed, err := refreshInProgressScript.Run(ctx, r.client, []string{key.String()},
+        StatusInProgress, r.opts.InProgressTTL.Milliseconds()).Int()
+    if err != nil {
+        return fmt.Errorf("redis refresh error: %w", err)
+    }
+    if refreshed == 0 {
+        return ErrNotInProgress
+    }
+    return nil
+}
+
+// LeaseRemaining returns how long the key has left before it expires, which for an
+// IN_PROGRESS key is the time until the lease lapses. It returns 0 if the key is gone.
+func (r *RedisStore) LeaseRemaining(ctx context.Context, key TxnKey) (time.Duration, error) {
+    ttl, err := r.client.PTTL(ctx, key.String()).Result()
+    if err != nil {
+        return 0, fmt.Errorf("redis PTTL error: %w", err)
+    }
+    // PTTL reports a missing key (-2) or one without an expiry (-1) as negative durations
+    return max(ttl, 0), nil
+}
+
+// Cancel marks a pending transaction (see StagePending) CANCELLED, keeping it for
+// the completed TTL so the TransactionID can't be reused to charge after all.
+// Cancelling a cancelled transaction succeeds again. It returns ErrNotInProgress
+// if the transaction is unknown, and ErrNotCancellable if it is past pending.
+func (r *RedisStore) Cancel(ctx context.Context, key TxnKey) error {
+    res, err := cancelScript.Run(ctx, r.client, []string{key.String()},
+        StatusInProgress, StagePending, StatusCancelled, completedTTL(ctx, r.opts.CompletedTTL).Milliseconds()).Int()
+    if err != nil {
+        return fmt.Errorf("redis cancel error: %w", err)
+    }
+    switch res {
+    case 0:
+        return ErrNotInProgress
+    case 2:
+        return ErrNotCancellable
+    }
+    return nil
+}
+
+// Dispatch ends the pending stage just before a provider is called, so the
+// transaction can no longer be cancelled, and renews its lease. It returns
+// ErrCancelled if it was cancelled meanwhile, and ErrNotInProgress if it isn't
+// pending (e.g. its lease lapsed while it waited).
+func (r *RedisStore) Dispatch(ctx context.Context, key TxnKey) error {
+    res, err := dispatchScript.Run(ctx, r.client, []string{key.String()},
+        StatusInProgress, StagePending, StatusCancelled, r.opts.InProgressTTL.Milliseconds()).Int()
+    if err != nil {
+        return fmt.Errorf("redis dispatch error: %w", err)
+    }
+    switch res {
+    case 0:
+        return ErrNotInProgress
+    case 2:
+        return ErrCancelled
+    }
+    return nil
+}
+
+// AppendAudit pushes a JSON audit record onto the list for the record's day.
+func (r *RedisStore) AppendAudit(ctx context.Context, rec AuditRecord) error {
+    key := auditKey(rec.Timestamp)
+    data, err := json.Marshal(rec)
+    if err != nil {
+        return fmt.Errorf("encoding audit record: %w", err)
+    }
+
+    // RPUSH and EXPIRE in one round trip; the expiry is refreshed on each write
+    pipe := r.client.TxPipeline()
+    pipe.RPush(ctx, key, data)
+    pipe.Expire(ctx, key, AuditRetention)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("redis RPUSH error: %w", err)
+    }
+    return nil
+}
+
+// ListAudit returns up to limit of the most recent audit records for a day, oldest first.
+func (r *RedisStore) ListAudit(ctx context.Context, day time.Time, limit int) ([]AuditRecord, error) {
+    items, err := r.client.LRange(ctx, auditKey(day), int64(-limit), -1).Result()
+    if err != nil {
+        return nil, fmt.Errorf("redis LRANGE error: %w", err)
+    }
+
+    records := make([]AuditRecord, 0, len(items))
+    for _, item := range items {
+        var rec AuditRecord
+        if err := json.Unmarshal([]byte(item), &rec); err != nil {
+            return nil, fmt.Errorf("decoding audit record: %w", err)
+        }
+        records = append(records, rec)
+    }
+    return records, nil
+}
+
+// SetProviderDisabled adds provider to (or removes it from) the disabled set.
+func (r *RedisStore) SetProviderDisabled(ctx context.Context, provider string, disabled bool) error {
+    if disabled {
+        if err := r.client.SAdd(ctx, disabledProvidersKey, provider).Err(); err != nil {
+            return fmt.Errorf("redis SADD error: %w", err)
+        }
+        return nil
+    }
+    if err := r.client.SRem(ctx, disabledProvidersKey, provider).Err(); err != nil {
+        return fmt.Errorf("redis SREM error: %w", err)
+    }
+    return nil
+}
+
+// IsProviderDisabled reports whether provider is in the disabled set.
+func (r *RedisStore) IsProviderDisabled(ctx context.Context, provider string) (bool, error) {
+    disabled, err := r.client.SIsMember(ctx, disabledProvidersKey, provider).Result()
+    if err != nil {
+        return false, fmt.Errorf("redis SISMEMBER error: %w", err)
+    }
+    return disabled, nil
+}
+
+// AddUsage counts one more payment of amount against provider's total for day.
+func (r *RedisStore) AddUsage(ctx context.Context, provider, day string, amount float64) error {
+    key := usageKey(provider, day)
+
+    // Both counters and the expiry in one round trip; they share a key, so MULTI is safe in a cluster
+    pipe := r.client.TxPipeline()
+    pipe.HIncrBy(ctx, key, "count", 1)
+    pipe.HIncrByFloat(ctx, key, "amount", amount)
+    pipe.Expire(ctx, key, UsageRetention)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("redis HINCRBY error: %w", err)
+    }
+    return nil
+}
+
+// GetUsage returns provider's totals for day.
+func (r *RedisStore) GetUsage(ctx context.Context, provider, day string) (Usage, error) {
+    fields, err := r.client.HGetAll(ctx, usageKey(provider, day)).Result()
+    if err != nil {
+        return Usage{}, fmt.Errorf("redis HGETALL error: %w", err)
+    }
+
+    var usage Usage
+    if v, ok := fields["count"]; ok {
+        if usage.Count, err = strconv.ParseInt(v, 10, 64); err != nil {
+            return Usage{}, fmt.Errorf("decoding usage count: %w", err)
+        }
+    }
+    if v, ok := fields["amount"]; ok {
+        if usage.Amount, err = strconv.ParseFloat(v, 64); err != nil {
+            return Usage{}, fmt.Errorf("decoding usage amount: %w", err)
+        }
+    }
+    return usage, nil
+}
+
+// Enqueue appends p to the payment queue and marks its transaction as queued.
+func (r *RedisStore) Enqueue(ctx context.Context, p QueuedPayment) error {
+    return r.pushQueued(ctx, p, false)
+}
+
+// Requeue puts p back at the head of the payment queue.
+func (r *RedisStore) Requeue(ctx context.Context, p QueuedPayment) error {
+    return r.pushQueued(ctx, p, true)
+}
+
+// pushQueued writes p to the tail (or head) of the queue along with its marker.
+// A plain pipeline rather than MULTI: in cluster mode the list and the marker live
+// in different slots.
+func (r *RedisStore) pushQueued(ctx context.Context, p QueuedPayment, head bool) error {
+    data, err := json.Marshal(p)
+    if err != nil {
+        return fmt.Errorf("encoding queued payment: %w", err)
+    }
+
+    pipe := r.client.Pipeline()
+    if head {
+        pipe.LPush(ctx, paymentQueueKey, data)
+    } else {
+        pipe.RPush(ctx, paymentQueueKey, data)
+    }
+    pipe.Set(ctx, p.Key.queuedKey(), StatusQueued, QueuedMarkerTTL)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("redis queue push error: %w", err)
+    }
+    return nil
+}
+
+// Dequeue pops the oldest payment and clears its marker; (nil, nil) when the queue is empty.
+func (r *RedisStore) Dequeue(ctx context.Context) (*QueuedPayment, error) {
+    data, err := r.client.LPop(ctx, paymentQueueKey).Bytes()
+    if errors.Is(err, redis.Nil) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("redis LPOP error: %w", err)
+    }
+
+    var p QueuedPayment
+    if err := json.Unmarshal(data, &p); err != nil {
+        return nil, fmt.Errorf("decoding queued payment: %w", err)
+    }
+    if err := r.client.Del(ctx, p.Key.queuedKey()).Err(); err != nil {
+        return nil, fmt.Errorf("redis DEL error: %w", err)
+    }
+    return &p, nil
+}
+
+// IsQueued reports whether the transaction's queued marker is set.
+func (r *RedisStore) IsQueued(ctx context.Context, key TxnKey) (bool, error) {
+    n, err := r.client.Exists(ctx, key.queuedKey()).Result()
+    if err != nil {
+        return false, fmt.Errorf("redis EXISTS error: %w", err)
+    }
+    return n > 0, nil
+}
+
+// AddDeadLetter stores d under its transaction key, dropping the oldest entries
+// beyond the limit.
+func (r *RedisStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+    data, err := json.Marshal(d)
+    if err != nil {
+        return fmt.Errorf("encoding dead letter: %w", err)
+    }
+    err = addDeadLetterScript.Run(ctx, r.client, []string{deadLetterIndexKey, deadLetterEntriesKey},
+        d.Key.String(), d.FailedAt.UnixMilli(), data, r.opts.DeadLetterLimit, DeadLetterRetention.Milliseconds()).Err()
+    if err != nil {
+        return fmt.Errorf("redis dead letter error: %w", err)
+    }
+    return nil
+}
+
+// ListDeadLetters returns up to limit entries, most recent failure first.
+func (r *RedisStore) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+    keys, err := r.client.ZRevRange(ctx, deadLetterIndexKey, 0, int64(limit-1)).Result()
+    if err != nil {
+        return nil, fmt.Errorf("redis ZREVRANGE error: %w", err)
+    }
+    if len(keys) == 0 {
+        return []DeadLetter{}, nil
+    }
+    items, err := r.client.HMGet(ctx, deadLetterEntriesKey, keys...).Result()
+    if err != nil {
+        return nil, fmt.Errorf("redis HMGET error: %w", err)
+    }
+
+    letters := make([]DeadLetter, 0, len(items))
+    for _, item := range items {
+        data, ok := item.(string)
+        if !ok {
+            // Removed between the two reads
+            continue
+        }
+        var d DeadLetter
+        if err := json.Unmarshal([]byte(data), &d); err != nil {
+            return nil, fmt.Errorf("decoding dead letter: %w", err)
+        }
+        letters = append(letters, d)
+    }
+    return letters, nil
+}
+
+// GetDeadLetter returns the entry for key, or (nil, nil) when there is none.
+func (r *RedisStore) GetDeadLetter(ctx context.Context, key TxnKey) (*DeadLetter, error) {
+    data, err := r.client.HGet(ctx, deadLetterEntriesKey, key.String()).Bytes()
+    if errors.Is(err, redis.Nil) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("redis HGET error: %w", err)
+    }
+    var d DeadLetter
+    if err := json.Unmarshal(data, &d); err != nil {
+        return nil, fmt.Errorf("decoding dead letter: %w", err)
+    }
+    return &d, nil
+}
+
+// RemoveDeadLetter deletes the entry for key and its place in the index.
+func (r *RedisStore) RemoveDeadLetter(ctx context.Context, key TxnKey) error {
+    pipe := r.client.TxPipeline()
+    pipe.ZRem(ctx, deadLetterIndexKey, key.String())
+    pipe.HDel(ctx, deadLetterEntriesKey, key.String())
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("redis dead letter removal error: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// flakyRedis is a minimal RESP server. It closes the first drops connections it
+// accepts, as a Redis restart or network blip would, and then answers PING. Every
+// other command gets an error reply, which the client's handshake tolerates.
+type flakyRedis struct {
+	listener net.Listener
+	drops    int32
+	conns    atomic.Int32 // Connections accepted
+	open     atomic.Int32 // Connections being served, until the client closes them
+}
+
+func newFlakyRedis(t *testing.T, drops int32) *flakyRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &flakyRedis{listener: l, drops: drops}
+	t.Cleanup(func() { l.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *flakyRedis) addr() string { return f.listener.Addr().String() }
+
+func (f *flakyRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		if f.conns.Add(1) <= f.drops {
+			conn.Close()
+			continue
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *flakyRedis) handle(conn net.Conn) {
+	f.open.Add(1)
+	defer f.open.Add(-1)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		reply := "-ERR unknown command\r\n"
+		if strings.EqualFold(cmd, "PING") {
+			reply = "+PONG\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one RESP array of bulk strings and returns its first element.
+func readCommand(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return "", err
+	}
+	var name string
+	for i := range n {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return "", err
+		}
+		arg := make([]byte, size+2) // Plus the trailing CRLF
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return "", err
+		}
+		if i == 0 {
+			name = string(arg[:size])
+		}
+	}
+	return name, nil
+}
+
+func TestRedisStoreRetriesATransientFailure(t *testing.T) {
+	server := newFlakyRedis(t, 2)
+	s := NewRedisStore(server.addr(), "", 0, Options{
+		MaxRetries:      3,
+		MinRetryBackoff: time.Millisecond,
+		MaxRetryBackoff: 5 * time.Millisecond,
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v, want it to succeed after retrying", err)
+	}
+	if got := server.conns.Load(); got != 3 {
+		t.Errorf("%d connections, want 3 (two dropped, then one that answered)", got)
+	}
+}
+
+func TestRedisStoreWithoutRetriesFailsOnATransientFailure(t *testing.T) {
+	server := newFlakyRedis(t, 1)
+	s := NewRedisStore(server.addr(), "", 0, Options{MaxRetries: -1})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("Ping succeeded, want the dropped connection's error")
+	}
+	if got := server.conns.Load(); got != 1 {
+		t.Errorf("%d connections, want 1", got)
+	}
+}
+
+func TestRedisStoreRetriesStopAtTheContextDeadline(t *testing.T) {
+	server := newFlakyRedis(t, 1000)
+	s := NewRedisStore(server.addr(), "", 0, Options{
+		MaxRetries:      1000,
+		MinRetryBackoff: 20 * time.Millisecond,
+		MaxRetryBackoff: 20 * time.Millisecond,
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Ping(ctx); err == nil {
+		t.Fatal("Ping succeeded against a server that drops every connection")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("retries ran for %s, past the 100ms deadline", took)
+	}
+}
+
+func TestRedisStoreCloseReleasesConnectionsAndGoroutines(t *testing.T) {
+	server := newFlakyRedis(t, 0)
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stores := make([]*RedisStore, 5)
+	for i := range stores {
+		stores[i] = NewRedisStore(server.addr(), "", 0, Options{})
+		if err := stores[i].Ping(ctx); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	}
+	if got := server.open.Load(); got != int32(len(stores)) {
+		t.Fatalf("%d open connections, want %d", got, len(stores))
+	}
+
+	for _, s := range stores {
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	if err := stores[0].Ping(ctx); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("Ping after Close = %v, want %v", err, redis.ErrClosed)
+	}
+
+	// The server notices each close asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for server.open.Load() > 0 || runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("after Close: %d connections still open, %d goroutines (was %d)",
+				server.open.Load(), runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
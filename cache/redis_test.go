@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// newTestRedisStore returns a RedisStore over an in-process miniredis, closed when
+// the test ends. The miniredis is returned too, to inspect keys and fast-forward TTLs.
+func newTestRedisStore(t *testing.T, opts Options) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	s := NewRedisStore(mr.Addr(), "", 0, opts)
+	t.Cleanup(func() { s.Close() })
+	return s, mr
+}
+
+// miniredisStore is a RedisStore whose clock the suite can fast-forward (see wait).
+type miniredisStore struct {
+	*RedisStore
+	mr *miniredis.Miniredis
+}
+
+func (s miniredisStore) FastForward(d time.Duration) { s.mr.FastForward(d) }
+
+func TestRedisStore(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T, opts Options) IdempotencyStore {
+		s, mr := newTestRedisStore(t, opts)
+		return miniredisStore{s, mr}
+	})
+}
+
+func TestRedisStoreKeepsInProgressInfo(t *testing.T) {
+	s, _ := newTestRedisStore(t, Options{})
+	ctx := context.Background()
+	key := newKey(t)
+
+	info := InProgressInfo{Stage: StagePending, Provider: "MTN", Amount: 10.5, Currency: "ZAR"}
+	if state, err := s.CheckOrSetInProgressWithInfo(ctx, key, info); err != nil || state != StateNew {
+		t.Fatalf("CheckOrSetInProgressWithInfo = %s, %v; want NEW", state, err)
+	}
+
+	raw, err := s.client.Get(ctx, key.String()).Result()
+	if err != nil {
+		t.Fatalf("GET %s: %v", key, err)
+	}
+	var stored InProgressInfo
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+		t.Fatalf("stored value %q is not InProgressInfo: %v", raw, err)
+	}
+	if stored.Status != StatusInProgress || stored.Provider != "MTN" || stored.Amount != 10.5 || stored.StartedAt.IsZero() {
+		t.Errorf("stored %+v, want %+v with status and start time", stored, info)
+	}
+}
+
+func TestRedisStoreTTLs(t *testing.T) {
+	opts := Options{InProgressTTL: 10 * time.Second, CompletedTTL: time.Hour, FailedTTL: time.Minute}
+	res := &providers.PaymentResponse{Status: "FAILED", ReferenceID: "N/A"}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey)
+		ttl   time.Duration // How long the state should last
+	}{
+		{"in progress", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {}, opts.InProgressTTL},
+		{"refreshed lease", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {
+			mustDo(t, "RefreshInProgress", s.RefreshInProgress(ctx, key))
+		}, opts.InProgressTTL},
+		{"completed", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {
+			mustDo(t, "SetCompleted", s.SetCompleted(ctx, key))
+		}, opts.CompletedTTL},
+		{"completed with a TTL from the context", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {
+			mustDo(t, "SetCompleted", s.SetCompleted(WithCompletedTTL(ctx, 2*time.Hour), key))
+		}, 2 * time.Hour},
+		{"failed", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {
+			mustDo(t, "SetFailed", s.SetFailed(ctx, key, res))
+		}, opts.FailedTTL},
+		{"terminal failure", func(t *testing.T, ctx context.Context, s *RedisStore, key TxnKey) {
+			mustDo(t, "SetFailed", s.SetFailed(WithTerminalFailure(ctx), key, res))
+		}, opts.CompletedTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mr := newTestRedisStore(t, opts)
+			ctx := context.Background()
+			key := newKey(t)
+			wantState(t, ctx, s, key, StateNew)
+			tt.setup(t, ctx, s, key)
+			status, err := s.GetStatus(ctx, key)
+			if err != nil {
+				t.Fatalf("GetStatus: %v", err)
+			}
+
+			mr.FastForward(tt.ttl - time.Second)
+			wantStatus(t, ctx, s, key, status)
+
+			mr.FastForward(2 * time.Second)
+			wantStatus(t, ctx, s, key, "")
+			if mr.Exists(key.resultKey()) {
+				t.Errorf("result %s outlived its status", key.resultKey())
+			}
+			// Once the state has expired the transaction can be claimed afresh
+			wantState(t, ctx, s, key, StateNew)
+		})
+	}
+}
+
+func TestRedisStoreAbandonedClaimIsReleasedByItsTTL(t *testing.T) {
+	s, mr := newTestRedisStore(t, Options{InProgressTTL: 10 * time.Second})
+	ctx := context.Background()
+	key := newKey(t)
+
+	// A process that crashed mid-payment never releases its claim
+	wantState(t, ctx, s, key, StateNew)
+	if left, err := s.LeaseRemaining(ctx, key); err != nil || left <= 0 || left > 10*time.Second {
+		t.Errorf("LeaseRemaining = %s, %v; want up to 10s", left, err)
+	}
+	mr.FastForward(11 * time.Second)
+	wantErr(t, "RefreshInProgress", s.RefreshInProgress(ctx, key), ErrNotInProgress)
+	wantState(t, ctx, s, key, StateNew)
+}
@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payment-gateway-aggregator/providers"
+)
+
+// storeFactory returns an IdempotencyStore configured with opts for one test.
+type storeFactory func(t *testing.T, opts Options) IdempotencyStore
+
+// keySeq keeps keys unique across tests, so stores that outlive a test (a shared
+// Redis) never see one test's keys in another.
+var keySeq atomic.Int64
+
+// newKey returns a transaction key no other test uses.
+func newKey(t *testing.T) TxnKey {
+	t.Helper()
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return TxnKey{Tenant: "test-" + run, TransactionID: "TXN-" + strconv.FormatInt(keySeq.Add(1), 10)}
+}
+
+// storeCase is one behaviour every IdempotencyStore must share.
+type storeCase struct {
+	name string
+	opts Options // The store's options; zero uses the defaults
+	run  func(t *testing.T, ctx context.Context, s IdempotencyStore)
+}
+
+// storeCases is the parity suite: each case runs unchanged against MemoryStore and
+// RedisStore (over miniredis), so the two can't drift apart. Cases that need time to
+// pass use wait.
+var storeCases = []storeCase{
+	{name: "unknown transaction", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		if status, err := s.GetStatus(ctx, key); err != nil || status != "" {
+			t.Errorf("GetStatus = %q, %v; want \"\", nil", status, err)
+		}
+		if res, err := s.GetResult(ctx, key); err != nil || res != nil {
+			t.Errorf("GetResult = %+v, %v; want nil, nil", res, err)
+		}
+		if left, err := s.LeaseRemaining(ctx, key); err != nil || left != 0 {
+			t.Errorf("LeaseRemaining = %s, %v; want 0, nil", left, err)
+		}
+		wantErr(t, "Delete", s.Delete(ctx, key), ErrNotInProgress)
+		wantErr(t, "RefreshInProgress", s.RefreshInProgress(ctx, key), ErrNotInProgress)
+		wantErr(t, "Cancel", s.Cancel(ctx, key), ErrNotInProgress)
+		wantErr(t, "Dispatch", s.Dispatch(ctx, key), ErrNotInProgress)
+	}},
+
+	{name: "claim", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		wantState(t, ctx, s, key, StateInProgress)
+		wantStatus(t, ctx, s, key, StatusInProgress)
+		if err := s.RefreshInProgress(ctx, key); err != nil {
+			t.Errorf("RefreshInProgress: %v", err)
+		}
+	}},
+
+	{name: "tenants are separate", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		other := TxnKey{Tenant: key.Tenant + "-other", TransactionID: key.TransactionID}
+		wantState(t, ctx, s, key, StateNew)
+		wantState(t, ctx, s, other, StateNew)
+	}},
+
+	{name: "complete", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		res := &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "REF-1", ProviderName: "MTN_MOMO", Amount: 10.5}
+		mustDo(t, "SetResult", s.SetResult(ctx, key, res))
+		mustDo(t, "SetCompleted", s.SetCompleted(ctx, key))
+
+		wantState(t, ctx, s, key, StateCompleted)
+		wantStatus(t, ctx, s, key, StatusCompleted)
+		if done, err := s.CheckCompleted(ctx, key); err != nil || !done {
+			t.Errorf("CheckCompleted = %v, %v; want true, nil", done, err)
+		}
+		got, err := s.GetResult(ctx, key)
+		if err != nil || !reflect.DeepEqual(got, res) {
+			t.Errorf("GetResult = %+v, %v; want %+v", got, err, res)
+		}
+		// A completed transaction is never released or re-leased
+		wantErr(t, "Delete", s.Delete(ctx, key), ErrNotInProgress)
+		wantErr(t, "RefreshInProgress", s.RefreshInProgress(ctx, key), ErrNotInProgress)
+		wantErr(t, "Cancel", s.Cancel(ctx, key), ErrNotCancellable)
+	}},
+
+	{name: "stored result is a copy", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		res := &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "REF-1"}
+		mustDo(t, "SetResult", s.SetResult(ctx, key, res))
+		res.ReferenceID = "changed"
+		if got, _ := s.GetResult(ctx, key); got == nil || got.ReferenceID != "REF-1" {
+			t.Errorf("GetResult = %+v, want the result as stored", got)
+		}
+	}},
+
+	{name: "cached failure", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		res := &providers.PaymentResponse{Status: "FAILED", ReferenceID: "N/A", Message: "declined"}
+		mustDo(t, "SetFailed", s.SetFailed(ctx, key, res))
+
+		wantState(t, ctx, s, key, StateFailed)
+		wantStatus(t, ctx, s, key, StatusFailed)
+		if got, err := s.GetResult(ctx, key); err != nil || got == nil || got.Message != "declined" {
+			t.Errorf("GetResult = %+v, %v; want the failure", got, err)
+		}
+		// A failure can be cleared for an immediate retry
+		mustDo(t, "Delete", s.Delete(ctx, key))
+		wantState(t, ctx, s, key, StateNew)
+	}},
+
+	{name: "fingerprint", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		for _, step := range []struct {
+			fingerprint string
+			want        bool
+		}{{"a", true}, {"a", true}, {"b", false}} {
+			if got, err := s.MatchFingerprint(ctx, key, step.fingerprint); err != nil || got != step.want {
+				t.Errorf("MatchFingerprint(%q) = %v, %v; want %v", step.fingerprint, got, err, step.want)
+			}
+		}
+		// Releasing the claim forgets the fingerprint, so a corrected retry is accepted
+		mustDo(t, "Delete", s.Delete(ctx, key))
+		if got, err := s.MatchFingerprint(ctx, key, "b"); err != nil || !got {
+			t.Errorf("MatchFingerprint after Delete = %v, %v; want true", got, err)
+		}
+	}},
+
+	{name: "cancel a pending payment", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantClaim(t, ctx, s, key, InProgressInfo{Stage: StagePending}, StateNew)
+		wantStatus(t, ctx, s, key, StatusInProgress)
+		mustDo(t, "Cancel", s.Cancel(ctx, key))
+		mustDo(t, "Cancel again", s.Cancel(ctx, key))
+
+		wantState(t, ctx, s, key, StateCancelled)
+		wantStatus(t, ctx, s, key, StatusCancelled)
+		wantErr(t, "Dispatch", s.Dispatch(ctx, key), ErrCancelled)
+		wantErr(t, "Delete", s.Delete(ctx, key), ErrNotInProgress)
+	}},
+
+	{name: "dispatch ends the pending stage", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantClaim(t, ctx, s, key, InProgressInfo{Stage: StagePending, Provider: "MTN"}, StateNew)
+		mustDo(t, "Dispatch", s.Dispatch(ctx, key))
+
+		wantStatus(t, ctx, s, key, StatusInProgress)
+		wantErr(t, "Cancel", s.Cancel(ctx, key), ErrNotCancellable)
+		wantErr(t, "Dispatch again", s.Dispatch(ctx, key), ErrNotInProgress)
+	}},
+
+	{name: "only a pending payment can be cancelled", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		wantErr(t, "Cancel", s.Cancel(ctx, key), ErrNotCancellable)
+		wantErr(t, "Dispatch", s.Dispatch(ctx, key), ErrNotInProgress)
+	}},
+
+	{name: "delete releases a claim", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		mustDo(t, "Delete", s.Delete(ctx, key))
+		wantStatus(t, ctx, s, key, "")
+		wantState(t, ctx, s, key, StateNew)
+	}},
+
+	{name: "default TTLs", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		wantLease(t, ctx, s, key, InProgressExpiry)
+		mustDo(t, "SetCompleted", s.SetCompleted(ctx, key))
+		wantLease(t, ctx, s, key, CompletedExpiry)
+	}},
+
+	{name: "custom TTLs", opts: Options{InProgressTTL: 3 * time.Second, CompletedTTL: time.Hour}, run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		wantLease(t, ctx, s, key, 3*time.Second)
+		mustDo(t, "RefreshInProgress", s.RefreshInProgress(ctx, key))
+		wantLease(t, ctx, s, key, 3*time.Second)
+		mustDo(t, "SetCompleted", s.SetCompleted(ctx, key))
+		wantLease(t, ctx, s, key, time.Hour)
+	}},
+
+	{name: "completed TTL from the context", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		mustDo(t, "SetCompleted", s.SetCompleted(WithCompletedTTL(ctx, 2*time.Hour), key))
+		wantLease(t, ctx, s, key, 2*time.Hour)
+	}},
+
+	{name: "an expired claim can be taken again", opts: Options{InProgressTTL: 100 * time.Millisecond}, run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		wantState(t, ctx, s, key, StateNew)
+		wait(s, 150*time.Millisecond)
+		wantStatus(t, ctx, s, key, "")
+		wantErr(t, "RefreshInProgress", s.RefreshInProgress(ctx, key), ErrNotInProgress)
+		wantState(t, ctx, s, key, StateNew)
+	}},
+
+	{name: "concurrent claims: exactly one wins", run: func(t *testing.T, ctx context.Context, s IdempotencyStore) {
+		key := newKey(t)
+		const callers = 50
+		states := make(chan TxnState, callers)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for range callers {
+			wg.Go(func() {
+				<-start
+				state, err := s.CheckOrSetInProgress(ctx, key)
+				if err != nil {
+					t.Errorf("CheckOrSetInProgress: %v", err)
+					return
+				}
+				states <- state
+			})
+		}
+		close(start)
+		wg.Wait()
+		close(states)
+
+		counts := make(map[TxnState]int)
+		for state := range states {
+			counts[state]++
+		}
+		if counts[StateNew] != 1 || counts[StateInProgress] != callers-1 {
+			t.Errorf("claims %v, want exactly one NEW and %d IN_PROGRESS", counts, callers-1)
+		}
+	}},
+}
+
+// runStoreSuite runs every storeCase against stores built by newStore.
+func runStoreSuite(t *testing.T, newStore storeFactory) {
+	for _, tc := range storeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tc.run(t, ctx, newStore(t, tc.opts))
+		})
+	}
+}
+
+// fastForwarder is a store whose clock tests can move, like one over miniredis.
+type fastForwarder interface {
+	FastForward(d time.Duration)
+}
+
+// wait lets d pass for s: instantly for a store that can fast-forward, otherwise in real time.
+func wait(s IdempotencyStore, d time.Duration) {
+	if ff, ok := s.(fastForwarder); ok {
+		ff.FastForward(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T, opts Options) IdempotencyStore {
+		return NewMemoryStore(opts)
+	})
+}
+
+func wantState(t *testing.T, ctx context.Context, s IdempotencyStore, key TxnKey, want TxnState) {
+	t.Helper()
+	wantClaim(t, ctx, s, key, InProgressInfo{}, want)
+}
+
+func wantClaim(t *testing.T, ctx context.Context, s IdempotencyStore, key TxnKey, info InProgressInfo, want TxnState) {
+	t.Helper()
+	got, err := s.CheckOrSetInProgressWithInfo(ctx, key, info)
+	if err != nil {
+		t.Fatalf("CheckOrSetInProgress: %v", err)
+	}
+	if got != want {
+		t.Errorf("CheckOrSetInProgress = %s, want %s", got, want)
+	}
+}
+
+func wantStatus(t *testing.T, ctx context.Context, s IdempotencyStore, key TxnKey, want string) {
+	t.Helper()
+	got, err := s.GetStatus(ctx, key)
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	if got != want {
+		t.Errorf("GetStatus = %q, want %q", got, want)
+	}
+}
+
+func wantErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s = %v, want %v", op, err, want)
+	}
+}
+
+func mustDo(t *testing.T, op string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", op, err)
+	}
+}
+
+// wantLease checks that key expires in at most ttl, and not much sooner.
+func wantLease(t *testing.T, ctx context.Context, s IdempotencyStore, key TxnKey, ttl time.Duration) {
+	t.Helper()
+	left, err := s.LeaseRemaining(ctx, key)
+	if err != nil {
+		t.Fatalf("LeaseRemaining: %v", err)
+	}
+	if left > ttl || left < ttl-time.Second {
+		t.Errorf("LeaseRemaining = %s, want just under %s", left, ttl)
+	}
+}
@@ -0,0 +1,14 @@
+package cache
+
+import "context"
+
+// disabledProvidersKey is the set of provider keys an operator has disabled.
+const disabledProvidersKey = "providers:disabled"
+
+// ProviderSwitch persists the operator kill-switch that takes a provider out of
+// routing whatever its breaker says, e.g. for a maintenance window. It lives in
+// the shared store, so it survives restarts and applies to every instance.
+type ProviderSwitch interface {
+	SetProviderDisabled(ctx context.Context, provider string, disabled bool) error
+	IsProviderDisabled(ctx context.Context, provider string) (bool, error)
+}
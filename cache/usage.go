@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// UsageRetention is how long a day's usage counters are kept. It outlasts the day
+// in any timezone, so the counters are gone well before a key could be reused.
+const UsageRetention = 48 * time.Hour
+
+// Usage is one provider's successful payments for one day.
+type Usage struct {
+	Count  int64   `json:"count"`
+	Amount float64 `json:"amount"`
+}
+
+// UsageStore keeps per-provider daily totals, used to enforce daily caps.
+// day is an opaque label such as "2025-01-31"; the caller picks the timezone.
+type UsageStore interface {
+	AddUsage(ctx context.Context, provider, day string, amount float64) error
+	// GetUsage returns the zero Usage for a day with no payments yet.
+	GetUsage(ctx context.Context, provider, day string) (Usage, error)
+}
+
+// usageKey returns the per-provider, per-day hash key, e.g. "usage:MTN:2025-01-31".
+func usageKey(provider, day string) string {
+	return "usage:" + provider + ":" + day
+}
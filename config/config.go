@@ -0,0 +1,923 @@
+// Package config loads the aggregator's runtime configuration.
+//
+// Values are resolved per field in this order: the JSON file named by CONFIG_FILE,
+// then environment variables, then the built-in defaults.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads from JSON as either a Go duration
+// string ("1500ms", "30s") or a whole number of milliseconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var ms int64
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\" or a number of milliseconds: %s", data)
+	}
+	*d = Duration(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing the duration as a string like "5s".
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the full aggregator configuration.
+type Config struct {
+	Server    ServerConfig              `json:"server"`
+	Redis     RedisConfig               `json:"redis"`
+	Retry     RetryConfig               `json:"retry"`
+	Batch     BatchConfig               `json:"batch"`
+	Webhook   WebhookConfig             `json:"webhook"`
+	Queue     QueueConfig               `json:"queue"`
+	Async     AsyncConfig               `json:"async"`
+	Auth      AuthConfig                `json:"auth"`
+	RateLimit RateLimitConfig           `json:"rateLimit"`
+	Tracing   TracingConfig             `json:"tracing"`
+	CORS      CORSConfig                `json:"cors"`
+	Providers map[string]ProviderConfig `json:"providers"` // Keyed by provider key, e.g. "MTN"
+
+	// IdempotencyStore selects the store backend: "redis" (default) or "memory" for local development.
+	IdempotencyStore string            `json:"idempotencyStore"`
+	Idempotency      IdempotencyConfig `json:"idempotency"`
+
+	// TransactionIDPattern is the regular expression a client's TransactionID must
+	// match (empty accepts anything), and TransactionIDMaxLength its longest length in
+	// characters. The default admits UUIDs and letters, digits and '-'.
+	TransactionIDPattern   string `json:"transactionIDPattern"`
+	TransactionIDMaxLength int    `json:"transactionIDMaxLength"`
+
+	// Currencies is the allowlist of ISO 4217 codes accepted on payments. Empty allows
+	// exactly the currencies some provider is routed for.
+	Currencies []string `json:"currencies"`
+
+	// ProviderTimeout is the default call timeout for providers without their own Timeout.
+	ProviderTimeout Duration `json:"providerTimeout"`
+
+	// ProviderMinRemaining is the least time a request must have left for an HTTP
+	// provider to be called at all; with less, the call is skipped as a timeout
+	// instead of being made only to time out. 0 always calls.
+	ProviderMinRemaining Duration `json:"providerMinRemaining"`
+
+	// HealthProbeInterval is how often providers whose breaker isn't closed are
+	// health-checked, so they can recover without waiting for live traffic.
+	HealthProbeInterval Duration `json:"healthProbeInterval"`
+
+	// DailyCapTimezone is the IANA timezone (e.g. "Africa/Lusaka") whose midnight
+	// resets the per-provider daily caps.
+	DailyCapTimezone string `json:"dailyCapTimezone"`
+
+	// SplitMaxLegs is the most sub-payments a payment sent with Split may be divided into.
+	SplitMaxLegs int `json:"splitMaxLegs"`
+
+	// DeadLetterMaxEntries caps the dead-letter store (payments that failed after every
+	// retry and fallback); the oldest entries are dropped beyond it.
+	DeadLetterMaxEntries int `json:"deadLetterMaxEntries"`
+
+	// LoadBalancerSeed fixes the random source behind weighted provider selection
+	// so it is repeatable in tests. 0 seeds from the clock.
+	LoadBalancerSeed uint64 `json:"loadBalancerSeed"`
+
+	// AdaptiveRouting sends payments that don't pin a provider to whichever provider on
+	// their route has the lowest recent latency, instead of by weight or currency alone.
+	AdaptiveRouting bool `json:"adaptiveRouting"`
+
+	// LatencyAlpha is the weight (0 < alpha <= 1) each new call duration gets in a
+	// provider's moving-average latency; higher reacts faster but is noisier.
+	LatencyAlpha float64 `json:"latencyAlpha"`
+
+	// DryRun replaces every provider call with a synthetic success (for load/integration tests).
+	DryRun bool `json:"dryRun"`
+
+	// SimulatorMode lets magic payment amounts (402, 500, 408, ...) script the outcome
+	// of the MTN and Airtel simulators; see providers.WithMagicAmounts. Never enable
+	// it in production.
+	SimulatorMode bool `json:"simulatorMode"`
+}
+
+// ServerConfig controls the HTTP listener.
+type ServerConfig struct {
+	Port            string   `json:"port"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	MaxBodyBytes    int64    `json:"maxBodyBytes"` // Largest accepted request body
+	// RequestTimeout caps a whole request (store round trips and provider retries
+	// included); keep it above the provider timeouts
+	RequestTimeout Duration `json:"requestTimeout"`
+	// BindAddr overrides the listen address, e.g. "127.0.0.1:8080" to only accept
+	// local connections (sidecars) or "unix:/run/aggregator.sock" for a Unix socket.
+	BindAddr string `json:"bindAddr"`
+	// TLS serves HTTPS when a certificate is configured; otherwise plain HTTP (local dev).
+	TLS TLSConfig `json:"tls"`
+}
+
+// TLSConfig holds the server certificate and protocol settings. Send SIGHUP to
+// reload the certificate files after rotating them.
+type TLSConfig struct {
+	CertFile string `json:"certFile"` // PEM certificate (chain); TLS is enabled when set
+	KeyFile  string `json:"keyFile"`  // PEM private key
+	// MinVersion is the oldest protocol accepted: "1.2" (default) or "1.3".
+	MinVersion string `json:"minVersion"`
+	// CipherSuites restricts the TLS 1.2 suites by name (e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256").
+	// Empty uses Go's defaults; TLS 1.3 suites are not configurable.
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+// Enabled reports whether a certificate is configured.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Address returns the address to listen on: BindAddr, or all interfaces on Port.
+func (s ServerConfig) Address() string {
+	if s.BindAddr != "" {
+		return s.BindAddr
+	}
+	return ":" + s.Port
+}
+
+// RedisConfig holds the Redis connection settings.
+type RedisConfig struct {
+	// Mode is "single" (default), "sentinel" or "cluster".
+	Mode string `json:"mode"`
+	// Addrs lists the Sentinel addresses (sentinel mode) or cluster seed nodes (cluster mode).
+	Addrs []string `json:"addrs"`
+	// MasterName is the Sentinel-monitored master to connect to (sentinel mode).
+	MasterName string `json:"masterName"`
+
+	Addr           string   `json:"addr"` // Single-node address
+	Password       string   `json:"password"`
+	DB             int      `json:"db"`
+	ConnectTimeout Duration `json:"connectTimeout"`
+
+	// Retries on network errors with exponential backoff; bounded by each request's deadline.
+	MaxRetries      int      `json:"maxRetries"`      // -1 disables retries
+	MinRetryBackoff Duration `json:"minRetryBackoff"` // First backoff; doubles per retry
+	MaxRetryBackoff Duration `json:"maxRetryBackoff"` // Backoff cap
+}
+
+// IdempotencyConfig controls how long idempotency keys live.
+type IdempotencyConfig struct {
+	InProgressTTL Duration `json:"inProgressTTL"` // Lease on a payment being processed; should outlast the slowest provider call
+	CompletedTTL  Duration `json:"completedTTL"`  // How long completed payments can be replayed
+
+	// MaxClientTTL caps the Idempotency-TTL header, with which a client picks its own
+	// replay window for a payment instead of CompletedTTL.
+	MaxClientTTL Duration `json:"maxClientTTL"`
+
+	// CacheFailures replays a definite failure (a decline or a structured FAILED
+	// response) to retries for FailedTTL, instead of calling the provider again.
+	// Timeouts are never cached: their outcome is unknown.
+	CacheFailures bool     `json:"cacheFailures"`
+	FailedTTL     Duration `json:"failedTTL"`
+
+	// InProgressStatus is the HTTP status returned for a retry of a payment that is
+	// still being processed: 425 (default), 409 or 429, for clients that don't know 425.
+	InProgressStatus int `json:"inProgressStatus"`
+
+	// FailOpen processes payments without duplicate protection while the store is
+	// unreachable. Off by default: payments are rejected with 503 instead.
+	FailOpen bool `json:"failOpen"`
+
+	// Mirror is a second Redis that idempotency state is dual-written to, e.g. the
+	// new cluster during a migration. Disabled unless Addr or Addrs is set.
+	Mirror RedisConfig `json:"mirror"`
+	// ReadPolicy is "first" (default: the primary answers unless it's down) or "quorum".
+	ReadPolicy string `json:"readPolicy"`
+	// Quorum is how many of the stores a write (or a quorum read) needs to succeed on.
+	Quorum int `json:"quorum"`
+}
+
+// Enabled reports whether a mirror store is configured.
+func (r RedisConfig) Enabled() bool {
+	return r.Addr != "" || len(r.Addrs) > 0
+}
+
+// RetryConfig controls provider call retries.
+type RetryConfig struct {
+	MaxRetries int      `json:"maxRetries"` // Retries after the first call, so up to MaxRetries+1 calls in all
+	BaseDelay  Duration `json:"baseDelay"`  // Backoff before the first retry; doubles on each one
+	MaxDelay   Duration `json:"maxDelay"`   // Longest backoff, however many retries came before
+}
+
+// BatchConfig controls POST /v1/pay/batch.
+type BatchConfig struct {
+	MaxItems int `json:"maxItems"` // Largest accepted batch
+	Workers  int `json:"workers"`  // Payments processed concurrently per batch
+}
+
+// WebhookConfig controls completion callbacks.
+type WebhookConfig struct {
+	URL         string   `json:"url"`         // Default callback URL for requests without their own CallbackURL; empty disables it
+	Secret      string   `json:"secret"`      // HMAC-SHA256 key for the X-Signature header; empty sends unsigned callbacks
+	MaxAttempts int      `json:"maxAttempts"` // Delivery attempts per callback, including the first
+	BaseDelay   Duration `json:"baseDelay"`   // Starting backoff between attempts
+	MaxDelay    Duration `json:"maxDelay"`    // Longest backoff between attempts
+	Timeout     Duration `json:"timeout"`     // Per-attempt HTTP timeout
+	QueueSize   int      `json:"queueSize"`   // Pending callbacks held before new ones are dropped
+}
+
+// QueueConfig controls deferring payments while no provider can take them.
+type QueueConfig struct {
+	// Enabled queues payments (202 Accepted) instead of rejecting them with 503 when
+	// every provider on their route is unavailable. Off by default.
+	Enabled      bool     `json:"enabled"`
+	PollInterval Duration `json:"pollInterval"` // How often the worker retries the queue between breaker recoveries
+}
+
+// AsyncConfig controls the background workers behind POST /v1/pay/async.
+type AsyncConfig struct {
+	Workers   int `json:"workers"`   // Payments processed concurrently
+	QueueSize int `json:"queueSize"` // Accepted payments waiting for a worker before new ones get a 503
+}
+
+// AuthConfig controls API key authentication on the payment endpoints.
+type AuthConfig struct {
+	// APIKeys maps a client identifier to its key, e.g. {"checkout": "s3cr3t"}.
+	// Empty leaves the payment endpoints unauthenticated.
+	APIKeys map[string]string `json:"apiKeys"`
+	// AdminClients lists the clients (keys of APIKeys) allowed to use operator
+	// endpoints, e.g. the provider kill-switch and /debug/breakers. Empty closes
+	// those endpoints to every key; with no APIKeys at all they are open like
+	// everything else.
+	AdminClients []string `json:"adminClients"`
+}
+
+// RateLimitConfig controls per-client token-bucket rate limiting on the payment endpoints.
+type RateLimitConfig struct {
+	Rate    float64                    `json:"rate"`    // Sustained requests per second per client; 0 disables limiting
+	Burst   int                        `json:"burst"`   // Requests a client may send at once before being limited
+	Clients map[string]ClientRateLimit `json:"clients"` // Per-client overrides, keyed by API key client ID
+	IdleTTL Duration                   `json:"idleTTL"` // Buckets unused this long are discarded
+}
+
+// ClientRateLimit overrides the global rate and burst for one client.
+type ClientRateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// TracingConfig controls OpenTelemetry trace export.
+type TracingConfig struct {
+	Endpoint    string `json:"endpoint"`    // OTLP/HTTP endpoint, e.g. "http://otel-collector:4318"; empty disables export
+	ServiceName string `json:"serviceName"` // service.name reported on every span
+}
+
+// CORSConfig controls cross-origin access for browser clients.
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowedOrigins"` // Exact origins, e.g. "https://shop.example.com", or "*"; empty disables CORS
+	AllowedMethods []string `json:"allowedMethods"` // Methods allowed in preflight responses
+	AllowedHeaders []string `json:"allowedHeaders"` // Request headers allowed in preflight responses
+	MaxAge         Duration `json:"maxAge"`         // How long browsers may cache a preflight result
+}
+
+// ProviderConfig holds per-provider settings.
+//
+// Every entry is built into a provider instance at startup. Keys other than the
+// built-in "MTN" and "AIRTEL" register extra instances, e.g. regional endpoints keyed
+// "<PROVIDER>_<COUNTRY>" ("MTN_ZM", "MTN_UG"), which payments with a matching Country
+// are routed to.
+type ProviderConfig struct {
+	// Type picks how the instance is built: "MTN" or "AIRTEL" (simulators) or "HTTP".
+	// Defaults to "HTTP" when BaseURL is set, else the part of the key before "_".
+	// An unknown type fails startup.
+	Type string `json:"type"`
+	// BaseURL points an HTTP instance at a real API (see providers.HTTPProvider).
+	BaseURL string `json:"baseURL"`
+
+	Timeout Duration      `json:"timeout"`
+	Breaker BreakerConfig `json:"breaker"`
+
+	// Per-transaction amount limits (inclusive). Zero means no limit on that side.
+	MinAmount float64 `json:"minAmount"`
+	MaxAmount float64 `json:"maxAmount"`
+
+	// Fee is charged on each successful payment and reported on the response.
+	Fee FeeConfig `json:"fee"`
+
+	// Daily ceilings on successful payments through this provider (reset at midnight in
+	// Config.DailyCapTimezone). Zero means no cap on that side.
+	DailyMaxAmount float64 `json:"dailyMaxAmount"`
+	DailyMaxCount  int     `json:"dailyMaxCount"`
+
+	// MaxConcurrent caps in-flight calls to this provider; extra calls are rejected immediately.
+	MaxConcurrent int `json:"maxConcurrent"`
+
+	// Relative share of unpinned traffic on this provider's route, e.g. MTN 70 / AIRTEL 30.
+	// Zero everywhere keeps plain currency routing.
+	Weight int `json:"weight"`
+}
+
+// FeeConfig is a provider's per-payment fee: Flat plus Percent of the amount.
+type FeeConfig struct {
+	Flat    float64 `json:"flat"`
+	Percent float64 `json:"percent"` // e.g. 1.5 for 1.5%
+}
+
+// BreakerConfig holds the tunable circuit breaker settings for one provider.
+type BreakerConfig struct {
+	// The maximum number of requests allowed in the half-open state.
+	// Setting to 1 allows one trial request after the Timeout expires.
+	MaxRequests uint32 `json:"maxRequests"`
+	// The period of the open state (the delay before the circuit tries to close)
+	Timeout Duration `json:"timeout"`
+	// The rolling window size to clear counts
+	Interval Duration `json:"interval"`
+	// Minimum number of requests in the window before the failure ratio is considered
+	MinRequests uint32 `json:"minRequests"`
+	// Failure ratio (0-1) at or above which the circuit opens
+	FailureRatio float64 `json:"failureRatio"`
+	// CountBusinessErrors makes declines and rejected requests count as failures too.
+	// Off by default: only provider faults (5xx, timeouts) move the breaker.
+	CountBusinessErrors bool `json:"countBusinessErrors"`
+}
+
+// DefaultBreaker is the original MTN tuning: trip at a 60% failure rate
+// over at least 3 requests, stay open for 30s, then allow one trial request.
+var DefaultBreaker = BreakerConfig{
+	MaxRequests:  1,
+	Timeout:      Duration(30 * time.Second),
+	Interval:     Duration(5 * time.Second),
+	MinRequests:  3,
+	FailureRatio: 0.6,
+}
+
+// DefaultMaxConcurrent is the per-provider in-flight call cap when none is configured.
+const DefaultMaxConcurrent = 100
+
+// MinRateLimitIdleTTL is the shortest RateLimit.IdleTTL. Idle buckets are swept every
+// IdleTTL/2, so a shorter TTL would only keep the sweeper spinning on the lock.
+const MinRateLimitIdleTTL = Duration(time.Second)
+
+// Default returns the built-in configuration used when nothing else is set.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            "8080",
+			ShutdownTimeout: Duration(10 * time.Second),
+			MaxBodyBytes:    64 << 10, // 64KB is plenty for a payment
+			RequestTimeout:  Duration(10 * time.Second),
+		},
+		Redis: RedisConfig{
+			Mode:           "single",
+			Addr:           "localhost:6379",
+			ConnectTimeout: Duration(5 * time.Second),
+
+			MaxRetries:      3,
+			MinRetryBackoff: Duration(8 * time.Millisecond),
+			MaxRetryBackoff: Duration(512 * time.Millisecond),
+		},
+		Retry: RetryConfig{
+			MaxRetries: 2,
+			BaseDelay:  Duration(100 * time.Millisecond),
+			MaxDelay:   Duration(2 * time.Second),
+		},
+		Batch: BatchConfig{
+			MaxItems: 100,
+			Workers:  8,
+		},
+		RateLimit: RateLimitConfig{
+			Burst:   20,
+			IdleTTL: Duration(10 * time.Minute),
+		},
+		Tracing: TracingConfig{
+			ServiceName: "payment-gateway-aggregator",
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID", "X-Tenant-ID"},
+			MaxAge:         Duration(10 * time.Minute),
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts: 5,
+			BaseDelay:   Duration(500 * time.Millisecond),
+			MaxDelay:    Duration(time.Minute),
+			Timeout:     Duration(5 * time.Second),
+			QueueSize:   1000,
+		},
+		Async: AsyncConfig{
+			Workers:   16,
+			QueueSize: 256,
+		},
+		Queue: QueueConfig{
+			PollInterval: Duration(5 * time.Second),
+		},
+		Providers: map[string]ProviderConfig{
+			"MTN":    {Breaker: DefaultBreaker, MaxConcurrent: DefaultMaxConcurrent},
+			"AIRTEL": {Breaker: DefaultBreaker, MaxConcurrent: DefaultMaxConcurrent},
+		},
+		IdempotencyStore: "redis",
+		Idempotency: IdempotencyConfig{
+			InProgressTTL:    Duration(10 * time.Second),
+			CompletedTTL:     Duration(24 * time.Hour),
+			MaxClientTTL:     Duration(7 * 24 * time.Hour),
+			FailedTTL:        Duration(30 * time.Second),
+			InProgressStatus: 425,
+			ReadPolicy:       "first",
+			Quorum:           1,
+		},
+		TransactionIDPattern:   `^[A-Za-z0-9-]+$`,
+		TransactionIDMaxLength: 64,
+		ProviderTimeout:        Duration(5 * time.Second),
+		ProviderMinRemaining:   Duration(50 * time.Millisecond),
+		HealthProbeInterval:    Duration(5 * time.Second),
+		DailyCapTimezone:       "UTC",
+		SplitMaxLegs:           4,
+		DeadLetterMaxEntries:   1000,
+		LatencyAlpha:           0.2,
+	}
+}
+
+// Load builds the configuration from defaults, environment variables, and
+// (if path is non-empty) a JSON file. Fields missing from the file keep their
+// environment or default values, provider entries included: per-provider
+// variables such as MTN_ZM_TIMEOUT_MS also apply to keys only the file declares.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	var data []byte
+	var file struct {
+		Providers map[string]json.RawMessage `json:"providers"`
+	}
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return Config{}, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		// Give the file's own provider keys an entry for applyEnv to fill in
+		for key := range file.Providers {
+			if _, ok := cfg.Providers[key]; !ok {
+				cfg.Providers[key] = ProviderConfig{}
+			}
+		}
+	}
+	applyEnv(&cfg)
+
+	if data != nil {
+		// json replaces a map entry whole, which would drop its env overrides, so
+		// provider entries are decoded one by one over the existing ones instead
+		providers := maps.Clone(cfg.Providers)
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		for key, raw := range file.Providers {
+			p := providers[key]
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return Config{}, fmt.Errorf("parsing config file %s: provider %s: %w", path, key, err)
+			}
+			providers[key] = p
+		}
+		cfg.Providers = providers
+	}
+
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+// Provider returns the settings for the given provider key, falling back to the
+// default breaker tuning and concurrency cap for providers that have no entry.
+func (c Config) Provider(key string) ProviderConfig {
+	if p, ok := c.Providers[key]; ok {
+		return p
+	}
+	return ProviderConfig{Breaker: DefaultBreaker, MaxConcurrent: DefaultMaxConcurrent}
+}
+
+// ProviderTimeoutFor returns the call timeout for the given provider key.
+func (c Config) ProviderTimeoutFor(key string) time.Duration {
+	if p, ok := c.Providers[key]; ok && p.Timeout > 0 {
+		return time.Duration(p.Timeout)
+	}
+	return time.Duration(c.ProviderTimeout)
+}
+
+// applyDefaults fills any zero values left by a partial config file.
+func (c *Config) applyDefaults() {
+	def := Default()
+	if c.Server.Port == "" {
+		c.Server.Port = def.Server.Port
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		c.Server.ShutdownTimeout = def.Server.ShutdownTimeout
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		c.Server.MaxBodyBytes = def.Server.MaxBodyBytes
+	}
+	if c.Server.RequestTimeout <= 0 {
+		c.Server.RequestTimeout = def.Server.RequestTimeout
+	}
+	if c.Redis.Mode == "" {
+		c.Redis.Mode = def.Redis.Mode
+	}
+	if c.Redis.Addr == "" {
+		c.Redis.Addr = def.Redis.Addr
+	}
+	if c.Redis.ConnectTimeout <= 0 {
+		c.Redis.ConnectTimeout = def.Redis.ConnectTimeout
+	}
+	if c.Redis.MaxRetries == 0 {
+		c.Redis.MaxRetries = def.Redis.MaxRetries
+	}
+	if c.Redis.MinRetryBackoff == 0 {
+		c.Redis.MinRetryBackoff = def.Redis.MinRetryBackoff
+	}
+	if c.Redis.MaxRetryBackoff == 0 {
+		c.Redis.MaxRetryBackoff = def.Redis.MaxRetryBackoff
+	}
+	if c.Retry.BaseDelay <= 0 {
+		c.Retry.BaseDelay = def.Retry.BaseDelay
+	}
+	if c.Retry.MaxDelay <= 0 {
+		c.Retry.MaxDelay = def.Retry.MaxDelay
+	}
+	if c.Batch.MaxItems <= 0 {
+		c.Batch.MaxItems = def.Batch.MaxItems
+	}
+	if c.Batch.Workers <= 0 {
+		c.Batch.Workers = def.Batch.Workers
+	}
+	if c.RateLimit.Burst <= 0 {
+		c.RateLimit.Burst = def.RateLimit.Burst
+	}
+	if c.RateLimit.IdleTTL <= 0 {
+		c.RateLimit.IdleTTL = def.RateLimit.IdleTTL
+	}
+	c.RateLimit.IdleTTL = max(c.RateLimit.IdleTTL, MinRateLimitIdleTTL)
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = def.Tracing.ServiceName
+	}
+	if len(c.CORS.AllowedMethods) == 0 {
+		c.CORS.AllowedMethods = def.CORS.AllowedMethods
+	}
+	if len(c.CORS.AllowedHeaders) == 0 {
+		c.CORS.AllowedHeaders = def.CORS.AllowedHeaders
+	}
+	if c.CORS.MaxAge <= 0 {
+		c.CORS.MaxAge = def.CORS.MaxAge
+	}
+	if c.Async.Workers <= 0 {
+		c.Async.Workers = def.Async.Workers
+	}
+	if c.Async.QueueSize <= 0 {
+		c.Async.QueueSize = def.Async.QueueSize
+	}
+	if c.Queue.PollInterval <= 0 {
+		c.Queue.PollInterval = def.Queue.PollInterval
+	}
+	if c.Webhook.MaxAttempts <= 0 {
+		c.Webhook.MaxAttempts = def.Webhook.MaxAttempts
+	}
+	if c.Webhook.BaseDelay <= 0 {
+		c.Webhook.BaseDelay = def.Webhook.BaseDelay
+	}
+	if c.Webhook.MaxDelay <= 0 {
+		c.Webhook.MaxDelay = def.Webhook.MaxDelay
+	}
+	if c.Webhook.Timeout <= 0 {
+		c.Webhook.Timeout = def.Webhook.Timeout
+	}
+	if c.Webhook.QueueSize <= 0 {
+		c.Webhook.QueueSize = def.Webhook.QueueSize
+	}
+	if c.IdempotencyStore == "" {
+		c.IdempotencyStore = def.IdempotencyStore
+	}
+	if c.Idempotency.InProgressTTL <= 0 {
+		c.Idempotency.InProgressTTL = def.Idempotency.InProgressTTL
+	}
+	if c.Idempotency.CompletedTTL <= 0 {
+		c.Idempotency.CompletedTTL = def.Idempotency.CompletedTTL
+	}
+	if c.Idempotency.MaxClientTTL <= 0 {
+		c.Idempotency.MaxClientTTL = def.Idempotency.MaxClientTTL
+	}
+	if c.Idempotency.FailedTTL <= 0 {
+		c.Idempotency.FailedTTL = def.Idempotency.FailedTTL
+	}
+	if c.Idempotency.InProgressStatus == 0 {
+		c.Idempotency.InProgressStatus = def.Idempotency.InProgressStatus
+	}
+	if c.Idempotency.ReadPolicy == "" {
+		c.Idempotency.ReadPolicy = def.Idempotency.ReadPolicy
+	}
+	if c.Idempotency.Quorum <= 0 {
+		c.Idempotency.Quorum = def.Idempotency.Quorum
+	}
+	if c.Idempotency.Mirror.Enabled() && c.Idempotency.Mirror.Mode == "" {
+		c.Idempotency.Mirror.Mode = def.Redis.Mode
+	}
+	if c.TransactionIDMaxLength <= 0 {
+		c.TransactionIDMaxLength = def.TransactionIDMaxLength
+	}
+	if c.ProviderTimeout <= 0 {
+		c.ProviderTimeout = def.ProviderTimeout
+	}
+	if c.ProviderMinRemaining < 0 {
+		c.ProviderMinRemaining = def.ProviderMinRemaining
+	}
+	if c.HealthProbeInterval <= 0 {
+		c.HealthProbeInterval = def.HealthProbeInterval
+	}
+	if c.DailyCapTimezone == "" {
+		c.DailyCapTimezone = def.DailyCapTimezone
+	}
+	if c.SplitMaxLegs <= 0 {
+		c.SplitMaxLegs = def.SplitMaxLegs
+	}
+	if c.DeadLetterMaxEntries <= 0 {
+		c.DeadLetterMaxEntries = def.DeadLetterMaxEntries
+	}
+	if c.LatencyAlpha <= 0 || c.LatencyAlpha > 1 {
+		c.LatencyAlpha = def.LatencyAlpha
+	}
+
+	for key, p := range c.Providers {
+		if p.MaxConcurrent <= 0 {
+			p.MaxConcurrent = DefaultMaxConcurrent
+		}
+
+		b := &p.Breaker
+		if b.MaxRequests == 0 {
+			b.MaxRequests = DefaultBreaker.MaxRequests
+		}
+		if b.Timeout <= 0 {
+			b.Timeout = DefaultBreaker.Timeout
+		}
+		if b.Interval <= 0 {
+			b.Interval = DefaultBreaker.Interval
+		}
+		if b.MinRequests == 0 {
+			b.MinRequests = DefaultBreaker.MinRequests
+		}
+		if b.FailureRatio <= 0 {
+			b.FailureRatio = DefaultBreaker.FailureRatio
+		}
+		c.Providers[key] = p
+	}
+}
+
+// applyEnv overrides cfg with any environment variables that are set.
+func applyEnv(cfg *Config) {
+	cfg.Server.Port = envString("PORT", cfg.Server.Port)
+	cfg.Server.BindAddr = envString("BIND_ADDR", cfg.Server.BindAddr)
+	cfg.Server.TLS.CertFile = envString("TLS_CERT_FILE", cfg.Server.TLS.CertFile)
+	cfg.Server.TLS.KeyFile = envString("TLS_KEY_FILE", cfg.Server.TLS.KeyFile)
+	cfg.Server.TLS.MinVersion = envString("TLS_MIN_VERSION", cfg.Server.TLS.MinVersion)
+	cfg.Server.ShutdownTimeout = Duration(envDuration("SHUTDOWN_TIMEOUT", time.Duration(cfg.Server.ShutdownTimeout)))
+	cfg.Server.MaxBodyBytes = int64(envInt("MAX_BODY_BYTES", int(cfg.Server.MaxBodyBytes)))
+	cfg.Server.RequestTimeout = Duration(envDurationMs("REQUEST_TIMEOUT_MS", time.Duration(cfg.Server.RequestTimeout)))
+
+	cfg.Redis.Mode = envString("REDIS_MODE", cfg.Redis.Mode)
+	cfg.Redis.Addr = envString("REDIS_ADDR", cfg.Redis.Addr)
+	if v := os.Getenv("REDIS_ADDRS"); v != "" {
+		cfg.Redis.Addrs = splitList(v)
+	}
+	cfg.Redis.MasterName = envString("REDIS_MASTER_NAME", cfg.Redis.MasterName)
+	cfg.Redis.Password = envString("REDIS_PASSWORD", cfg.Redis.Password)
+	cfg.Redis.DB = envInt("REDIS_DB", cfg.Redis.DB)
+	cfg.Redis.ConnectTimeout = Duration(envDurationMs("REDIS_CONNECT_TIMEOUT_MS", time.Duration(cfg.Redis.ConnectTimeout)))
+	cfg.Redis.MaxRetries = envInt("REDIS_MAX_RETRIES", cfg.Redis.MaxRetries)
+	cfg.Redis.MinRetryBackoff = Duration(envDurationMs("REDIS_MIN_RETRY_BACKOFF_MS", time.Duration(cfg.Redis.MinRetryBackoff)))
+	cfg.Redis.MaxRetryBackoff = Duration(envDurationMs("REDIS_MAX_RETRY_BACKOFF_MS", time.Duration(cfg.Redis.MaxRetryBackoff)))
+
+	cfg.Retry.MaxRetries = envInt("RETRY_MAX_RETRIES", cfg.Retry.MaxRetries)
+	cfg.Retry.BaseDelay = Duration(envDurationMs("RETRY_BASE_DELAY_MS", time.Duration(cfg.Retry.BaseDelay)))
+	cfg.Retry.MaxDelay = Duration(envDurationMs("RETRY_MAX_DELAY_MS", time.Duration(cfg.Retry.MaxDelay)))
+
+	cfg.Batch.MaxItems = envInt("BATCH_MAX_ITEMS", cfg.Batch.MaxItems)
+	cfg.Batch.Workers = envInt("BATCH_WORKERS", cfg.Batch.Workers)
+
+	cfg.Webhook.URL = envString("WEBHOOK_URL", cfg.Webhook.URL)
+	cfg.Webhook.Secret = envString("WEBHOOK_SECRET", cfg.Webhook.Secret)
+	cfg.Webhook.MaxAttempts = envInt("WEBHOOK_MAX_ATTEMPTS", cfg.Webhook.MaxAttempts)
+	cfg.Webhook.BaseDelay = Duration(envDurationMs("WEBHOOK_BASE_DELAY_MS", time.Duration(cfg.Webhook.BaseDelay)))
+	cfg.Webhook.MaxDelay = Duration(envDurationMs("WEBHOOK_MAX_DELAY_MS", time.Duration(cfg.Webhook.MaxDelay)))
+	cfg.Webhook.Timeout = Duration(envDurationMs("WEBHOOK_TIMEOUT_MS", time.Duration(cfg.Webhook.Timeout)))
+	cfg.Webhook.QueueSize = envInt("WEBHOOK_QUEUE_SIZE", cfg.Webhook.QueueSize)
+	cfg.Async.Workers = envInt("ASYNC_WORKERS", cfg.Async.Workers)
+	cfg.Async.QueueSize = envInt("ASYNC_QUEUE_SIZE", cfg.Async.QueueSize)
+	cfg.Queue.Enabled = envBool("QUEUE_ENABLED", cfg.Queue.Enabled)
+	cfg.Queue.PollInterval = Duration(envDurationMs("QUEUE_POLL_INTERVAL_MS", time.Duration(cfg.Queue.PollInterval)))
+
+	// API_KEYS is a comma-separated list of client:key pairs, e.g. "checkout:abc,billing:def"
+	if v := os.Getenv("API_KEYS"); v != "" {
+		cfg.Auth.APIKeys = parseAPIKeys(v)
+	}
+	// ADMIN_CLIENTS names the operator clients among them, e.g. "ops"
+	if v := os.Getenv("ADMIN_CLIENTS"); v != "" {
+		cfg.Auth.AdminClients = splitList(v)
+	}
+
+	cfg.RateLimit.Rate = envFloat("RATE_LIMIT_RPS", cfg.RateLimit.Rate)
+	cfg.RateLimit.Burst = envInt("RATE_LIMIT_BURST", cfg.RateLimit.Burst)
+
+	// Standard OpenTelemetry variable names, so existing deployment templates just work
+	cfg.Tracing.Endpoint = envString("OTEL_EXPORTER_OTLP_ENDPOINT", cfg.Tracing.Endpoint)
+	cfg.Tracing.ServiceName = envString("OTEL_SERVICE_NAME", cfg.Tracing.ServiceName)
+
+	// Comma-separated lists, e.g. CORS_ALLOWED_ORIGINS="https://shop.example.com,https://admin.example.com"
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.CORS.AllowedOrigins = splitList(v)
+	}
+	if v := os.Getenv("CORS_ALLOWED_METHODS"); v != "" {
+		cfg.CORS.AllowedMethods = splitList(v)
+	}
+	if v := os.Getenv("CORS_ALLOWED_HEADERS"); v != "" {
+		cfg.CORS.AllowedHeaders = splitList(v)
+	}
+	if v := os.Getenv("TLS_CIPHER_SUITES"); v != "" {
+		cfg.Server.TLS.CipherSuites = splitList(v)
+	}
+	cfg.CORS.MaxAge = Duration(envDuration("CORS_MAX_AGE", time.Duration(cfg.CORS.MaxAge)))
+
+	// Comma-separated ISO 4217 codes, e.g. "ZAR,KES,UGX"
+	if v := os.Getenv("CURRENCIES"); v != "" {
+		cfg.Currencies = splitList(v)
+	}
+
+	cfg.IdempotencyStore = envString("IDEMPOTENCY_STORE", cfg.IdempotencyStore)
+	cfg.Idempotency.InProgressTTL = Duration(envDuration("IDEMPOTENCY_IN_PROGRESS_TTL", time.Duration(cfg.Idempotency.InProgressTTL)))
+	cfg.Idempotency.CompletedTTL = Duration(envDuration("IDEMPOTENCY_COMPLETED_TTL", time.Duration(cfg.Idempotency.CompletedTTL)))
+	cfg.Idempotency.MaxClientTTL = Duration(envDuration("IDEMPOTENCY_MAX_CLIENT_TTL", time.Duration(cfg.Idempotency.MaxClientTTL)))
+	cfg.Idempotency.CacheFailures = envBool("IDEMPOTENCY_CACHE_FAILURES", cfg.Idempotency.CacheFailures)
+	cfg.Idempotency.FailedTTL = Duration(envDuration("IDEMPOTENCY_FAILED_TTL", time.Duration(cfg.Idempotency.FailedTTL)))
+	cfg.Idempotency.InProgressStatus = envInt("IDEMPOTENCY_IN_PROGRESS_STATUS", cfg.Idempotency.InProgressStatus)
+	cfg.Idempotency.FailOpen = envBool("IDEMPOTENCY_FAIL_OPEN", cfg.Idempotency.FailOpen)
+	cfg.Idempotency.Mirror.Addr = envString("IDEMPOTENCY_MIRROR_REDIS_ADDR", cfg.Idempotency.Mirror.Addr)
+	cfg.Idempotency.Mirror.Password = envString("IDEMPOTENCY_MIRROR_REDIS_PASSWORD", cfg.Idempotency.Mirror.Password)
+	cfg.Idempotency.ReadPolicy = envString("IDEMPOTENCY_READ_POLICY", cfg.Idempotency.ReadPolicy)
+	cfg.Idempotency.Quorum = envInt("IDEMPOTENCY_QUORUM", cfg.Idempotency.Quorum)
+	cfg.TransactionIDPattern = envString("TRANSACTION_ID_PATTERN", cfg.TransactionIDPattern)
+	cfg.TransactionIDMaxLength = envInt("TRANSACTION_ID_MAX_LENGTH", cfg.TransactionIDMaxLength)
+	cfg.ProviderTimeout = Duration(envDurationMs("PROVIDER_TIMEOUT_MS", time.Duration(cfg.ProviderTimeout)))
+	cfg.ProviderMinRemaining = Duration(envDurationMs("PROVIDER_MIN_REMAINING_MS", time.Duration(cfg.ProviderMinRemaining)))
+	cfg.HealthProbeInterval = Duration(envDurationMs("HEALTH_PROBE_INTERVAL_MS", time.Duration(cfg.HealthProbeInterval)))
+	cfg.DailyCapTimezone = envString("DAILY_CAP_TIMEZONE", cfg.DailyCapTimezone)
+	cfg.SplitMaxLegs = envInt("SPLIT_MAX_LEGS", cfg.SplitMaxLegs)
+	cfg.DeadLetterMaxEntries = envInt("DEAD_LETTER_MAX_ENTRIES", cfg.DeadLetterMaxEntries)
+	cfg.DryRun = envBool("DRY_RUN", cfg.DryRun)
+	cfg.SimulatorMode = envBool("SIMULATOR_MODE", cfg.SimulatorMode)
+	cfg.LoadBalancerSeed = uint64(envInt("LOAD_BALANCER_SEED", int(cfg.LoadBalancerSeed)))
+	cfg.AdaptiveRouting = envBool("ADAPTIVE_ROUTING", cfg.AdaptiveRouting)
+	cfg.LatencyAlpha = envFloat("LATENCY_ALPHA", cfg.LatencyAlpha)
+
+	// Per-provider overrides, e.g. AIRTEL_TIMEOUT_MS or MTN_BREAKER_FAILURE_RATIO
+	for key, p := range cfg.Providers {
+		prefix := strings.ToUpper(key)
+		p.Timeout = Duration(envDurationMs(prefix+"_TIMEOUT_MS", time.Duration(p.Timeout)))
+		p.Type = envString(prefix+"_TYPE", p.Type)
+		p.BaseURL = envString(prefix+"_BASE_URL", p.BaseURL)
+
+		b := &p.Breaker
+		b.MaxRequests = uint32(envInt(prefix+"_BREAKER_MAX_REQUESTS", int(b.MaxRequests)))
+		b.Timeout = Duration(envDurationMs(prefix+"_BREAKER_TIMEOUT_MS", time.Duration(b.Timeout)))
+		b.Interval = Duration(envDurationMs(prefix+"_BREAKER_INTERVAL_MS", time.Duration(b.Interval)))
+		b.MinRequests = uint32(envInt(prefix+"_BREAKER_MIN_REQUESTS", int(b.MinRequests)))
+		b.FailureRatio = envFloat(prefix+"_BREAKER_FAILURE_RATIO", b.FailureRatio)
+		b.CountBusinessErrors = envBool(prefix+"_BREAKER_COUNT_BUSINESS_ERRORS", b.CountBusinessErrors)
+
+		p.MinAmount = envFloat(prefix+"_MIN_AMOUNT", p.MinAmount)
+		p.MaxAmount = envFloat(prefix+"_MAX_AMOUNT", p.MaxAmount)
+		p.Fee.Flat = envFloat(prefix+"_FEE_FLAT", p.Fee.Flat)
+		p.Fee.Percent = envFloat(prefix+"_FEE_PERCENT", p.Fee.Percent)
+		p.DailyMaxAmount = envFloat(prefix+"_DAILY_MAX_AMOUNT", p.DailyMaxAmount)
+		p.DailyMaxCount = envInt(prefix+"_DAILY_MAX_COUNT", p.DailyMaxCount)
+		p.Weight = envInt(prefix+"_WEIGHT", p.Weight)
+		p.MaxConcurrent = envInt(prefix+"_MAX_CONCURRENT", p.MaxConcurrent)
+
+		cfg.Providers[key] = p
+	}
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseAPIKeys reads "client:key" pairs separated by commas, skipping malformed entries.
+func parseAPIKeys(v string) map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		client, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || client == "" || key == "" {
+			slog.Warn("ignoring malformed API_KEYS entry", "client", client)
+			continue
+		}
+		keys[client] = key
+	}
+	return keys
+}
+
+// envString returns the named environment variable, or fallback when it is unset.
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envBool reads a boolean ("true", "1", "false", ...) from the named environment
+// variable, returning fallback when it is unset or invalid.
+func envBool(name string, fallback bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("ignoring invalid environment value", "name", name, "value", v, "using", fallback)
+		return fallback
+	}
+	return b
+}
+
+// envInt reads a non-negative integer from the named environment variable,
+// returning fallback when it is unset or invalid.
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("ignoring invalid environment value", "name", name, "value", v, "using", fallback)
+		return fallback
+	}
+	return n
+}
+
+// envFloat reads a non-negative float from the named environment variable,
+// returning fallback when it is unset or invalid.
+func envFloat(name string, fallback float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		slog.Warn("ignoring invalid environment value", "name", name, "value", v, "using", fallback)
+		return fallback
+	}
+	return f
+}
+
+// envDurationMs reads a whole number of milliseconds from the named environment
+// variable, returning fallback when it is unset or invalid.
+func envDurationMs(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		slog.Warn("ignoring invalid environment value", "name", name, "value", v, "using", fallback.String())
+		return fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// envDuration reads a Go duration string (e.g. "15s") from the named environment
+// variable, returning fallback when it is unset or invalid.
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		slog.Warn("ignoring invalid environment value", "name", name, "value", v, "using", fallback.String())
+		return fallback
+	}
+	return d
+}
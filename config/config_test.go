@@ -0,0 +1,171 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeFile writes contents to a config file in a temporary directory and returns its path.
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadWithoutFileIsDefault(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := Default(); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Load(\"\") = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestDefaultRoundTripsThroughAFile(t *testing.T) {
+	data, err := json.Marshal(Default())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	cfg, err := Load(writeFile(t, string(data)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := Default(); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("round trip = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadExampleFile(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "config.example.json"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.Port != "8080" {
+		t.Errorf("Server.Port = %q, want 8080", cfg.Server.Port)
+	}
+	if got := time.Duration(cfg.Idempotency.CompletedTTL); got != 24*time.Hour {
+		t.Errorf("Idempotency.CompletedTTL = %s, want 24h", got)
+	}
+}
+
+func TestLoadPartialFileKeepsDefaults(t *testing.T) {
+	cfg, err := Load(writeFile(t, `{
+		"server": {"port": "9090"},
+		"providers": {"MTN": {"maxAmount": 500}}
+	}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	def := Default()
+
+	if cfg.Server.Port != "9090" {
+		t.Errorf("Server.Port = %q, want 9090", cfg.Server.Port)
+	}
+	if cfg.Server.MaxBodyBytes != def.Server.MaxBodyBytes {
+		t.Errorf("Server.MaxBodyBytes = %d, want default %d", cfg.Server.MaxBodyBytes, def.Server.MaxBodyBytes)
+	}
+	if cfg.Retry != def.Retry {
+		t.Errorf("Retry = %+v, want default %+v", cfg.Retry, def.Retry)
+	}
+
+	mtn := cfg.Providers["MTN"]
+	if mtn.MaxAmount != 500 {
+		t.Errorf("MTN MaxAmount = %v, want 500", mtn.MaxAmount)
+	}
+	if mtn.Breaker != DefaultBreaker || mtn.MaxConcurrent != DefaultMaxConcurrent {
+		t.Errorf("MTN entry missing defaults: %+v", mtn)
+	}
+	if _, ok := cfg.Providers["AIRTEL"]; !ok {
+		t.Error("AIRTEL entry dropped by a file that only mentions MTN")
+	}
+}
+
+func TestLoadProviderEnvOverrides(t *testing.T) {
+	t.Setenv("MTN_TIMEOUT_MS", "1500")
+	t.Setenv("MTN_MAX_CONCURRENT", "7")
+	t.Setenv("MTN_ZM_TIMEOUT_MS", "2500")
+	t.Setenv("AIRTEL_TIMEOUT_MS", "3500")
+
+	cfg, err := Load(writeFile(t, `{
+		"providers": {
+			"MTN": {"maxAmount": 500},
+			"MTN_ZM": {"baseURL": "https://zm.example.com"},
+			"AIRTEL": {"timeout": "4s"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		key     string
+		timeout time.Duration
+	}{
+		{"MTN", 1500 * time.Millisecond},    // Env fills in what the file leaves out
+		{"MTN_ZM", 2500 * time.Millisecond}, // A key only the file declares
+		{"AIRTEL", 4 * time.Second},         // The file wins over env
+	}
+	for _, tt := range tests {
+		if got := cfg.ProviderTimeoutFor(tt.key); got != tt.timeout {
+			t.Errorf("%s timeout = %s, want %s", tt.key, got, tt.timeout)
+		}
+	}
+	if got := cfg.Providers["MTN"]; got.MaxAmount != 500 || got.MaxConcurrent != 7 {
+		t.Errorf("MTN = %+v, want MaxAmount 500 from the file and MaxConcurrent 7 from env", got)
+	}
+	if got := cfg.Providers["MTN_ZM"].BaseURL; got != "https://zm.example.com" {
+		t.Errorf("MTN_ZM BaseURL = %q", got)
+	}
+}
+
+func TestLoadRetryEnv(t *testing.T) {
+	t.Setenv("RETRY_MAX_RETRIES", "4")
+	t.Setenv("RETRY_MAX_DELAY_MS", "750")
+	t.Setenv("WEBHOOK_MAX_DELAY_MS", "30000")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Retry.MaxRetries != 4 || cfg.Retry.MaxDelay != Duration(750*time.Millisecond) {
+		t.Errorf("Retry = %+v, want 4 retries capped at 750ms", cfg.Retry)
+	}
+	if cfg.Webhook.MaxDelay != Duration(30*time.Second) {
+		t.Errorf("Webhook.MaxDelay = %s, want 30s", time.Duration(cfg.Webhook.MaxDelay))
+	}
+}
+
+func TestLoadRaisesATinyIdleTTL(t *testing.T) {
+	cfg, err := Load(writeFile(t, `{"rateLimit": {"rate": 10, "idleTTL": "1ns"}}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.RateLimit.IdleTTL != MinRateLimitIdleTTL {
+		t.Errorf("IdleTTL = %s, want the %s minimum", time.Duration(cfg.RateLimit.IdleTTL), time.Duration(MinRateLimitIdleTTL))
+	}
+}
+
+func TestLoadRejectsMalformedFiles(t *testing.T) {
+	for name, contents := range map[string]string{
+		"not JSON":          `{`,
+		"bad duration":      `{"providerTimeout": "soon"}`,
+		"bad provider type": `{"providers": {"MTN": {"maxAmount": "lots"}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(writeFile(t, contents)); err == nil {
+				t.Error("Load: no error")
+			}
+		})
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load of a missing file: no error")
+	}
+}
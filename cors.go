@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/requestid"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers browser code may read.
+var corsExposedHeaders = strings.Join([]string{
+	requestid.Header,
+	idempotencyStatusHeader,
+	providerHeader,
+	providerLatencyHeader,
+	providerTimeoutHeader,
+	attemptsHeader,
+	"Retry-After",
+}, ", ")
+
+// withCORS lets browser clients on the configured origins call the API. Preflight
+// (OPTIONS with Access-Control-Request-Method) is answered here with 204, before
+// authentication, since browsers never send credentials on a preflight. Requests
+// from other origins get no CORS headers, so the browser blocks them. With no
+// allowed origins the middleware is a pass-through.
+func withCORS(cfg config.CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(time.Duration(cfg.MaxAge).Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The answer depends on Origin, so shared caches must key on it
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(cfg.AllowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(corsAllowWriter{w}, r)
+	})
+}
+
+// corsAllowWriter adds OPTIONS to the Allow header of a 405, since preflight
+// requests are answered here rather than by the handler.
+type corsAllowWriter struct {
+	http.ResponseWriter
+}
+
+func (w corsAllowWriter) WriteHeader(status int) {
+	if allow := w.Header().Get("Allow"); status == http.StatusMethodNotAllowed && allow != "" {
+		w.Header().Set("Allow", allow+", OPTIONS")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w corsAllowWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"payment-gateway-aggregator/cache"
+	"time"
+
+	// The runtime image has no zoneinfo, so embed it for DailyCapLocation
+	_ "time/tzdata"
+)
+
+// DailyCap is a provider's ceiling on successful payments per day, e.g. the
+// volume its contract allows. A zero field is unbounded.
+type DailyCap struct {
+	MaxAmount float64 `json:"maxAmount,omitempty"`
+	MaxCount  int64   `json:"maxCount,omitempty"`
+}
+
+// Enabled reports whether either side of the cap is set.
+func (c DailyCap) Enabled() bool {
+	return c.MaxAmount > 0 || c.MaxCount > 0
+}
+
+// Allows reports whether one more payment of amount stays within the cap, given
+// the day's usage so far. Reaching the cap exactly is allowed.
+func (c DailyCap) Allows(usage cache.Usage, amount float64) bool {
+	if c.MaxCount > 0 && usage.Count+1 > c.MaxCount {
+		return false
+	}
+	if c.MaxAmount > 0 && usage.Amount+amount > c.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// String describes the cap for error messages, e.g. "5000.00 or 100 payments a day".
+func (c DailyCap) String() string {
+	switch {
+	case c.MaxAmount > 0 && c.MaxCount > 0:
+		return fmt.Sprintf("%.2f or %d payments a day", c.MaxAmount, c.MaxCount)
+	case c.MaxAmount > 0:
+		return fmt.Sprintf("%.2f a day", c.MaxAmount)
+	default:
+		return fmt.Sprintf("%d payments a day", c.MaxCount)
+	}
+}
+
+// usageDay returns the day usage is counted under at now, and when that day ends,
+// in DailyCapLocation (UTC if unset).
+func (a *Aggregator) usageDay(now time.Time) (day string, end time.Time) {
+	loc := a.DailyCapLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	y, m, d := now.Date()
+	return now.Format("2006-01-02"), time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
+
+// withinDailyCap reports whether providerName can take a payment of amount today.
+// Caps are enforced on a best-effort basis: usage is read before the call and
+// added after it succeeds, so concurrent payments can overshoot slightly, and an
+// unreadable usage store lets the payment through.
+func (a *Aggregator) withinDailyCap(ctx context.Context, providerName string, amount float64) bool {
+	limit := a.DailyCaps[providerName]
+	if a.Usage == nil || !limit.Enabled() {
+		return true
+	}
+	day, _ := a.usageDay(time.Now())
+	usage, err := a.Usage.GetUsage(ctx, providerName, day)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read provider usage, not enforcing daily cap", "provider", providerName, "error", err)
+		return true
+	}
+	return limit.Allows(usage, amount)
+}
+
+// routeAtDailyCap reports whether every provider on route has reached its daily
+// cap for a payment of amount, so none could take it today.
+func (a *Aggregator) routeAtDailyCap(ctx context.Context, route []string, amount float64) bool {
+	for _, candidate := range route {
+		if _, ok := a.Providers[candidate]; ok && a.withinDailyCap(ctx, candidate, amount) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordUsage counts a successful payment towards providerName's daily totals.
+func (a *Aggregator) recordUsage(ctx context.Context, providerName string, amount float64) {
+	if a.Usage == nil {
+		return
+	}
+	day, _ := a.usageDay(time.Now())
+	if err := a.Usage.AddUsage(ctx, providerName, day, amount); err != nil {
+		slog.WarnContext(ctx, "failed to record provider usage", "provider", providerName, "error", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"payment-gateway-aggregator/requestid"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+// deadLetter keeps a payment that failed after every retry and fallback (a
+// provider error or a timeout) for investigation and manual reprocessing.
+// Failures are logged but never change the payment's own response.
+func (a *Aggregator) deadLetter(ctx context.Context, req providers.PaymentRequest, providerName string, status int, cause error) {
+	if a.DeadLetters == nil {
+		return
+	}
+
+	err := a.DeadLetters.AddDeadLetter(ctx, cache.DeadLetter{
+		Key:       txnKey(ctx, req.TransactionID),
+		Request:   req,
+		Provider:  providerName,
+		Status:    status,
+		Error:     cause.Error(),
+		RequestID: requestid.FromContext(ctx),
+		FailedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to write dead letter", "transaction_id", req.TransactionID, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "payment dead-lettered", "transaction_id", req.TransactionID, "provider", providerName, "status", status)
+}
+
+// DeadLetterHandler lists the most recent dead letters, newest first: the caller's
+// own tenant's, or every tenant's for an admin client (see requireAdmin).
+// GET /v1/deadletter?limit=100 (limit defaults to 100)
+func (a *Aggregator) DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	if a.DeadLetters == nil {
+		writeJSON(w, http.StatusNotImplemented, messageResponse{Error: "Dead-letter store is not enabled"})
+		return
+	}
+
+	limit := defaultDeadLetterLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeadLetterLimit {
+			writeJSON(w, http.StatusBadRequest, messageResponse{
+				Error:   "Invalid limit",
+				Message: "limit must be between 1 and " + strconv.Itoa(maxDeadLetterLimit) + ".",
+			})
+			return
+		}
+		limit = n
+	}
+
+	// The store holds every tenant's entries; read as far back as allowed to find
+	// limit of the caller's own
+	admin := isAdmin(r.Context())
+	fetch := limit
+	if !admin {
+		fetch = maxDeadLetterLimit
+	}
+	letters, err := a.DeadLetters.ListDeadLetters(r.Context(), fetch)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read dead letters", "error", err)
+		writeJSON(w, http.StatusInternalServerError, messageResponse{Error: "Failed to read dead letters"})
+		return
+	}
+	if !admin {
+		tenant := tenantFromContext(r.Context())
+		letters = slices.DeleteFunc(letters, func(d cache.DeadLetter) bool { return d.Key.Tenant != tenant })
+		letters = letters[:min(limit, len(letters))]
+	}
+
+	writeJSON(w, http.StatusOK, letters)
+}
+
+// ReprocessHandler runs a dead-lettered payment again, under its original
+// TransactionID, and responds as /v1/pay would. The entry is removed once the
+// payment goes through (or turns out to have completed meanwhile); a payment
+// that fails again stays, with the new error.
+// POST /v1/deadletter/{id} -> the payment's response, 404 if there is no such entry.
+func (a *Aggregator) ReprocessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	transactionID := strings.TrimPrefix(r.URL.Path, "/v1/deadletter/")
+	if transactionID == "" || strings.Contains(transactionID, "/") {
+		writeJSON(w, http.StatusBadRequest, messageResponse{Error: "Missing or invalid transaction ID"})
+		return
+	}
+	if a.DeadLetters == nil {
+		writeJSON(w, http.StatusNotImplemented, messageResponse{Error: "Dead-letter store is not enabled"})
+		return
+	}
+
+	key := txnKey(ctx, transactionID)
+	letter, err := a.DeadLetters.GetDeadLetter(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read dead letter", "transaction_id", transactionID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, messageResponse{Error: "Failed to read dead letter"})
+		return
+	}
+	if letter == nil {
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, "Not Found",
+			fmt.Sprintf("No dead letter is held for transaction %s.", transactionID)))
+		return
+	}
+
+	slog.InfoContext(ctx, "reprocessing dead letter", "transaction_id", transactionID, "failed_at", letter.FailedAt, "original_request_id", letter.RequestID)
+	out := a.pay(ctx, letter.Request)
+	if out.StatusCode == http.StatusOK {
+		if err := a.DeadLetters.RemoveDeadLetter(ctx, key); err != nil {
+			slog.WarnContext(ctx, "failed to remove reprocessed dead letter", "transaction_id", transactionID, "error", err)
+		}
+	}
+	writeOutcome(w, out)
+}
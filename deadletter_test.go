@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"slices"
+	"testing"
+)
+
+func TestDeadLettersAreScopedToTheClient(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key", "ops": "ops-key"}
+	cfg.Auth.AdminClients = []string{"ops"}
+	env := newTestEnv(t, cfg)
+	env.mtn.err = providers.ErrProviderInternal
+	env.airtel.err = providers.ErrProviderInternal
+	h := env.handler(t, cfg)
+
+	for _, p := range []struct{ id, key string }{{"TXN-SHOP", "shop-key"}, {"TXN-OTHER", "other-key"}} {
+		if rec := do(t, h, "POST", "/v1/pay", payment(p.id, 10), "X-API-Key", p.key); rec.Code != http.StatusBadGateway {
+			t.Fatalf("pay %s: status %d, want 502", p.id, rec.Code)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+		ids     []string
+	}{
+		{"no API key", nil, http.StatusUnauthorized, nil},
+		{"client", []string{"X-API-Key", "shop-key"}, http.StatusOK, []string{"TXN-SHOP"}},
+		{"client claiming another tenant", []string{"X-API-Key", "shop-key", "X-Tenant-ID", "other"}, http.StatusOK, []string{"TXN-SHOP"}},
+		{"admin client", []string{"X-API-Key", "ops-key"}, http.StatusOK, []string{"TXN-OTHER", "TXN-SHOP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "GET", "/v1/deadletter", nil, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var letters []cache.DeadLetter
+			decode(t, rec, &letters)
+			var ids []string
+			for _, d := range letters {
+				ids = append(ids, d.Key.TransactionID)
+				if d.Status != http.StatusBadGateway {
+					t.Errorf("%s: status %d, want 502", d.Key.TransactionID, d.Status)
+				}
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("dead letters for %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+
+	// Another client can't reprocess the shop's entry either
+	if rec := do(t, h, "POST", "/v1/deadletter/TXN-SHOP", nil, "X-API-Key", "other-key"); rec.Code != http.StatusNotFound {
+		t.Errorf("reprocess by another client: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// breakerDebugInfo is one entry in the GET /debug/breakers listing.
+type breakerDebugInfo struct {
+	Name              string        `json:"name"`
+	Provider          string        `json:"provider"`
+	State             string        `json:"state"`
+	Counts            breakerCounts `json:"counts"`
+	RetryAfterSeconds int           `json:"retryAfterSeconds,omitempty"` // Only while open
+}
+
+// BreakersDebugHandler shows every breaker's live counts for the current interval,
+// to help tune ReadyToTrip (why a breaker tripped, or didn't). Read-only, for admin
+// clients (see requireAdmin).
+// GET /debug/breakers
+func (a *Aggregator) BreakersDebugHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	list := make([]breakerDebugInfo, 0, len(a.Breakers))
+	for key, breaker := range a.Breakers {
+		counts := breaker.Counts()
+		info := breakerDebugInfo{
+			Name:     breaker.Name(),
+			Provider: key,
+			State:    breaker.State().String(),
+			Counts: breakerCounts{
+				Requests:             counts.Requests,
+				TotalSuccesses:       counts.TotalSuccesses,
+				TotalFailures:        counts.TotalFailures,
+				ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+				ConsecutiveFailures:  counts.ConsecutiveFailures,
+			},
+		}
+		if wait := openings.retryAfter(breaker.Name()); wait > 0 {
+			info.RetryAfterSeconds = retryAfterSeconds(wait)
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	writeJSON(w, http.StatusOK, list)
+}
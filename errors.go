@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"payment-gateway-aggregator/requestid"
+)
+
+// Stable, machine-readable error codes. Clients branch on these rather than on
+// the HTTP status or the message; never change an existing value.
+const (
+	codeMethodNotAllowed       = "METHOD_NOT_ALLOWED"
+	codeUnsupportedMediaType   = "UNSUPPORTED_MEDIA_TYPE"
+	codeBodyTooLarge           = "BODY_TOO_LARGE"
+	codeInvalidBody            = "INVALID_REQUEST_BODY"
+	codeIdempotencyKeyConflict = "IDEMPOTENCY_KEY_CONFLICT"
+	codeValidationFailed       = "VALIDATION_FAILED"
+	codeUnsupportedCurrency    = "UNSUPPORTED_CURRENCY"
+	codeProviderNotFound       = "PROVIDER_NOT_FOUND"
+	codeAmountOutOfRange       = "AMOUNT_OUT_OF_RANGE"
+	codeDailyLimitReached      = "DAILY_LIMIT_REACHED"
+	codeIdempotencyKeyReused   = "IDEMPOTENCY_KEY_REUSED"
+	codeStoreUnavailable       = "STORE_UNAVAILABLE"
+	codeTransactionInProgress  = "TRANSACTION_IN_PROGRESS"
+	codeDuplicateTransaction   = "DUPLICATE_TRANSACTION"
+	codeTransactionCancelled   = "TRANSACTION_CANCELLED"
+	codeProviderUnavailable    = "PROVIDER_UNAVAILABLE"
+	codeProviderAtCapacity     = "PROVIDER_AT_CAPACITY"
+	codePaymentDeclined        = "PAYMENT_DECLINED"
+	codeProviderError          = "PROVIDER_ERROR"
+	codeServiceBusy            = "SERVICE_BUSY"
+	codeNotFound               = "NOT_FOUND"
+	codeNotImplemented         = "NOT_IMPLEMENTED"
+	codeProviderTimeout        = "PROVIDER_TIMEOUT"
+	codeInvalidBatchSize       = "INVALID_BATCH_SIZE"
+	codeAmountExceedsPayment   = "AMOUNT_EXCEEDS_PAYMENT"
+	codeAlreadyRefunded        = "ALREADY_REFUNDED"
+	codeRefundNeedsReconcile   = "REFUND_NEEDS_RECONCILIATION"
+	codeTransactionCompleted   = "TRANSACTION_COMPLETED"
+	codeTransactionChanged     = "TRANSACTION_STATE_CHANGED"
+	codeNotCancellable         = "TRANSACTION_NOT_CANCELLABLE"
+	codeInvalidTenant          = "INVALID_TENANT"
+	codeInternal               = "INTERNAL_ERROR"
+)
+
+// ErrorResponse is the body of every error the payment, refund and transaction
+// endpoints return. Error is
+// the short title older clients already read; the optional fields below it only
+// appear on the errors they describe.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"requestID,omitempty"`
+
+	RetryAfterSeconds   int          `json:"retryAfterSeconds,omitempty"`
+	SupportedCurrencies []string     `json:"supportedCurrencies,omitempty"`
+	Limits              *AmountLimit `json:"limits,omitempty"`
+}
+
+// apiError returns an ErrorResponse for code with a human-readable title and message.
+func apiError(code, title, message string) *ErrorResponse {
+	return &ErrorResponse{Code: code, Error: title, Message: message}
+}
+
+// writeError sends e with status, stamped with the request's X-Request-ID (set on
+// the response by withRequestID) so a client report can be matched to our logs.
+func writeError(w http.ResponseWriter, status int, e *ErrorResponse) {
+	stamped := *e
+	stamped.RequestID = w.Header().Get(requestid.Header)
+	writeJSON(w, status, stamped)
+}
+
+// writeOutcome sends a payment outcome: its headers, status and body. Error bodies
+// go through writeError so they carry this request's ID, even when the outcome
+// was shared with a concurrent request for the same transaction (see pay).
+func writeOutcome(w http.ResponseWriter, out paymentOutcome) {
+	for key, values := range out.Header {
+		w.Header()[key] = values
+	}
+	if e, ok := out.Body.(*ErrorResponse); ok {
+		writeError(w, out.StatusCode, e)
+		return
+	}
+	writeJSON(w, out.StatusCode, out.Body)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+)
+
+// cacheFailure stores a definite failure (a decline, or a structured FAILED response)
+// against the transaction's key for the short failure TTL when CacheFailures is on, so
+// a client retrying straight away gets the same answer without another provider call.
+// Without it the IN_PROGRESS lease simply expires and the retry runs again.
+func (a *Aggregator) cacheFailure(ctx context.Context, key cache.TxnKey, req providers.PaymentRequest, res *providers.PaymentResponse) {
+	if !a.CacheFailures {
+		return
+	}
+	if err := a.Store.SetFailed(ctx, key, res); err != nil {
+		slog.WarnContext(ctx, "failed to cache payment failure", "transaction_id", req.TransactionID, "error", err)
+	}
+}
+
+// failureStatusCode is the status a cached failure is replayed with, matching the
+// live response: 402 for a decline, 502 otherwise.
+func failureStatusCode(res *providers.PaymentResponse) int {
+	if res.Status == providers.StatusDeclined {
+		return http.StatusPaymentRequired
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"payment-gateway-aggregator/providers"
+)
+
+// FeeSchedule is what a provider charges per payment: a flat amount plus a
+// percentage of the payment amount. The zero value charges nothing.
+type FeeSchedule struct {
+	Flat    float64 `json:"flat,omitempty"`
+	Percent float64 `json:"percent,omitempty"` // e.g. 1.5 for 1.5%
+}
+
+// Fee returns the fee on amountMinor, both in currency's minor unit. The percentage
+// is rounded to a whole minor unit and the fee is capped at the amount itself.
+func (f FeeSchedule) Fee(amountMinor int64, currency string) int64 {
+	flat, _ := providers.ToMinorUnits(f.Flat, currency)
+	percent := int64(math.Round(float64(amountMinor) * f.Percent / 100))
+	return min(flat+percent, amountMinor)
+}
+
+// feeSchedule returns the configured fees for a provider (none if unset).
+func (a *Aggregator) feeSchedule(providerName string) FeeSchedule {
+	return a.Fees[providerName]
+}
+
+// applyFee fills in the amount charged, the fee the serving provider takes from
+// it and the amount left once that is deducted.
+func (a *Aggregator) applyFee(ctx context.Context, req providers.PaymentRequest, providerName string, res *providers.PaymentResponse) {
+	// Computed in minor units so the fee and net amount add up to the amount exactly
+	fee := a.feeSchedule(providerName).Fee(req.AmountMinor, req.Currency)
+	res.Amount = req.Amount
+	res.Fee = providers.FromMinorUnits(fee, req.Currency)
+	res.NetAmount = providers.FromMinorUnits(req.AmountMinor-fee, req.Currency)
+	if fee > 0 {
+		slog.DebugContext(ctx, "fee applied", "transaction_id", req.TransactionID, "provider", providerName, "fee", res.Fee)
+	}
+}
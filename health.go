@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// HealthHandler is the liveness/readiness probe for load balancers and orchestrators.
+// It returns 200 when Redis is reachable and no circuit breaker is Open, 503 otherwise.
+// With ?deep=true it also runs every provider's HealthCheck and reports the results;
+// those are informational and don't change the status, since the breakers already
+// take unreachable providers out of rotation.
+func (a *Aggregator) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	healthy := true
+
+	// Keep the Redis check short so the probe itself never hangs
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	redisStatus := "UP"
+	if err := a.Store.Ping(ctx); err != nil {
+		slog.WarnContext(r.Context(), "health check: Redis unreachable", "error", err)
+		redisStatus = "DOWN"
+		healthy = false
+	}
+
+	// Report every breaker by name so operators can see which provider tripped
+	breakers := make(map[string]string, len(a.Breakers))
+	for _, breaker := range a.Breakers {
+		state := breaker.State()
+		breakers[breaker.Name()] = state.String()
+		if state == gobreaker.StateOpen {
+			healthy = false
+		}
+	}
+
+	status, code := "UP", http.StatusOK
+	if !healthy {
+		status, code = "DOWN", http.StatusServiceUnavailable
+	}
+
+	body := map[string]interface{}{
+		"status":   status,
+		"redis":    redisStatus,
+		"breakers": breakers,
+	}
+	if r.URL.Query().Get("deep") == "true" {
+		body["providers"] = a.providerHealth(ctx)
+	}
+	writeJSON(w, code, body)
+}
+
+// providerHealth runs every provider's HealthCheck concurrently and maps each
+// provider key to "UP" or "DOWN".
+func (a *Aggregator) providerHealth(ctx context.Context) map[string]string {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]string, len(a.Providers))
+	)
+	for key, provider := range a.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := "UP"
+			if err := provider.HealthCheck(ctx); err != nil {
+				slog.WarnContext(ctx, "health check: provider unreachable", "provider", key, "error", err)
+				status = "DOWN"
+			}
+			mu.Lock()
+			results[key] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
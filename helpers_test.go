@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// stubProvider answers every call at once with a fixed outcome, or with process
+// when set, and counts the payments it was asked to make.
+type stubProvider struct {
+	name    string
+	err     error                                                                                       // Returned by ProcessPayment (with a FAILED response)
+	process func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) // Overrides err
+	refund  func(ctx context.Context, req providers.RefundRequest) (*providers.RefundResponse, error)   // Replaces the default success
+	calls   atomic.Int32
+}
+
+func newStubProvider(name string) *stubProvider {
+	return &stubProvider{name: name}
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) ProcessPayment(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+	p.calls.Add(1)
+	if p.process != nil {
+		return p.process(ctx, req)
+	}
+	if p.err != nil {
+		return &providers.PaymentResponse{Status: "FAILED", ReferenceID: "N/A", ProviderName: p.name, Message: p.err.Error()}, p.err
+	}
+	return &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "REF-" + req.TransactionID, ProviderName: p.name}, nil
+}
+
+func (p *stubProvider) Refund(ctx context.Context, req providers.RefundRequest) (*providers.RefundResponse, error) {
+	if p.refund != nil {
+		return p.refund(ctx, req)
+	}
+	return &providers.RefundResponse{Status: "SUCCESS", RefundID: "RF-" + req.TransactionID, ProviderName: p.name}, nil
+}
+
+func (p *stubProvider) Authorize(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+	return &providers.PaymentResponse{Status: providers.StatusAuthorized, ReferenceID: "AUTH-" + req.TransactionID, ProviderName: p.name}, nil
+}
+
+func (p *stubProvider) Capture(ctx context.Context, req providers.CaptureRequest) (*providers.PaymentResponse, error) {
+	return &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "CAP-" + req.AuthorizationID, ProviderName: p.name}, nil
+}
+
+func (p *stubProvider) HealthCheck(ctx context.Context) error { return nil }
+
+// testConfig is the default configuration without retry backoff, so failure paths run fast.
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.IdempotencyStore = "memory"
+	cfg.Retry.MaxRetries = 0
+	return cfg
+}
+
+// testEnv is an Aggregator over a MemoryStore and stub MTN and AIRTEL providers.
+type testEnv struct {
+	a      *Aggregator
+	store  *cache.MemoryStore
+	mtn    *stubProvider
+	airtel *stubProvider
+}
+
+// newTestEnv builds a testEnv from cfg, stopping its background workers when the test ends.
+// As in newDependencies, the store takes cfg's TTLs and holds the payment queue only
+// when cfg.Queue is enabled.
+func newTestEnv(t testing.TB, cfg config.Config) *testEnv {
+	t.Helper()
+	store := cache.NewMemoryStore(cache.Options{
+		InProgressTTL: time.Duration(cfg.Idempotency.InProgressTTL),
+		CompletedTTL:  time.Duration(cfg.Idempotency.CompletedTTL),
+		FailedTTL:     time.Duration(cfg.Idempotency.FailedTTL),
+	})
+	env := &testEnv{store: store, mtn: newStubProvider("MTN_MOMO"), airtel: newStubProvider("AIRTEL_MONEY")}
+	deps := Dependencies{
+		Store:       store,
+		Audit:       store,
+		Usage:       store,
+		Switches:    store,
+		DeadLetters: store,
+		Providers:   map[string]providers.PaymentProvider{"MTN": env.mtn, "AIRTEL": env.airtel},
+	}
+	if cfg.Queue.Enabled {
+		deps.Queue = store
+	}
+	a, err := NewAggregator(cfg, deps)
+	if err != nil {
+		t.Fatalf("NewAggregator: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		a.Async.Close(ctx)
+		a.Webhooks.Close(ctx)
+	})
+	env.a = a
+	return env
+}
+
+// tripBreaker fails calls to the named provider ("MTN" or "AIRTEL") until its
+// breaker opens, then lets its stub succeed again.
+func (env *testEnv) tripBreaker(t testing.TB, name string) {
+	t.Helper()
+	stub := map[string]*stubProvider{"MTN": env.mtn, "AIRTEL": env.airtel}[name]
+	stub.err = providers.ErrProviderInternal
+	defer func() { stub.err = nil }()
+	for i := 0; env.a.Breakers[name].State() != gobreaker.StateOpen; i++ {
+		if i == 10 {
+			t.Fatalf("%s breaker never opened", name)
+		}
+		env.a.callProvider(context.Background(), name, payment("TXN-TRIP", 10))
+	}
+}
+
+// handler returns every route, as main serves them.
+func (env *testEnv) handler(t testing.TB, cfg config.Config) http.Handler {
+	t.Helper()
+	limiter := newRateLimiter(cfg.RateLimit)
+	t.Cleanup(limiter.Close)
+	return withRequestID(newMux(cfg, env.a, limiter))
+}
+
+// do sends a request through h and returns the recorded response. A non-nil body
+// is sent as JSON; headers are set as given, in pairs.
+func do(t testing.TB, h http.Handler, method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encoding request body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a recorded JSON response into v.
+func decode(t testing.TB, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// payment is a valid ZAR payment routed to MTN.
+func payment(transactionID string, amount float64) providers.PaymentRequest {
+	return providers.PaymentRequest{TransactionID: transactionID, Amount: amount, Currency: "ZAR"}
+}
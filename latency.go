@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"payment-gateway-aggregator/providers"
+	"sync"
+	"time"
+)
+
+// latencyTracker keeps an exponentially weighted moving average of each provider's
+// call duration, retries included. It lives in memory, so every instance learns
+// from its own traffic and starts afresh on restart.
+type latencyTracker struct {
+	mu    sync.Mutex
+	alpha float64                  // Weight of the newest sample, 0 < alpha <= 1
+	ewma  map[string]time.Duration // Keyed by provider key; absent until its first sample
+}
+
+// newLatencyTracker returns a tracker that gives each new sample weight alpha.
+func newLatencyTracker(alpha float64) *latencyTracker {
+	return &latencyTracker{alpha: alpha, ewma: make(map[string]time.Duration)}
+}
+
+// observe folds one call's duration into the provider's average. Calls that say
+// nothing about the provider's speed (skipped for lack of time, or abandoned by the
+// client) are ignored; timeouts count, so a provider that hangs ranks as slow.
+func (t *latencyTracker) observe(provider string, d time.Duration, err error) {
+	if t == nil || errors.Is(err, providers.ErrNoTimeLeft) || errors.Is(err, context.Canceled) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if avg, ok := t.ewma[provider]; ok {
+		d = time.Duration(t.alpha*float64(d) + (1-t.alpha)*float64(avg))
+	}
+	t.ewma[provider] = d
+}
+
+// average returns the provider's current average; ok is false before its first sample.
+func (t *latencyTracker) average(provider string) (avg time.Duration, ok bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	avg, ok = t.ewma[provider]
+	return avg, ok
+}
+
+// fastest returns the candidate with the lowest average. A candidate without a
+// sample yet counts as fastest, so every provider gets measured; ties go to the
+// earlier candidate. ok is false when candidates is empty.
+func (t *latencyTracker) fastest(candidates []string) (choice string, ok bool) {
+	var best time.Duration
+	for _, c := range candidates {
+		avg, _ := t.average(c)
+		if !ok || avg < best {
+			choice, best, ok = c, avg, true
+		}
+	}
+	return choice, ok
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"strconv"
+	"time"
+)
+
+// keepLease refreshes key's IN_PROGRESS lease every LeaseRefreshInterval until the
+// returned stop func is called, so a payment that outlives the lease (a slow provider,
+// retries) is never mistaken for an abandoned one and charged twice.
+func (a *Aggregator) keepLease(ctx context.Context, key cache.TxnKey, transactionID string) (stop func()) {
+	if a.LeaseRefreshInterval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.LeaseRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := a.Store.RefreshInProgress(ctx, key)
+			switch {
+			case errors.Is(err, cache.ErrNotInProgress):
+				// Expired (e.g. the store was unreachable for a whole lease) or cleared by an operator
+				slog.WarnContext(ctx, "in-progress lease lost", "transaction_id", transactionID)
+				return
+			case err != nil && ctx.Err() == nil:
+				slog.WarnContext(ctx, "failed to refresh in-progress lease", "transaction_id", transactionID, "error", err)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// leaseRetryAfter returns how many seconds a client should wait before retrying a
+// transaction whose key is IN_PROGRESS: the time left on its lease, by which point
+// the payment has either finished (and the retry gets its result) or been abandoned.
+func (a *Aggregator) leaseRetryAfter(ctx context.Context, key cache.TxnKey) int {
+	remaining, err := a.Store.LeaseRemaining(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read in-progress lease", "transaction_id", key.TransactionID, "error", err)
+	}
+	return retryAfterSeconds(remaining)
+}
+
+// isInProgress reports whether out is an inProgressOutcome. Check the code rather
+// than the status: InProgressStatus may be a 409 or 429, which mean other things too.
+func isInProgress(out paymentOutcome) bool {
+	e, ok := out.Body.(*ErrorResponse)
+	return ok && e.Code == codeTransactionInProgress
+}
+
+// inProgressOutcome is the response to a retry of a payment that is still being
+// processed, sent with InProgressStatus and a Retry-After from leaseRetryAfter.
+func (a *Aggregator) inProgressOutcome(ctx context.Context, key cache.TxnKey) paymentOutcome {
+	seconds := a.leaseRetryAfter(ctx, key)
+	body := apiError(codeTransactionInProgress, "Duplicate transaction ID detected", fmt.Sprintf(
+		"A transaction with this ID is currently being processed. Retry in %d seconds with the same TransactionID to get its result.", seconds))
+	body.RetryAfterSeconds = seconds
+	return paymentOutcome{
+		StatusCode: a.InProgressStatus,
+		Body:       body,
+		Header:     http.Header{"Retry-After": {strconv.Itoa(seconds)}},
+	}
+}
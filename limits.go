@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// AmountLimit is the per-transaction amount range a provider accepts.
+// A zero Min or Max means that side is unbounded.
+type AmountLimit struct {
+	Min float64 `json:"min,omitempty"`
+	Max float64 `json:"max,omitempty"`
+}
+
+// Allows reports whether amount falls within the limit. Boundaries are inclusive.
+func (l AmountLimit) Allows(amount float64) bool {
+	if l.Min > 0 && amount < l.Min {
+		return false
+	}
+	if l.Max > 0 && amount > l.Max {
+		return false
+	}
+	return true
+}
+
+// String describes the limit for error messages, e.g. "between 1.00 and 5000.00".
+func (l AmountLimit) String() string {
+	switch {
+	case l.Min > 0 && l.Max > 0:
+		return fmt.Sprintf("between %.2f and %.2f", l.Min, l.Max)
+	case l.Min > 0:
+		return fmt.Sprintf("at least %.2f", l.Min)
+	case l.Max > 0:
+		return fmt.Sprintf("at most %.2f", l.Max)
+	default:
+		return "any positive amount"
+	}
+}
+
+// amountLimit returns the configured limit for a provider (unbounded if none).
+func (a *Aggregator) amountLimit(providerName string) AmountLimit {
+	return a.Limits[providerName]
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"payment-gateway-aggregator/requestid"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupLogger installs a JSON slog handler as the process-wide default so every
+// log line is machine-parseable. LOG_LEVEL may be DEBUG, INFO (default), WARN or ERROR.
+func setupLogger() {
+	level := slog.LevelInfo
+	v := os.Getenv("LOG_LEVEL")
+	invalid := false
+	if v != "" {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(v))); err != nil {
+			level = slog.LevelInfo
+			invalid = true
+		}
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+
+	if invalid {
+		slog.Warn("invalid LOG_LEVEL, using INFO", "value", v)
+	}
+}
+
+// requestIDHandler adds the request_id (and, once authenticated, client_id) attributes
+// to every record logged with a request-scoped context (slog.InfoContext etc.), so all
+// lines for one payment correlate.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if client := clientIDFromContext(ctx); client != "" {
+		r.AddAttrs(slog.String("client_id", client))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// reportOutcome records the final outcome of a payment request in the metrics,
+// the structured log, and the current trace span, with the provider's breaker state at that moment.
+func (a *Aggregator) reportOutcome(ctx context.Context, transactionID, providerName, outcome string, start time.Time) {
+	recordOutcome(providerName, outcome)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("payment.provider", providerName),
+		attribute.String("payment.outcome", outcome),
+	)
+
+	attrs := []any{
+		"transaction_id", transactionID,
+		"provider", providerName,
+		"outcome", outcome,
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if breaker, ok := a.Breakers[providerName]; ok {
+		attrs = append(attrs, "breaker_state", breaker.State().String())
+	}
+
+	if outcome == outcomeSuccess || outcome == outcomeDuplicate || outcome == outcomeCancelled {
+		slog.InfoContext(ctx, "payment finished", attrs...)
+	} else {
+		slog.WarnContext(ctx, "payment finished", attrs...)
+	}
+}
+
+// reportReplay records a request answered from the idempotency store (kind is one of
+// the replay* constants) in the metrics, the structured log, and the current trace span.
+func (a *Aggregator) reportReplay(ctx context.Context, transactionID, providerName, kind string) {
+	recordReplay(providerName, kind)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("payment.replay", kind))
+	slog.InfoContext(ctx, "idempotent replay",
+		"event", "idempotent_replay",
+		"transaction_id", transactionID,
+		"provider", providerName,
+		"kind", kind,
+	)
+}
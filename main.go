@@ -5,85 +5,517 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
 	"payment-gateway-aggregator/providers"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sony/gobreaker" // NEW IMPORT
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 // Aggregator now holds references to providers, the store, and the circuit breakers
 type Aggregator struct {
-	Providers map[string]providers.PaymentProvider
-	Store     cache.IdempotencyStore
-	Breakers  map[string]*gobreaker.CircuitBreaker // NEW FIELD: Map of breakers
-}
-
-// newAggregator initializes the service with all providers, cache, and circuit breakers.
-func newAggregator() *Aggregator {
-	// 1. Initialize Redis Store - READS FROM ENVIRONMENT VARIABLE
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		// Fallback for local development/testing
-		redisAddr = "localhost:6379"
-		log.Println("WARNING: Using default Redis address: localhost:6379")
+	Providers   map[string]providers.PaymentProvider
+	Store       cache.IdempotencyStore
+	Audit       cache.AuditStore                     // Append-only record of payment attempts; nil disables auditing
+	Usage       cache.UsageStore                     // Per-provider daily totals behind DailyCaps; nil disables the caps
+	Switches    cache.ProviderSwitch                 // Operator kill-switch per provider; nil means none can be disabled
+	DeadLetters cache.DeadLetterStore                // Payments that failed after every retry and fallback; nil disables it
+	Breakers    map[string]*gobreaker.CircuitBreaker // NEW FIELD: Map of breakers
+	Timeouts    map[string]time.Duration             // Per-provider call timeout, keyed like Providers
+	Bulkheads   map[string]bulkhead                  // Per-provider cap on concurrent calls
+	Retry       RetryPolicy                          // Retries attempted before the breaker sees a failure
+	Routes      map[string][]string                  // Ordered fallback candidates, keyed by requested provider
+
+	CurrencyRoutes map[string]string      // Provider used for each currency when ProviderKey is empty
+	Currencies     map[string]bool        // Allowlist of accepted currency codes
+	MaxBodyBytes   int64                  // Request bodies larger than this are rejected with 413
+	Limits         map[string]AmountLimit // Per-provider transaction amount limits
+	Fees           map[string]FeeSchedule // Per-provider fees reported on successful payments
+	DailyCaps      map[string]DailyCap    // Per-provider ceilings on successful payments per day
+	Batch          config.BatchConfig     // Size and concurrency limits for /v1/pay/batch
+	Weights        map[string]int         // Traffic share of each provider for unpinned payments; nil disables balancing
+
+	// Latency is each provider's moving-average call duration; with AdaptiveRouting,
+	// unpinned payments go to the fastest eligible provider on their route
+	Latency         *latencyTracker
+	AdaptiveRouting bool
+
+	Webhooks           *WebhookDispatcher // Delivers completion callbacks in the background
+	DefaultCallbackURL string             // Used when a request has no CallbackURL; empty disables callbacks
+	BreakerEvents      *breakerEvents     // Every breaker transition; Subscribe to react to one
+
+	// DailyCapLocation is the timezone whose midnight resets DailyCaps
+	DailyCapLocation *time.Location
+
+	// SplitMaxLegs caps how many sub-payments a Split payment is divided into
+	SplitMaxLegs int
+
+	// TransactionIDs is the format client TransactionIDs are checked against on the
+	// way in (see transactionIDFormat)
+	TransactionIDs transactionIDFormat
+
+	// MaxIdempotencyTTL caps the replay window a client may ask for with Idempotency-TTL
+	MaxIdempotencyTTL time.Duration
+
+	// InProgressStatus is the HTTP status for a retry of a payment still being
+	// processed (425, 409 or 429; see inProgressOutcome)
+	InProgressStatus int
+
+	// CacheFailures replays definite failures to retries for a short while (see
+	// cacheFailure) instead of calling the provider again
+	CacheFailures bool
+
+	// IdempotencyFailOpen lets payments through (without duplicate protection) when the
+	// store is unreachable; by default they are rejected with 503
+	IdempotencyFailOpen bool
+
+	// LeaseRefreshInterval is how often a payment being processed refreshes its
+	// IN_PROGRESS lease (see keepLease); 0 disables refreshing
+	LeaseRefreshInterval time.Duration
+
+	// Async runs payments accepted by /v1/pay/async on a bounded worker pool
+	Async *asyncPayments
+
+	// Queue holds payments no provider could take, to be processed once one recovers
+	// (see deferPayment). Nil when queuing is disabled: those payments get a 503.
+	Queue cache.PaymentQueue
+
+	picker   *weightedPicker    // Weighted provider selection, seeded from config
+	inflight singleflight.Group // Collapses concurrent in-process requests for the same TransactionID
+}
+
+// defaultProviderTimeout is used for any provider without an entry in Aggregator.Timeouts.
+const defaultProviderTimeout = 5 * time.Second
+
+// Dependencies are the external collaborators an Aggregator is built around.
+// newAggregator wires the production ones from config; NewAggregator accepts any,
+// e.g. a MemoryStore and stub providers in a test.
+type Dependencies struct {
+	Store       cache.IdempotencyStore
+	Audit       cache.AuditStore                     // Optional; nil disables auditing
+	Usage       cache.UsageStore                     // Optional; nil disables daily caps
+	Switches    cache.ProviderSwitch                 // Optional; nil disables the provider kill-switch
+	DeadLetters cache.DeadLetterStore                // Optional; nil disables the dead-letter store
+	Queue       cache.PaymentQueue                   // Optional; nil rejects payments during a full outage
+	Providers   map[string]providers.PaymentProvider // Keyed by provider key, e.g. "MTN"
+}
+
+// newAggregator initializes the service with the configured providers and store.
+// It returns an error if the idempotency store can't be reached within the Redis connect timeout.
+func newAggregator(cfg config.Config) (*Aggregator, error) {
+	deps, err := newDependencies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewAggregator(cfg, deps)
+}
+
+// newDependencies builds the production store (Redis or in-memory, optionally
+// mirrored) and providers (the built-in simulators plus any configured instances).
+func newDependencies(cfg config.Config) (Dependencies, error) {
+	// 1. Initialize the Idempotency Store
+	// The same backend also holds the audit log.
+	var (
+		store    cache.IdempotencyStore
+		audit    cache.AuditStore
+		usage    cache.UsageStore
+		queue    cache.PaymentQueue
+		switches cache.ProviderSwitch
+		dead     cache.DeadLetterStore
+	)
+	storeOpts := cache.Options{
+		InProgressTTL:   time.Duration(cfg.Idempotency.InProgressTTL),
+		CompletedTTL:    time.Duration(cfg.Idempotency.CompletedTTL),
+		FailedTTL:       time.Duration(cfg.Idempotency.FailedTTL),
+		DeadLetterLimit: cfg.DeadLetterMaxEntries,
+	}
+	if cfg.IdempotencyStore == "memory" {
+		// Local development only: state lives in this process and is lost on restart
+		slog.Warn("using in-memory idempotency store", "idempotency_store", "memory")
+		memoryStore := cache.NewMemoryStore(storeOpts)
+		store, audit, usage, queue, switches, dead = memoryStore, memoryStore, memoryStore, memoryStore, memoryStore, memoryStore
 	} else {
-		log.Printf("Using Redis address from environment: %s", redisAddr)
-	}
-
-	// Pass the retrieved address to the NewRedisStore constructor
-	redisStore := cache.NewRedisStore(redisAddr, "", 0)
-
-	// 2. Define Circuit Breaker Settings (Using ReadyToTrip for failure rate logic)
-	settings := gobreaker.Settings{
-		Name: "MTN-Breaker",
-		// The maximum number of requests allowed in the half-open state.
-		// Setting to 1 allows one trial request after the Timeout expires.
-		MaxRequests: 1,
-		// The period of the open state (the delay before the circuit tries to close)
-		Timeout: 30 * time.Second,
-		// The rolling window size to clear counts
-		Interval: 5 * time.Second,
-
-		// THIS IS THE CORRECT FIELD: Determines when to open the circuit (Closed -> Open).
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			// Ensure we have a minimum number of requests (e.g., 3) to start calculating the ratio
-			if counts.Requests < 3 {
-				return false
-			}
+		redisStore, err := newRedisStore(cfg.Redis, storeOpts)
+		if err != nil {
+			return Dependencies{}, err
+		}
+		store, audit, usage, queue, switches, dead = redisStore, redisStore, redisStore, redisStore, redisStore, redisStore
+	}
+	if !cfg.Queue.Enabled {
+		queue = nil
+	}
+	if mirror := cfg.Idempotency.Mirror; mirror.Enabled() {
+		// Dual-write idempotency state (e.g. during a Redis migration); the audit log,
+		// usage counters, payment queue, kill-switches and dead letters stay on the primary
+		mirrorStore, err := newRedisStore(mirror, storeOpts)
+		if err != nil {
+			return Dependencies{}, fmt.Errorf("idempotency mirror: %w", err)
+		}
+		policy, err := cache.ParseReadPolicy(cfg.Idempotency.ReadPolicy)
+		if err != nil {
+			return Dependencies{}, err
+		}
+		slog.Info("dual-writing idempotency state", "read_policy", cfg.Idempotency.ReadPolicy, "quorum", cfg.Idempotency.Quorum)
+		store = cache.NewMultiStore(policy, cfg.Idempotency.Quorum, store, mirrorStore)
+	}
 
-			// Calculate the failure ratio using TotalFailures since the last clear/reset
-			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+	// Fail fast at startup rather than failing every request at runtime
+	connectTimeout := time.Duration(cfg.Redis.ConnectTimeout)
+	pingCtx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := store.Ping(pingCtx); err != nil {
+		store.Close()
+		return Dependencies{}, fmt.Errorf("idempotency store unreachable after %s: %w", connectTimeout, err)
+	}
 
-			// Return true (OPEN the circuit) if the failure ratio is 60% or higher
-			return failureRatio >= 0.6
-		},
+	// 2. Providers: one instance per config entry (the built-in "MTN" and "AIRTEL" plus
+	// e.g. regional endpoints like "MTN_ZM"), each with its own breaker below
+	registered, err := newProviders(cfg)
+	if err != nil {
+		return Dependencies{}, err
+	}
+	return Dependencies{Store: store, Audit: audit, Usage: usage, Switches: switches, DeadLetters: dead, Queue: queue, Providers: registered}, nil
+}
 
-		// This function defines what an error means. Any non-nil error from ProcessPayment is a failure.
-		IsSuccessful: func(err error) bool {
-			return err == nil
-		},
+// NewAggregator builds an Aggregator around deps: a breaker, timeout, bulkhead and
+// limits per provider, routing, retries and the background workers, all from cfg.
+func NewAggregator(cfg config.Config, deps Dependencies) (*Aggregator, error) {
+	if deps.Store == nil {
+		return nil, errors.New("an idempotency store is required")
+	}
+
+	// Copied so DRY_RUN wrapping never touches the caller's map
+	registered := make(map[string]providers.PaymentProvider, len(deps.Providers))
+	for key, p := range deps.Providers {
+		registered[key] = p
+	}
+	if cfg.DryRun {
+		// Keep routing, breakers and idempotency live but never reach a real provider
+		slog.Warn("DRY_RUN enabled: provider calls are simulated")
+		for key, p := range registered {
+			registered[key] = providers.NewDryRunProvider(p)
+		}
+	}
+
+	// 3. Circuit Breakers and call timeouts: each provider gets its own breaker (and a
+	// unique name) so one provider tripping never takes the other offline.
+	breakers := make(map[string]*gobreaker.CircuitBreaker, len(registered))
+	timeouts := make(map[string]time.Duration, len(registered))
+	limits := make(map[string]AmountLimit, len(registered))
+	fees := make(map[string]FeeSchedule, len(registered))
+	dailyCaps := make(map[string]DailyCap, len(registered))
+	bulkheads := make(map[string]bulkhead, len(registered))
+	events := &breakerEvents{}
+	var weights map[string]int
+	for key := range registered {
+		pc := cfg.Provider(key)
+		if pc.Weight > 0 {
+			if weights == nil {
+				weights = make(map[string]int, len(registered))
+			}
+			weights[key] = pc.Weight
+		}
+		breakers[key] = newBreaker(key+"-Breaker", pc.Breaker, events)
+		timeouts[key] = cfg.ProviderTimeoutFor(key)
+		limits[key] = AmountLimit{Min: pc.MinAmount, Max: pc.MaxAmount}
+		fees[key] = FeeSchedule{Flat: pc.Fee.Flat, Percent: pc.Fee.Percent}
+		dailyCaps[key] = DailyCap{MaxAmount: pc.DailyMaxAmount, MaxCount: int64(pc.DailyMaxCount)}
+		slots, err := newBulkhead(pc.MaxConcurrent)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", key, err)
+		}
+		bulkheads[key] = slots
 	}
 
-	// 3. Initialize Breaker and Aggregator
-	breakerMTN := gobreaker.NewCircuitBreaker(settings)
-	breakerAirtel := gobreaker.NewCircuitBreaker(settings)
+	// Currency coverage: used when the client doesn't pin a provider via ProviderKey
+	currencyRoutes := map[string]string{
+		"ZAR": "MTN",
+		"GHS": "MTN",
+		"UGX": "MTN",
+		"RWF": "MTN",
+		"ZMW": "AIRTEL",
+		"KES": "AIRTEL",
+		"TZS": "AIRTEL",
+		"MWK": "AIRTEL",
+	}
+	currencies, err := currencyAllowlist(cfg.Currencies, currencyRoutes)
+	if err != nil {
+		return nil, err
+	}
+	dailyCapLocation, err := time.LoadLocation(cfg.DailyCapTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("daily cap timezone: %w", err)
+	}
+	transactionIDs, err := newTransactionIDFormat(cfg.TransactionIDPattern, cfg.TransactionIDMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Idempotency.InProgressStatus {
+	case http.StatusTooEarly, http.StatusConflict, http.StatusTooManyRequests:
+	default:
+		return nil, fmt.Errorf("idempotency in-progress status %d: want 425, 409 or 429", cfg.Idempotency.InProgressStatus)
+	}
 
-	return &Aggregator{
-		Providers: map[string]providers.PaymentProvider{
-			"MTN":    providers.NewMTNProvider(),
-			"AIRTEL": providers.NewAirtelProvider(),
+	a := &Aggregator{
+		Providers: registered,
+		Store:     deps.Store,
+		Audit:     deps.Audit,
+		Usage:     deps.Usage,
+		Switches:  deps.Switches,
+		// Payments that fail after every retry and fallback, kept for reprocessing
+		DeadLetters: deps.DeadLetters,
+		Breakers:    breakers,
+		Timeouts:    timeouts,
+		Bulkheads:   bulkheads,
+		// 4. Fallback routing: if the requested provider's circuit is open, try the next one
+		Routes: map[string][]string{
+			"MTN":    {"MTN", "AIRTEL"},
+			"AIRTEL": {"AIRTEL", "MTN"},
 		},
-		Store: redisStore,
-		Breakers: map[string]*gobreaker.CircuitBreaker{ // ASSIGN BREAKER
-			"MTN":    breakerMTN,
-			"AIRTEL": breakerAirtel,
+		// 5. Currency coverage and the allowlist of accepted currencies
+		CurrencyRoutes: currencyRoutes,
+		Currencies:     currencies,
+		// 6. Retry policy
+		Retry: RetryPolicy{
+			MaxRetries: cfg.Retry.MaxRetries,
+			BaseDelay:  time.Duration(cfg.Retry.BaseDelay),
+			MaxDelay:   time.Duration(cfg.Retry.MaxDelay),
 		},
+		MaxBodyBytes: cfg.Server.MaxBodyBytes,
+		Limits:       limits,
+		Fees:         fees,
+		Batch:        cfg.Batch,
+		// Daily caps, reset at midnight in the configured timezone
+		DailyCaps:        dailyCaps,
+		DailyCapLocation: dailyCapLocation,
+		SplitMaxLegs:     cfg.SplitMaxLegs,
+		TransactionIDs:   transactionIDs,
+		// 7. Weighted load balancing across healthy providers (only when weights are configured)
+		Weights: weights,
+		picker:  newWeightedPicker(cfg.LoadBalancerSeed),
+		// Latency-based routing (opt-in); averages are kept either way for /v1/providers
+		Latency:         newLatencyTracker(cfg.LatencyAlpha),
+		AdaptiveRouting: cfg.AdaptiveRouting,
+		// 8. Completion callbacks
+		Webhooks:           NewWebhookDispatcher(cfg.Webhook),
+		DefaultCallbackURL: cfg.Webhook.URL,
+		// 9. Breaker transition listeners
+		BreakerEvents: events,
+		// 10. Store outage policy
+		IdempotencyFailOpen: cfg.Idempotency.FailOpen,
+		// Short-lived replay of definite failures (opt-in)
+		CacheFailures:    cfg.Idempotency.CacheFailures,
+		InProgressStatus: cfg.Idempotency.InProgressStatus,
+		// Client-chosen replay windows (Idempotency-TTL) are capped here
+		MaxIdempotencyTTL: time.Duration(cfg.Idempotency.MaxClientTTL),
+		// Three refreshes per lease, so one failed refresh never lets it lapse
+		LeaseRefreshInterval: time.Duration(cfg.Idempotency.InProgressTTL) / 3,
+		// 11. Deferred payments during a full provider outage (opt-in)
+		Queue: deps.Queue,
+	}
+	// 12. Background workers for /v1/pay/async
+	a.Async = newAsyncPayments(a, cfg.Async, time.Duration(cfg.Server.RequestTimeout))
+	return a, nil
+}
+
+// regional returns the instance of providerName serving country (e.g. "MTN" + "ZM"
+// -> "MTN_ZM") when one is registered, or providerName itself otherwise.
+func (a *Aggregator) regional(providerName, country string) string {
+	if country == "" {
+		return providerName
+	}
+	if key := providerName + "_" + country; a.Providers[key] != nil {
+		return key
+	}
+	return providerName
+}
+
+// newRedisStore builds the Redis-backed store for the configured deployment mode.
+func newRedisStore(cfg config.RedisConfig, opts cache.Options) (*cache.RedisStore, error) {
+	opts.MaxRetries = cfg.MaxRetries
+	opts.MinRetryBackoff = time.Duration(cfg.MinRetryBackoff)
+	opts.MaxRetryBackoff = time.Duration(cfg.MaxRetryBackoff)
+
+	switch cfg.Mode {
+	case "single":
+		slog.Info("using Redis idempotency store", "redis_mode", cfg.Mode, "redis_addr", cfg.Addr)
+		return cache.NewRedisStore(cfg.Addr, cfg.Password, cfg.DB, opts), nil
+	case "sentinel":
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis sentinel mode needs a master name and at least one sentinel address")
+		}
+		slog.Info("using Redis idempotency store", "redis_mode", cfg.Mode, "redis_master", cfg.MasterName, "redis_sentinels", cfg.Addrs)
+		return cache.NewRedisSentinelStore(cfg.MasterName, cfg.Addrs, cfg.Password, cfg.DB, opts), nil
+	case "cluster":
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("redis cluster mode needs at least one node address")
+		}
+		// Cluster mode has no numbered databases, so cfg.DB is ignored
+		slog.Info("using Redis idempotency store", "redis_mode", cfg.Mode, "redis_nodes", cfg.Addrs)
+		return cache.NewRedisClusterStore(cfg.Addrs, cfg.Password, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q (want single, sentinel or cluster)", cfg.Mode)
+	}
+}
+
+// supportedCurrencies lists every allowed currency, sorted for stable output.
+func (a *Aggregator) supportedCurrencies() []string {
+	currencies := make([]string, 0, len(a.Currencies))
+	for currency := range a.Currencies {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// currencyAllowlist builds the accepted currency set from the configured codes,
+// falling back to every currency in routes. Configured codes are normalized and
+// must be well-formed ISO 4217 codes.
+func currencyAllowlist(configured []string, routes map[string]string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	if len(configured) == 0 {
+		for currency := range routes {
+			allowed[currency] = true
+		}
+		return allowed, nil
+	}
+	for _, c := range configured {
+		code := providers.NormalizeCurrency(c)
+		if !providers.IsCurrencyCode(code) {
+			return nil, fmt.Errorf("invalid currency %q in allowlist: want a 3-letter ISO 4217 code", c)
+		}
+		allowed[code] = true
+	}
+	return allowed, nil
+}
+
+// routeFor returns the ordered list of providers to try for a request addressed to
+// providerName. Providers without a configured route are tried on their own.
+func (a *Aggregator) routeFor(providerName string) []string {
+	if route, ok := a.Routes[providerName]; ok && len(route) > 0 {
+		return route
+	}
+	return []string{providerName}
+}
+
+// preferredRoute turns a request's ProviderPreference into the route to walk: each
+// name narrowed to its regional instance for country, repeats dropped. Every name
+// must be a registered provider.
+func (a *Aggregator) preferredRoute(preference []string, country string) ([]string, error) {
+	route := make([]string, 0, len(preference))
+	for _, name := range preference {
+		if _, ok := a.Providers[name]; !ok {
+			known := slices.Sorted(maps.Keys(a.Providers))
+			return nil, fmt.Errorf("ProviderPreference names unknown provider %q (want one of %s)", name, strings.Join(known, ", "))
+		}
+		if name = a.regional(name, country); !slices.Contains(route, name) {
+			route = append(route, name)
+		}
+	}
+	return route, nil
+}
+
+// callProvider runs a single payment through the named provider's circuit breaker,
+// bounded by that provider's timeout, and reports how many attempts (retries
+// included) reached the provider. It returns gobreaker.ErrOpenState without
+// calling the provider when the circuit is open.
+func (a *Aggregator) callProvider(parent context.Context, providerName string, req providers.PaymentRequest) (result interface{}, attempts int, err error) {
+	provider := a.Providers[providerName]
+
+	// Turn the call away immediately rather than queue behind a saturated provider.
+	// This happens outside the breaker so it never counts as a provider failure.
+	slots := a.Bulkheads[providerName]
+	if !slots.tryAcquire() {
+		return nil, 0, errBulkheadFull
+	}
+	defer slots.release()
+
+	// Bound the external provider call by its configured timeout
+	ctx, cancel := context.WithTimeout(parent, a.providerTimeout(providerName))
+	defer cancel()
+
+	// One span for the breaker decision, with a child for the provider call it lets through
+	ctx, span := tracer.Start(ctx, "breaker.execute", trace.WithAttributes(attribute.String("payment.provider", providerName)))
+	defer func() { endSpan(span, err) }()
+
+	call := func() (interface{}, error) {
+		// Retries happen in here too, so only the final outcome counts towards tripping.
+		callCtx, callSpan := tracer.Start(ctx, "provider.call", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(append(paymentAttributes(req.TransactionID, req.Amount, req.Currency), attribute.String("payment.provider", providerName))...))
+		start := time.Now()
+		defer func() {
+			providerCallDuration.WithLabelValues(providerName).Observe(time.Since(start).Seconds())
+		}()
+		res, n, callErr := processWithRetry(callCtx, provider, req, a.Retry)
+		a.Latency.observe(providerName, time.Since(start), callErr)
+		attempts = n
+		if res != nil {
+			callSpan.SetAttributes(attribute.String("payment.status", res.Status))
+		}
+		endSpan(callSpan, callErr)
+		return res, callErr
+	}
+
+	breaker, ok := a.Breakers[providerName]
+	if !ok {
+		// Fallback for providers without a defined breaker (shouldn't happen here)
+		slog.WarnContext(parent, "no circuit breaker found for provider", "provider", providerName)
+		result, err = call()
+		return result, attempts, err
+	}
+	span.SetAttributes(attribute.String("breaker.state", breaker.State().String()))
+
+	// The Execute function handles the core CB logic:
+	// 1. Checks if the circuit is Open (fails immediately with gobreaker.ErrOpenState).
+	// 2. If Closed, runs the request function.
+	// 3. If Half-Open, permits a trial request.
+	result, err = breaker.Execute(call)
+	return result, attempts, err
+}
+
+// isBreakerRejection reports whether err means the breaker refused to run the call:
+// either the circuit is Open, or it is Half-Open and already has a trial in flight.
+func isBreakerRejection(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+// providerTimeout returns the call timeout configured for the named provider.
+func (a *Aggregator) providerTimeout(providerName string) time.Duration {
+	if d, ok := a.Timeouts[providerName]; ok && d > 0 {
+		return d
+	}
+	return defaultProviderTimeout
+}
+
+// callBudget returns how long a call to providerName made now may run: its timeout,
+// or less when the request's own deadline (see withRequestTimeout) comes sooner.
+func (a *Aggregator) callBudget(ctx context.Context, providerName string) time.Duration {
+	budget := a.providerTimeout(providerName)
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = min(budget, time.Until(deadline))
 	}
+	return max(budget, 0)
 }
 
 // PayHandler processes the API request, now with Idempotency and Circuit Breaker logic.
@@ -92,135 +524,750 @@ func (a *Aggregator) PayHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != "POST" { // (Keep this)
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method Not Allowed"})
+		methodNotAllowed(w, "POST")
 		return
 	}
-
-	var req providers.PaymentRequest                             // (Keep this)
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { // (Keep this)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid Request Body"})
+	if !requireJSON(w, r) {
 		return
 	}
 
-	// --- IDEMPOTENCY CHECK --- (Keep this section)
-	isDuplicate, err := a.Store.CheckOrSetInProgress(r.Context(), req.TransactionID)
-	if err != nil && err.Error() == "transaction already in progress" {
-		w.WriteHeader(http.StatusTooEarly)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "Duplicate transaction ID detected",
-			"message": "A transaction with this ID is currently being processed. Please wait.",
-		})
+	req, ok := a.decodePayment(w, r)
+	if !ok {
 		return
 	}
-	if isDuplicate {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "Duplicate transaction ID detected",
-			"message": "This transaction ID has already been successfully completed.",
-		})
-		return
+
+	// Continue the caller's trace if they sent a traceparent header
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "POST /v1/pay", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(paymentAttributes(req.TransactionID, req.Amount, req.Currency)...))
+	defer span.End()
+
+	out := a.pay(ctx, req)
+	out = a.deferPayment(ctx, req, out)
+	span.SetAttributes(attribute.Int("http.response.status_code", out.StatusCode))
+	if out.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(out.StatusCode))
 	}
-	// --- IDEMPOTENCY CHECK END ---
 
-	// --- Input Validation and Routing ---
-	// Use the ProviderKey from the request for routing. Default to MTN if invalid.
-	providerName := req.ProviderKey
-	if _, ok := a.Providers[providerName]; !ok {
-		providerName = "MTN"
-		log.Printf("Provider key '%s' not found. Defaulting to MTN.", req.ProviderKey)
+	// Send the response back to the client
+	writeOutcome(w, out)
+}
+
+// decodePayment reads a PaymentRequest from the body of a payment submission. On
+// failure it has already written the error response and returns false.
+func (a *Aggregator) decodePayment(w http.ResponseWriter, r *http.Request) (providers.PaymentRequest, bool) {
+	// Cap the body size so a client can't stream an arbitrarily large payload into memory,
+	// and reject unknown fields so typo'd keys (e.g. "ammount") fail loudly.
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req providers.PaymentRequest
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, apiError(codeBodyTooLarge,
+				"Request Body Too Large", fmt.Sprintf("Request body must not exceed %d bytes.", tooLarge.Limit)))
+			return req, false
+		}
+
+		writeError(w, http.StatusBadRequest, apiError(codeInvalidBody, "Invalid Request Body", err.Error()))
+		return req, false
 	}
 
-	provider, ok := a.Providers[providerName]
-	if !ok {
-		// This should not happen if default is set, but kept for safety
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Provider %s not found", providerName)})
-		return
+	// Prefer the Idempotency-Key header (Stripe-style) and fall back to the body's
+	// TransactionID for older clients. Whichever is used becomes the Redis key.
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if req.TransactionID != "" && req.TransactionID != key {
+			writeError(w, http.StatusBadRequest, apiError(codeIdempotencyKeyConflict,
+				"Conflicting Idempotency Key", "The Idempotency-Key header and TransactionID must match when both are sent."))
+			return req, false
+		}
+		req.TransactionID = key
+	}
+	if err := a.TransactionIDs.check(req.TransactionID); err != nil {
+		writeError(w, http.StatusBadRequest, apiError(codeValidationFailed, "Validation Failed", err.Error()))
+		return req, false
 	}
 
-	breaker, ok := a.Breakers[providerName]
-	if !ok {
-		// Fallback for providers without a defined breaker (shouldn't happen here)
-		log.Printf("Warning: No circuit breaker found for %s", providerName)
+	// Idempotency-TTL (whole seconds) picks how long this payment is replayed, capped
+	// at MaxIdempotencyTTL; without it the configured completed TTL applies
+	if v := r.Header.Get(idempotencyTTLHeader); v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seconds <= 0 {
+			writeError(w, http.StatusBadRequest, apiError(codeValidationFailed,
+				"Validation Failed", "Idempotency-TTL must be a positive whole number of seconds."))
+			return req, false
+		}
+		req.IdempotencyTTL = time.Duration(min(seconds, int64(a.MaxIdempotencyTTL/time.Second))) * time.Second
 	}
+	return req, true
+}
 
-	// Set a 5-second timeout for the external provider call (WAS 1 second)
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
+// paymentOutcome is the HTTP status and JSON body produced for a single payment.
+type paymentOutcome struct {
+	StatusCode int
+	Body       interface{}
+	Header     http.Header // Extra response headers (e.g. Retry-After); may be nil
 
-	log.Printf("Starting transaction %s via %s", req.TransactionID, provider.Name())
+	// Deferrable marks a 503 where every provider on the route turned the payment away
+	// without trying it, so it can be queued instead (see deferPayment)
+	Deferrable bool
+}
 
-	// --- CIRCUIT BREAKER EXECUTION ---
-	// The Execute function handles the core CB logic:
-	// 1. Checks if the circuit is Open (fails immediately with gobreaker.ErrOpenState).
-	// 2. If Closed, runs the request function.
-	// 3. If Half-Open, permits a trial request.
-	result, errCB := breaker.Execute(func() (interface{}, error) {
-		// The actual provider call happens inside the circuit breaker wrapper
-		return provider.ProcessPayment(ctx, req)
+// idempotencyTTLHeader lets a client choose how long a payment is replayed (see decodePayment).
+const idempotencyTTLHeader = "Idempotency-TTL"
+
+// Idempotency-Status tells clients whether a response was replayed from the
+// idempotency store or produced by a live provider call. It always agrees with
+// the body's IsIdempotent field.
+const (
+	idempotencyStatusHeader   = "Idempotency-Status"
+	idempotencyStatusReplayed = "replayed"
+	idempotencyStatusNew      = "new"
+)
+
+// Headers describing the provider call behind a response. They are only set when a
+// provider actually ran, never on validation, idempotency or rejection responses.
+const (
+	providerHeader        = "X-Provider"
+	providerLatencyHeader = "X-Provider-Latency-Ms" // Time spent in the breaker-wrapped call, retries included
+	providerTimeoutHeader = "X-Provider-Timeout-Ms" // Deadline that call ran under (see callBudget)
+	attemptsHeader        = "X-Attempts"            // Provider calls made, retries and fallbacks included
+)
+
+// idempotencyStatus returns response headers carrying the given Idempotency-Status.
+func idempotencyStatus(status string) http.Header {
+	return http.Header{idempotencyStatusHeader: {status}}
+}
+
+// pay processes a payment, sharing a single execution between concurrent requests
+// for the same TransactionID on this instance. Redis still provides the
+// cross-instance dedup; this just spares the losers a confusing 425 and an extra
+// round trip while the winner is in flight.
+func (a *Aggregator) pay(ctx context.Context, req providers.PaymentRequest) paymentOutcome {
+	if req.TransactionID == "" {
+		return a.processPayment(ctx, req)
+	}
+
+	v, _, shared := a.inflight.Do(txnKey(ctx, req.TransactionID).String(), func() (interface{}, error) {
+		// Detach cancellation so one caller disconnecting doesn't fail the others,
+		// but keep the request deadline; the provider timeout still bounds the call.
+		detached := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			detached, cancel = context.WithDeadline(detached, deadline)
+			defer cancel()
+		}
+		return a.processPayment(detached, req), nil
 	})
+	if shared {
+		slog.InfoContext(ctx, "shared in-flight result for duplicate request", "transaction_id", req.TransactionID)
+	}
+	return v.(paymentOutcome)
+}
+
+// processPayment runs one decoded payment request through validation, routing,
+// idempotency, and the circuit breaker path. It is shared by the single and batch endpoints.
+func (a *Aggregator) processPayment(ctx context.Context, req providers.PaymentRequest) paymentOutcome {
+	if req.IdempotencyTTL > 0 {
+		ctx = cache.WithCompletedTTL(ctx, req.IdempotencyTTL)
+	}
+	if req.Split {
+		return a.processSplitPayment(ctx, req)
+	}
+	p, out := a.admitPayment(ctx, req, "")
+	if p == nil {
+		return out
+	}
+	return a.executePayment(ctx, p)
+}
+
+// checkPayment normalizes req and rejects it if it is malformed or in a currency
+// that isn't accepted. It returns false and the response to send in that case.
+func (a *Aggregator) checkPayment(req *providers.PaymentRequest) (paymentOutcome, bool) {
+	req.Currency = providers.NormalizeCurrency(req.Currency)
+	req.Country = providers.NormalizeCountry(req.Country)
+	req.ProviderPreference = providers.NormalizeProviderPreference(req.ProviderPreference)
+	req.NormalizeAmount()
+	if err := req.Validate(); err != nil {
+		return paymentOutcome{StatusCode: http.StatusBadRequest, Body: apiError(codeValidationFailed, "Validation Failed", err.Error())}, false
+	}
+	if !a.Currencies[req.Currency] {
+		body := apiError(codeUnsupportedCurrency, "Unsupported Currency", fmt.Sprintf("Currency %s is not accepted.", req.Currency))
+		body.SupportedCurrencies = a.supportedCurrencies()
+		return paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: body}, false
+	}
+	return paymentOutcome{}, true
+}
+
+// admittedPayment is a payment that passed validation and routing and holds its
+// idempotency claim, ready for the provider call.
+type admittedPayment struct {
+	req          providers.PaymentRequest
+	key          cache.TxnKey
+	providerName string   // The routed provider; fallback may still serve it elsewhere
+	route        []string // Candidates to try in order, providerName first
+	start        time.Time
+}
+
+// admitPayment validates and routes req and claims its idempotency key in the given
+// stage (cache.StagePending for a payment that waits before it is processed, else
+// ""). It returns nil and the response to send when the payment goes no further
+// (invalid, unroutable, duplicate, or the store is down).
+func (a *Aggregator) admitPayment(ctx context.Context, req providers.PaymentRequest, stage string) (*admittedPayment, paymentOutcome) {
+	start := time.Now()
+
+	// Reject malformed requests before they touch Redis or a provider
+	if out, ok := a.checkPayment(&req); !ok {
+		return nil, out
+	}
+	if req.Split {
+		// Only processPayment knows how to run the legs
+		return nil, paymentOutcome{StatusCode: http.StatusBadRequest, Body: apiError(codeValidationFailed,
+			"Validation Failed", "Split payments are not supported on this endpoint")}
+	}
+
+	// --- Input Validation and Routing ---
+	// A ProviderPreference replaces the route for this request only. Otherwise a
+	// ProviderKey pins the provider (e.g. "MTN-12345" -> "MTN"); without one, the
+	// provider is chosen by which one covers the requested currency, then
+	// optionally rebalanced across its route by latency or weight.
+	// Routing happens before the idempotency check so rejected requests never hold a key.
+	var (
+		providerName string
+		route        []string
+	)
+	switch {
+	case len(req.ProviderPreference) > 0:
+		var err error
+		if route, err = a.preferredRoute(req.ProviderPreference, req.Country); err != nil {
+			return nil, paymentOutcome{StatusCode: http.StatusBadRequest, Body: apiError(codeValidationFailed, "Validation Failed", err.Error())}
+		}
+		providerName = route[0]
+	case req.ProviderKey != "":
+		providerName = providerNameFromKey(req.ProviderKey)
+	default:
+		name, ok := a.CurrencyRoutes[req.Currency]
+		if !ok {
+			body := apiError(codeUnsupportedCurrency, "Unsupported Currency", fmt.Sprintf("No provider supports currency %s.", req.Currency))
+			body.SupportedCurrencies = a.supportedCurrencies()
+			return nil, paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: body}
+		}
+		providerName = a.balance(ctx, req, name)
+	}
+	if route == nil {
+		// A Country narrows the provider to its regional instance, if it has one
+		providerName = a.regional(providerName, req.Country)
+		route = a.routeFor(providerName)
+	}
+
+	if _, ok := a.Providers[providerName]; !ok {
+		return nil, paymentOutcome{StatusCode: http.StatusNotFound, Body: apiError(codeProviderNotFound, fmt.Sprintf("Provider %s not found", providerName), "")}
+	}
+
+	// Enforce the provider's per-transaction amount limits
+	if limit := a.amountLimit(providerName); !limit.Allows(req.Amount) {
+		body := apiError(codeAmountOutOfRange, "Amount Out Of Range", fmt.Sprintf("Provider %s accepts amounts %s.", providerName, limit))
+		body.Limits = &limit
+		return nil, paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: body}
+	}
 
-	// Check if the error came from the Circuit Breaker itself (circuit is OPEN)
-	if errCB == gobreaker.ErrOpenState {
-		w.WriteHeader(http.StatusServiceUnavailable) // 503 is standard for CB open
-		log.Printf("Circuit Breaker OPEN for %s. Bypassing request.", provider.Name())
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "Service Unavailable",
-			"message": fmt.Sprintf("Provider %s is currently experiencing high failure rates and has been temporarily taken offline.", provider.Name()),
+	// Enforce daily caps; a fallback provider with room left can still take the payment
+	if a.routeAtDailyCap(ctx, route, req.Amount) {
+		_, dayEnd := a.usageDay(time.Now())
+		seconds := retryAfterSeconds(time.Until(dayEnd))
+		body := apiError(codeDailyLimitReached, "Daily Limit Reached",
+			fmt.Sprintf("Provider %s accepts at most %s.", providerName, a.DailyCaps[providerName]))
+		body.RetryAfterSeconds = seconds
+		return nil, paymentOutcome{
+			StatusCode: http.StatusTooManyRequests,
+			Body:       body,
+			Header:     http.Header{"Retry-After": {strconv.Itoa(seconds)}},
+		}
+	}
+
+	// --- IDEMPOTENCY CHECK --- (Keep this section)
+	key := txnKey(ctx, req.TransactionID)
+	// Providers de-duplicate on the same (tenant-scoped) key, so a retry that lands
+	// after our IN_PROGRESS lease expired still can't charge twice
+	req.IdempotencyKey = key.String()
+	idemCtx, idemSpan := tracer.Start(ctx, "idempotency.check")
+
+	// A TransactionID reused with different parameters is a client bug; replaying the
+	// earlier result would hide it
+	matched, err := a.Store.MatchFingerprint(idemCtx, key, req.Fingerprint())
+	if err == nil && !matched {
+		idemSpan.SetAttributes(attribute.String("idempotency.state", "MISMATCH"))
+		idemSpan.End()
+		slog.WarnContext(ctx, "transaction rejected: parameters differ from the original request", "transaction_id", req.TransactionID)
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcomeKeyReused, start)
+		return nil, paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: apiError(codeIdempotencyKeyReused,
+			"Idempotency Key Reused", "idempotency key reused with different parameters")}
+	}
+
+	var state cache.TxnState
+	if err == nil {
+		state, err = a.Store.CheckOrSetInProgressWithInfo(idemCtx, key, cache.InProgressInfo{
+			Stage:    stage,
+			Provider: providerName,
+			Amount:   req.Amount,
+			Currency: req.Currency,
 		})
-		return
 	}
+	idemSpan.SetAttributes(attribute.String("idempotency.state", state.String()))
+	endSpan(idemSpan, err)
+	if err != nil {
+		// The store is unreachable, so a duplicate can't be ruled out
+		if !a.IdempotencyFailOpen {
+			slog.ErrorContext(ctx, "idempotency store unavailable, rejecting payment", "transaction_id", req.TransactionID, "error", err)
+			a.reportOutcome(ctx, req.TransactionID, providerName, outcomeStoreUnavailable, start)
+			return nil, paymentOutcome{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       apiError(codeStoreUnavailable, "Service Unavailable", "Payments are temporarily unavailable. Please retry."),
+				Header:     http.Header{"Retry-After": {"1"}},
+			}
+		}
+		// Fail open (opt-in): the payment proceeds without cross-request dedup
+		slog.ErrorContext(ctx, "idempotency store unavailable, processing WITHOUT duplicate protection", "transaction_id", req.TransactionID, "error", err)
+	}
+
+	switch state {
+	case cache.StateInProgress:
+		a.reportReplay(ctx, req.TransactionID, providerName, replayInProgress)
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcomeDuplicate, start)
+		return nil, a.inProgressOutcome(ctx, key)
+
+	case cache.StateCompleted:
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcomeDuplicate, start)
+
+		// Replay the original successful response so retries see exactly what the first call saw
+		stored, err := a.Store.GetResult(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored result", "transaction_id", req.TransactionID, "error", err)
+		}
+		if stored != nil {
+			a.reportReplay(ctx, req.TransactionID, providerName, replayServed)
+			stored.IsIdempotent = true
+			return nil, paymentOutcome{StatusCode: http.StatusOK, Body: stored, Header: idempotencyStatus(idempotencyStatusReplayed)}
+		}
+
+		// No stored result (e.g. completed before results were cached): fall back to a plain conflict
+		a.reportReplay(ctx, req.TransactionID, providerName, replayConflict)
+		return nil, paymentOutcome{StatusCode: http.StatusConflict, Body: apiError(codeDuplicateTransaction,
+			"Duplicate transaction ID detected", "This transaction ID has already been successfully completed.")}
+
+	case cache.StateFailed:
+		// A recent definite failure (see cacheFailure): replay it rather than ask the provider again
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcomeDuplicate, start)
+		stored, err := a.Store.GetResult(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored result", "transaction_id", req.TransactionID, "error", err)
+		}
+		if stored != nil {
+			a.reportReplay(ctx, req.TransactionID, providerName, replayFailure)
+			stored.IsIdempotent = true
+			return nil, paymentOutcome{StatusCode: failureStatusCode(stored), Body: stored, Header: idempotencyStatus(idempotencyStatusReplayed)}
+		}
+		a.reportReplay(ctx, req.TransactionID, providerName, replayConflict)
+		return nil, paymentOutcome{StatusCode: http.StatusConflict, Body: apiError(codeDuplicateTransaction,
+			"Duplicate transaction ID detected", "This transaction ID failed moments ago. Please wait before retrying.")}
+
+	case cache.StateCancelled:
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcomeDuplicate, start)
+		return nil, cancelledOutcome()
+	}
+	// --- IDEMPOTENCY CHECK END ---
+
+	return &admittedPayment{req: req, key: key, providerName: providerName, route: route, start: start}, paymentOutcome{}
+}
+
+// executePayment calls the provider for an admitted payment, with breaker fallback,
+// and records the result against its idempotency key.
+func (a *Aggregator) executePayment(ctx context.Context, p *admittedPayment) paymentOutcome {
+	req, key, providerName, route, start := p.req, p.key, p.providerName, p.route, p.start
+	provider := a.Providers[providerName]
+	var ok bool
+
+	// --- CIRCUIT BREAKER EXECUTION WITH FALLBACK ---
+	// Walk the route (or the request's own preference) in order. A candidate whose circuit
+	// is Open is skipped; the first one that actually runs decides the outcome.
+	requested := provider
+	servedBy := providerName
+	var (
+		result     interface{}
+		errCB      error
+		attempted  bool
+		retryAfter time.Duration // Shortest wait until a skipped breaker allows a trial request
+		sawOpen    bool
+		sawFull    bool
+
+		callLatency time.Duration // Time spent in the call that decided the outcome
+		callBudget  time.Duration // Deadline that call ran under
+		attempts    int           // Provider calls made across the route, retries included
+	)
+	// Hold the lease for as long as providers are being called, retries included
+	stopLease := a.keepLease(ctx, key, req.TransactionID)
+	for _, candidate := range route {
+		provider, ok = a.Providers[candidate]
+		if !ok {
+			slog.WarnContext(ctx, "route references unknown provider", "route", providerName, "provider", candidate)
+			continue
+		}
+		if a.providerDisabled(ctx, candidate) {
+			slog.InfoContext(ctx, "skipping provider, disabled by operator", "transaction_id", req.TransactionID, "provider", candidate)
+			continue
+		}
+		if !a.amountLimit(candidate).Allows(req.Amount) {
+			slog.InfoContext(ctx, "skipping provider, amount out of range", "transaction_id", req.TransactionID, "provider", candidate)
+			continue
+		}
+		if !a.withinDailyCap(ctx, candidate, req.Amount) {
+			slog.InfoContext(ctx, "skipping provider, daily cap reached", "transaction_id", req.TransactionID, "provider", candidate)
+			continue
+		}
+
+		slog.InfoContext(ctx, "starting transaction", "transaction_id", req.TransactionID, "provider", candidate)
+		servedBy = candidate
+		attempted = true
+		callBudget = a.callBudget(ctx, candidate)
+		callStart := time.Now()
+		var n int
+		result, n, errCB = a.callProvider(ctx, candidate, req)
+		attempts += n
+		callLatency = time.Since(callStart)
+		if !isRejection(errCB) {
+			break
+		}
+		if errors.Is(errCB, errBulkheadFull) {
+			slog.WarnContext(ctx, "provider at concurrency limit, trying next provider", "transaction_id", req.TransactionID, "provider", candidate)
+			sawFull = true
+			continue
+		}
+		slog.WarnContext(ctx, "circuit breaker open, trying next provider", "transaction_id", req.TransactionID, "provider", candidate)
 
-	// Check for other errors (timeout or provider internal error)
+		if breaker, ok := a.Breakers[candidate]; ok {
+			if wait := openings.retryAfter(breaker.Name()); !sawOpen || wait < retryAfter {
+				retryAfter = wait
+			}
+			sawOpen = true
+		}
+	}
+	stopLease()
+
+	// No candidate on the route could take this payment at all
+	if !attempted {
+		slog.WarnContext(ctx, "no eligible provider on route", "transaction_id", req.TransactionID, "route", providerName)
+		return paymentOutcome{StatusCode: http.StatusServiceUnavailable, Body: apiError(codeProviderUnavailable,
+			"Service Unavailable", fmt.Sprintf("No provider is available to process this payment via %s.", providerName))}
+	}
+
+	// Every candidate's circuit is OPEN or its bulkhead is full
+	if isRejection(errCB) {
+		outcome, code := outcomeBreakerOpen, codeProviderUnavailable
+		message := fmt.Sprintf("Provider %s is currently experiencing high failure rates and has been temporarily taken offline.", requested.Name())
+		if sawFull && !sawOpen {
+			outcome, code = outcomeBulkheadFull, codeProviderAtCapacity
+			message = fmt.Sprintf("Provider %s is at capacity. Please retry shortly.", requested.Name())
+		}
+		a.reportOutcome(ctx, req.TransactionID, providerName, outcome, start)
+		a.recordAudit(ctx, req, providerName, outcome, nil)
+		// 503 is standard for CB open; Retry-After tells clients when a trial request may be allowed
+		seconds := retryAfterSeconds(retryAfter)
+		body := apiError(code, "Service Unavailable", message)
+		body.RetryAfterSeconds = seconds
+		return paymentOutcome{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       body,
+			Header:     http.Header{"Retry-After": {strconv.Itoa(seconds)}},
+			Deferrable: true,
+		}
+	}
+
+	if provider != requested {
+		slog.InfoContext(ctx, "served by fallback provider", "transaction_id", req.TransactionID, "provider", servedBy, "requested", providerName)
+	}
+
+	// From here on a provider was actually called; say which one and how long it took
+	live := idempotencyStatus(idempotencyStatusNew)
+	live.Set(providerHeader, servedBy)
+	live.Set(providerLatencyHeader, strconv.FormatInt(callLatency.Milliseconds(), 10))
+	live.Set(providerTimeoutHeader, strconv.FormatInt(callBudget.Milliseconds(), 10))
+	live.Set(attemptsHeader, strconv.Itoa(attempts))
+
+	// Check for other errors: a timeout (504) tells the client the outcome is unknown and
+	// worth retrying with the same TransactionID; a decline (402) or provider error (502)
+	// is a definite failure.
 	if errCB != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Provider/CB Error: %v", errCB)
+		slog.ErrorContext(ctx, "provider call failed", "transaction_id", req.TransactionID, "provider", servedBy, "error", errCB)
+
+		if errors.Is(errCB, context.DeadlineExceeded) || errors.Is(errCB, context.Canceled) {
+			a.reportOutcome(ctx, req.TransactionID, servedBy, outcomeTimeout, start)
+			message := fmt.Sprintf("Provider %s did not respond within %s.", servedBy, a.providerTimeout(servedBy))
+			switch {
+			case errors.Is(errCB, providers.ErrNoTimeLeft):
+				message = fmt.Sprintf("Provider %s was not called: too little time left before the deadline.", servedBy)
+			case ctx.Err() != nil:
+				// The request's own deadline ran out first (see withRequestTimeout)
+				message = fmt.Sprintf("Provider %s did not respond before the request deadline.", servedBy)
+			}
+			res := &providers.PaymentResponse{
+				Status:       "TIMEOUT",
+				ReferenceID:  "N/A",
+				ProviderName: provider.Name(),
+				Message:      message,
+			}
+			a.recordAudit(ctx, req, servedBy, outcomeTimeout, res)
+			a.deadLetter(ctx, req, servedBy, http.StatusGatewayTimeout, errCB)
+			// No callback: the provider may yet complete the payment, so it hasn't finished
+			return paymentOutcome{StatusCode: http.StatusGatewayTimeout, Body: res, Header: live}
+		}
+
+		// A decline is a definite answer from a healthy provider (402), not a gateway failure
+		if providers.IsBusinessError(errCB) {
+			a.reportOutcome(ctx, req.TransactionID, servedBy, outcomeDeclined, start)
+			res, _ := result.(*providers.PaymentResponse)
+			a.recordAudit(ctx, req, servedBy, outcomeDeclined, res)
+			if res != nil {
+				a.cacheFailure(ctx, key, req, res)
+				a.notifyCompletion(ctx, req, res)
+				return paymentOutcome{StatusCode: http.StatusPaymentRequired, Body: res, Header: live}
+			}
+			return paymentOutcome{
+				StatusCode: http.StatusPaymentRequired,
+				Body:       apiError(codePaymentDeclined, "Payment Declined", errCB.Error()),
+				Header:     live,
+			}
+		}
+
+		a.reportOutcome(ctx, req.TransactionID, servedBy, outcomeFailed, start)
 
 		// Try to cast the result, which might contain the FAILED status details
-		res, ok := result.(*providers.PaymentResponse)
-		if ok && res.Status == "FAILED" {
+		res, _ := result.(*providers.PaymentResponse)
+		a.recordAudit(ctx, req, servedBy, outcomeFailed, res)
+		a.deadLetter(ctx, req, servedBy, http.StatusBadGateway, errCB)
+		if res != nil && res.Status == "FAILED" {
 			// If the provider returned a structured FAILED response (even with an error), send it back
-			w.WriteHeader(http.StatusOK) // Use 200 OK because the failure is known and contained
-			json.NewEncoder(w).Encode(res)
-			return
+			a.cacheFailure(ctx, key, req, res)
+			a.notifyCompletion(ctx, req, res)
+			return paymentOutcome{StatusCode: http.StatusBadGateway, Body: res, Header: live}
 		}
 
-		// Default error response for true unknown errors (e.g. timeout)
-		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Processing error: %v", errCB)})
-		return
+		// Default error response for provider errors without a structured response
+		return paymentOutcome{
+			StatusCode: http.StatusBadGateway,
+			Body:       apiError(codeProviderError, fmt.Sprintf("Processing error: %v", errCB), ""),
+			Header:     live,
+		}
 	}
 
 	// Cast the result back to the expected type
 	res := result.(*providers.PaymentResponse)
 
+	outcome := outcomeFailed
+	if res.Status == "SUCCESS" {
+		outcome = outcomeSuccess
+		// Before the audit record and the stored result, so both carry the fee
+		a.applyFee(ctx, req, servedBy, res)
+		a.recordUsage(ctx, servedBy, req.Amount)
+	}
+	a.reportOutcome(ctx, req.TransactionID, servedBy, outcome, start)
+	a.recordAudit(ctx, req, servedBy, outcome, res)
+
 	// --- IDEMPOTENCY COMPLETION --- (Keep this section)
 	if res.Status == "SUCCESS" {
-		if err := a.Store.SetCompleted(r.Context(), req.TransactionID); err != nil {
-			log.Printf("Warning: Failed to set transaction %s as COMPLETED in Redis: %v", req.TransactionID, err)
+		// Store the result before flipping to COMPLETED so a replay always finds it
+		if err := a.Store.SetResult(ctx, key, res); err != nil {
+			slog.WarnContext(ctx, "failed to store result", "transaction_id", req.TransactionID, "error", err)
+		}
+		if err := a.Store.SetCompleted(ctx, key); err != nil {
+			slog.WarnContext(ctx, "failed to mark transaction completed", "transaction_id", req.TransactionID, "error", err)
 		}
-		res.IsIdempotent = true
 	}
 	// --- IDEMPOTENCY COMPLETION END ---
 
-	// Send the response back to the client
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+	a.notifyCompletion(ctx, req, res)
+	// A live provider call, not a replay: IsIdempotent stays false to match the header
+	res.IsIdempotent = false
+	return paymentOutcome{StatusCode: http.StatusOK, Body: res, Header: live}
+}
+
+// providerNameFromKey extracts the provider name from a ProviderKey by taking
+// everything before the first "-" and uppercasing it ("mtn-12345" -> "MTN").
+func providerNameFromKey(key string) string {
+	name, _, _ := strings.Cut(key, "-")
+	return strings.ToUpper(strings.TrimSpace(name))
 }
 
 func main() {
-	aggregator := newAggregator()
-	// ... (The rest of main() remains the same) ...
-	http.HandleFunc("/v1/pay", aggregator.PayHandler)
+	setupLogger()
+
+	// CONFIG_FILE is optional; without it everything comes from env vars and defaults
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		slog.Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	aggregator, err := newAggregator(cfg)
+	if err != nil {
+		slog.Error("startup failed", "error", err)
+		os.Exit(1)
 	}
-	log.Printf("Starting server on port %s...", port)
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
+	// Probe recovering providers so their breakers close without waiting for live traffic
+	prober := newHealthProber(aggregator, time.Duration(cfg.HealthProbeInterval))
+	// Work off payments queued during an outage (nil when queuing is disabled)
+	worker := newQueueWorker(aggregator, time.Duration(cfg.Queue.PollInterval), time.Duration(cfg.Server.RequestTimeout))
+
+	// Payment submission requires an API key (when any are configured); probes and metrics stay open
+	if len(cfg.Auth.APIKeys) == 0 {
+		slog.Warn("no API keys configured, payment endpoints are unauthenticated")
+	} else if len(cfg.Auth.AdminClients) == 0 {
+		slog.Warn("no admin clients configured, operator endpoints are closed")
+	}
+	// Rate limiting runs after authentication so buckets are keyed by client rather than IP
+	limiter := newRateLimiter(cfg.RateLimit)
+	defer limiter.Close()
+	mux := newMux(cfg, aggregator, limiter)
+
+	// How long in-flight payments get to finish once a shutdown signal arrives
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout)
+
+	// Every route, outermost first; the access log sits innermost to see the matched route
+	server := chain(
+		withRequestID,
+		func(next http.Handler) http.Handler { return withCORS(cfg.CORS, next) },
+		func(next http.Handler) http.Handler {
+			return withRequestTimeout(time.Duration(cfg.Server.RequestTimeout), next)
+		},
+	)
+	srv := &http.Server{
+		Addr:    cfg.Server.Address(),
+		Handler: server(withAccessLog(mux)),
+	}
+	// HTTPS when a certificate is configured; plain HTTP otherwise (local dev)
+	tlsConfig, certs, err := newTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		slog.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+	srv.TLSConfig = tlsConfig
+	// Bind before serving so a bad address or a port in use fails startup outright
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", srv.Addr, "error", err)
+		os.Exit(1)
+	}
+
+	// Cancelled on SIGINT/SIGTERM (e.g. ECS stopping the task during a deploy)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("starting server", "addr", ln.Addr().String(), "tls", tlsConfig != nil)
+		serve := srv.Serve
+		if tlsConfig != nil {
+			// Certificates come from TLSConfig.GetCertificate; SIGHUP reloads them
+			go certs.watch(ctx)
+			serve = func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }
+		}
+		if err := serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("shutdown signal received, draining in-flight requests", "timeout", shutdownTimeout.String())
+
+	// Let outstanding PayHandler calls finish so they don't leave stale IN_PROGRESS keys behind
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("graceful shutdown did not complete", "error", err)
+	}
+	prober.Close()
+	worker.Close()
+
+	// Finish accepted async payments before the callbacks they produce are flushed
+	if err := aggregator.Async.Close(shutdownCtx); err != nil {
+		slog.Error("async payments did not drain", "error", err)
+	}
+
+	// Give queued callbacks the rest of the shutdown window to go out
+	if err := aggregator.Webhooks.Close(shutdownCtx); err != nil {
+		slog.Error("webhook dispatcher did not drain", "error", err)
+	}
+
+	// Flush any spans still buffered for export
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("error flushing traces", "error", err)
+	}
+
+	// Release the store's connections last: the drains above may still write to it
+	if err := aggregator.Store.Close(); err != nil {
+		slog.Error("error closing idempotency store", "error", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+// newMux registers every route on a fresh ServeMux. Anything that reads or changes a
+// tenant's transactions sits behind the API key check and then withTenant, so an
+// authenticated client is always scoped to its own keys.
+func newMux(cfg config.Config, aggregator *Aggregator, limiter *rateLimiter) *http.ServeMux {
+	mux := http.NewServeMux()
+	authenticated := func(next http.Handler) http.Handler {
+		return requireAPIKey(cfg.Auth.APIKeys, withAdmin(cfg.Auth.APIKeys, cfg.Auth.AdminClients, next))
+	}
+	// Operator actions that affect every tenant need an admin client's key
+	admin := chain(authenticated, requireAdmin)
+	// Transactions are scoped per tenant (the API key's client, or X-Tenant-ID)
+	tenanted := chain(authenticated, withTenant)
+	payments := chain(authenticated, withTenant, limiter.Limit)
+	mux.Handle("/v1/pay", payments(http.HandlerFunc(aggregator.PayHandler)))
+	mux.Handle("/v1/pay/async", payments(http.HandlerFunc(aggregator.AsyncPayHandler)))
+	mux.Handle("/v1/pay/batch", payments(http.HandlerFunc(aggregator.BatchPayHandler)))
+	mux.Handle("/v1/refund", payments(http.HandlerFunc(aggregator.RefundHandler)))
+	mux.Handle("/v1/authorize", payments(http.HandlerFunc(aggregator.AuthorizeHandler)))
+	mux.Handle("/v1/capture", payments(http.HandlerFunc(aggregator.CaptureHandler)))
+	// Status polls (the statusURL of a 202) must resolve the same tenant the payment was made under
+	mux.Handle("/v1/transactions/", tenanted(http.HandlerFunc(aggregator.TransactionsHandler)))
+	mux.HandleFunc("/v1/providers", aggregator.ProvidersHandler)
+	mux.Handle("/v1/providers/", admin(http.HandlerFunc(aggregator.ProviderSwitchHandler)))
+	// Audit records carry their tenant; a client only sees its own
+	mux.Handle("/v1/audit", tenanted(http.HandlerFunc(aggregator.AuditHandler)))
+	// Dead letters hold whole payment requests: an API key is needed, and a client only sees its own
+	mux.Handle("/v1/deadletter", tenanted(http.HandlerFunc(aggregator.DeadLetterHandler)))
+	mux.Handle("/v1/deadletter/", tenanted(http.HandlerFunc(aggregator.ReprocessHandler)))
+	// Operator introspection: every provider's failure counts, so admin clients only
+	mux.Handle("/debug/breakers", admin(http.HandlerFunc(aggregator.BreakersDebugHandler)))
+	mux.HandleFunc("/healthz", aggregator.HealthHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// listen opens addr: a Unix domain socket for "unix:<path>" (replacing a stale
+// socket left behind by an unclean exit), otherwise a TCP host:port.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// Only ever remove a socket, never a regular file the path was pointed at by mistake
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
 	}
+	return net.Listen("unix", path)
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfiguredInProgressTTLIsTheClaimLease(t *testing.T) {
+	cfg := testConfig()
+	cfg.Idempotency.InProgressTTL = config.Duration(3 * time.Second)
+	deps, err := newDependencies(cfg)
+	if err != nil {
+		t.Fatalf("newDependencies: %v", err)
+	}
+	defer deps.Store.Close()
+
+	ctx := context.Background()
+	key := cache.TxnKey{TransactionID: "TXN-1"}
+	if state, err := deps.Store.CheckOrSetInProgress(ctx, key); err != nil || state != cache.StateNew {
+		t.Fatalf("CheckOrSetInProgress = %s, %v; want NEW", state, err)
+	}
+	left, err := deps.Store.LeaseRemaining(ctx, key)
+	if err != nil {
+		t.Fatalf("LeaseRemaining: %v", err)
+	}
+	if left > 3*time.Second || left < 2*time.Second {
+		t.Errorf("lease %s, want just under the configured 3s (default %s)", left, cache.InProgressExpiry)
+	}
+}
+
+func TestCurrencyIsNormalizedAndChecked(t *testing.T) {
+	cfg := testConfig()
+	cfg.Currencies = []string{" zar", "KES ", "usd"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		name      string
+		currency  string
+		want      int
+		wantCode  string
+		supported bool // The error lists the accepted currencies
+	}{
+		{"canonical", "ZAR", http.StatusOK, "", false},
+		{"lower case and padded", "  zar ", http.StatusOK, "", false},
+		{"another allowed currency", "kes", http.StatusOK, "", false},
+		{"valid code not allowed", "GHS", http.StatusUnprocessableEntity, codeUnsupportedCurrency, true},
+		{"allowed but no provider", "USD", http.StatusUnprocessableEntity, codeUnsupportedCurrency, true},
+		{"not a code", "dollars", http.StatusBadRequest, codeValidationFailed, false},
+		{"missing", "", http.StatusBadRequest, codeValidationFailed, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := payment(fmt.Sprintf("TXN-%d", i), 10)
+			req.Currency = tt.currency
+			rec := do(t, h, "POST", "/v1/pay", req)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK {
+				return
+			}
+			var body ErrorResponse
+			decode(t, rec, &body)
+			if body.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", body.Code, tt.wantCode)
+			}
+			if want := []string{"KES", "USD", "ZAR"}; tt.supported && !slices.Equal(body.SupportedCurrencies, want) {
+				t.Errorf("supportedCurrencies %v, want %v", body.SupportedCurrencies, want)
+			}
+		})
+	}
+}
+
+func TestNormalizedCurrencyReplaysTheSamePayment(t *testing.T) {
+	env := newTestEnv(t, testConfig())
+	h := env.handler(t, testConfig())
+
+	first := payment("TXN-1", 10)
+	first.Currency = "zar"
+	retry := payment("TXN-1", 10)
+	retry.Currency = " ZAR"
+	for _, req := range []providers.PaymentRequest{first, retry} {
+		if rec := do(t, h, "POST", "/v1/pay", req); rec.Code != http.StatusOK {
+			t.Fatalf("pay %q: status %d, body %s", req.Currency, rec.Code, rec.Body)
+		}
+	}
+	if calls := env.mtn.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want 1: the retry should replay", calls)
+	}
+}
+
+func TestCurrencyAllowlist(t *testing.T) {
+	routes := map[string]string{"ZAR": "MTN", "KES": "AIRTEL"}
+
+	got, err := currencyAllowlist(nil, routes)
+	if err != nil || len(got) != 2 || !got["ZAR"] || !got["KES"] {
+		t.Errorf("default allowlist = %v, %v; want the routed currencies", got, err)
+	}
+	got, err = currencyAllowlist([]string{" usd", "Eur "}, routes)
+	if err != nil || len(got) != 2 || !got["USD"] || !got["EUR"] {
+		t.Errorf("configured allowlist = %v, %v; want USD and EUR", got, err)
+	}
+	for _, bad := range []string{"dollars", "US", "U5D"} {
+		if _, err := currencyAllowlist([]string{"ZAR", bad}, routes); err == nil {
+			t.Errorf("allowlist with %q: no error", bad)
+		}
+	}
+}
+
+func TestIdempotencyKeyHeaderSuppliesTheTransactionID(t *testing.T) {
+	env := newTestEnv(t, testConfig())
+	h := env.handler(t, testConfig())
+
+	tests := []struct {
+		name   string
+		bodyID string
+		header string
+		want   int
+	}{
+		{"header only", "", "TXN-HEADER", http.StatusOK},
+		{"header and matching body", "TXN-BOTH", "TXN-BOTH", http.StatusOK},
+		{"header and different body", "TXN-BODY", "TXN-OTHER", http.StatusBadRequest},
+		{"neither", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.header != "" {
+				headers = []string{"Idempotency-Key", tt.header}
+			}
+			rec := do(t, h, "POST", "/v1/pay", payment(tt.bodyID, 10), headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if status, _ := env.store.GetStatus(context.Background(), cache.TxnKey{TransactionID: "TXN-HEADER"}); status != cache.StatusCompleted {
+		t.Errorf("TXN-HEADER status %q, want the header's ID used as the key", status)
+	}
+}
+
+func TestPaymentBodyIsDecodedStrictly(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.MaxBodyBytes = 128
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantCode string
+	}{
+		{"within the limit", `{"TransactionID":"TXN-1","Amount":10,"Currency":"ZAR"}`, http.StatusOK, ""},
+		{"over the limit", `{"TransactionID":"TXN-2","Amount":10,"Currency":"ZAR","CallbackURL":"https://example.com/` + strings.Repeat("x", 128) + `"}`, http.StatusRequestEntityTooLarge, codeBodyTooLarge},
+		{"unknown field", `{"TransactionID":"TXN-3","Ammount":10,"Currency":"ZAR"}`, http.StatusBadRequest, codeInvalidBody},
+		{"malformed JSON", `{"TransactionID":"TXN-4",`, http.StatusBadRequest, codeInvalidBody},
+		{"wrong type", `{"TransactionID":"TXN-5","Amount":"10","Currency":"ZAR"}`, http.StatusBadRequest, codeInvalidBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/pay", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.wantCode != "" {
+				var res ErrorResponse
+				decode(t, rec, &res)
+				if res.Code != tt.wantCode {
+					t.Errorf("code %s, want %s", res.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+	if got := env.mtn.calls.Load(); got != 1 {
+		t.Errorf("provider called %d times, want only for the valid body", got)
+	}
+}
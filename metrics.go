@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sony/gobreaker"
+)
+
+// Outcome labels recorded on paymentRequestsTotal.
+const (
+	outcomeSuccess          = "success"
+	outcomeFailed           = "failed"
+	outcomeDeclined         = "declined" // Business error: the provider refused the payment
+	outcomeTimeout          = "timeout"
+	outcomeBreakerOpen      = "breaker_open"
+	outcomeBulkheadFull     = "bulkhead_full"
+	outcomeDuplicate        = "duplicate"
+	outcomeKeyReused        = "key_reused" // Same TransactionID, different parameters
+	outcomeStoreUnavailable = "store_unavailable"
+	outcomeCancelled        = "cancelled" // An async payment cancelled before a provider was called
+)
+
+// Replay kinds recorded on idempotentReplaysTotal: how a repeated TransactionID was answered.
+const (
+	replayInProgress = "in_progress" // 425 (or InProgressStatus): the original is still being processed
+	replayServed     = "replayed"    // 200: the stored result was replayed
+	replayConflict   = "conflict"    // 409: completed, but no stored result to replay
+	replayFailure    = "failure"     // 402/502: a cached failure was replayed (see cacheFailure)
+)
+
+var (
+	// paymentRequestsTotal counts every PayHandler outcome per provider.
+	paymentRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "payment_requests_total",
+		Help: "Payment requests handled, by provider and outcome.",
+	}, []string{"provider", "outcome"})
+
+	// providerCallDuration tracks how long provider calls take (including retries).
+	providerCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "provider_call_duration_seconds",
+		Help:    "Latency of payment provider calls.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 10},
+	}, []string{"provider"})
+
+	// httpResponsesTotal counts every HTTP response by route, method and status code.
+	httpResponsesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_responses_total",
+		Help: "HTTP responses written, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// httpRequestDuration tracks end-to-end handler latency per route.
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// breakerState mirrors each breaker's State(): 0 = closed, 1 = half-open, 2 = open.
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "Current circuit breaker state (0 = closed, 1 = half-open, 2 = open).",
+	}, []string{"breaker"})
+)
+
+// breakerTransitionsTotal counts breaker state changes, e.g. closed -> open.
+var breakerTransitionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "circuit_breaker_transitions_total",
+	Help: "Circuit breaker state transitions, by breaker and from/to state.",
+}, []string{"breaker", "from", "to"})
+
+// healthProbesTotal counts background health checks of recovering providers.
+var healthProbesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "provider_health_probes_total",
+	Help: "Health probes sent to providers whose breaker is half-open, by provider and result.",
+}, []string{"provider", "result"})
+
+// paymentQueueEventsTotal counts payments deferred during an outage: enqueued,
+// requeued (still no provider), and processed.
+var paymentQueueEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "payment_queue_events_total",
+	Help: "Deferred payment queue activity, by event.",
+}, []string{"event"})
+
+// idempotentReplaysTotal counts requests answered from the idempotency store rather
+// than a provider. A spike usually means a client is stuck in a retry loop.
+var idempotentReplaysTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "idempotent_replays_total",
+	Help: "Payment requests answered from the idempotency store, by provider and kind.",
+}, []string{"provider", "kind"})
+
+// recordOutcome increments the request counter for a provider/outcome pair.
+func recordOutcome(provider, outcome string) {
+	paymentRequestsTotal.WithLabelValues(provider, outcome).Inc()
+}
+
+// recordReplay increments the replay counter for a provider/kind pair.
+func recordReplay(provider, kind string) {
+	idempotentReplaysTotal.WithLabelValues(provider, kind).Inc()
+}
+
+// recordHTTPResponse records one finished HTTP request.
+func recordHTTPResponse(route, method string, status int, elapsed time.Duration) {
+	httpResponsesTotal.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+}
+
+// recordBreakerState is wired into gobreaker's OnStateChange so the gauge follows every transition.
+func recordBreakerState(name string, from gobreaker.State, to gobreaker.State) {
+	breakerState.WithLabelValues(name).Set(float64(to))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"mime"
+	"net/http"
+	"payment-gateway-aggregator/requestid"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// middleware wraps a handler with behaviour shared across routes.
+type middleware func(http.Handler) http.Handler
+
+// chain composes middlewares in the order they run: chain(a, b)(h) is a(b(h)), so
+// a sees the request first and the response last.
+func chain(middlewares ...middleware) middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// maxRequestIDLength bounds client-supplied IDs so they can't bloat every log line.
+const maxRequestIDLength = 128
+
+// withRequestID reads X-Request-ID from the incoming request (generating a UUID
+// when it is absent), stores it in the request context, and echoes it back on
+// the response so clients can correlate their call with our logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// responseRecorder wraps an http.ResponseWriter to remember the status code and
+// body size a handler wrote, which net/http doesn't expose afterwards.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (Flush, deadlines).
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withRequestTimeout caps the total time spent on a request, Redis round trips and
+// provider retries included, by putting a deadline on its context. Handlers already
+// derive every downstream call from that context, so the per-provider timeout still
+// applies within it (whichever deadline is sooner wins) and an expired request ends
+// in the handler's usual 504/503 rather than a silently dropped connection.
+func withRequestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withAccessLog records every response's status, size and duration in the
+// http_* metrics and one "request completed" log line. Requests are labelled by
+// the mux pattern that served them rather than the raw path, so IDs in the URL
+// don't blow up metric cardinality.
+func withAccessLog(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		mux.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			// Handler returned without writing; net/http sends 200
+			rec.status = http.StatusOK
+		}
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		elapsed := time.Since(start)
+		recordHTTPResponse(route, r.Method, rec.status, elapsed)
+
+		attrs := []any{
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", elapsed.Milliseconds(),
+		}
+		if rec.status >= http.StatusInternalServerError {
+			slog.WarnContext(r.Context(), "request completed", attrs...)
+		} else {
+			slog.InfoContext(r.Context(), "request completed", attrs...)
+		}
+	})
+}
+
+// methodNotAllowed writes a 405 with the Allow header (required by RFC 9110)
+// listing the methods the endpoint accepts.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, apiError(codeMethodNotAllowed, "Method Not Allowed", ""))
+}
+
+// requireJSON writes a 415 unless the request declares a JSON body
+// ("application/json", with or without parameters such as charset) and
+// reports whether the handler may go on decoding it.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+	writeError(w, http.StatusUnsupportedMediaType, apiError(codeUnsupportedMediaType,
+		"Unsupported Media Type", "Content-Type must be application/json."))
+	return false
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMethodNotAllowedSetsAllow(t *testing.T) {
+	rec := httptest.NewRecorder()
+	methodNotAllowed(rec, "GET", "DELETE")
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, DELETE" {
+		t.Errorf("Allow = %q, want \"GET, DELETE\"", got)
+	}
+	var body ErrorResponse
+	decode(t, rec, &body)
+	if body.Code != codeMethodNotAllowed {
+		t.Errorf("code %s, want %s", body.Code, codeMethodNotAllowed)
+	}
+}
+
+func TestEveryRouteAnswers405WithAllow(t *testing.T) {
+	cfg := testConfig()
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		method, path, allow string
+	}{
+		{"GET", "/v1/pay", "POST"},
+		{"PUT", "/v1/pay", "POST"},
+		{"GET", "/v1/pay/async", "POST"},
+		{"GET", "/v1/pay/batch", "POST"},
+		{"GET", "/v1/refund", "POST"},
+		{"GET", "/v1/authorize", "POST"},
+		{"GET", "/v1/capture", "POST"},
+		{"POST", "/v1/transactions/TXN-1", "GET, DELETE"},
+		{"GET", "/v1/transactions/TXN-1/cancel", "POST"},
+		{"POST", "/v1/providers", "GET"},
+		{"GET", "/v1/providers/MTN", "POST"},
+		{"POST", "/v1/audit", "GET"},
+		{"POST", "/v1/deadletter", "GET"},
+		{"GET", "/v1/deadletter/TXN-1", "POST"},
+		{"POST", "/debug/breakers", "GET"},
+		{"POST", "/healthz", "GET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := do(t, h, tt.method, tt.path, nil)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status %d, want 405 (body %s)", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestPaymentsRequireJSONContentType(t *testing.T) {
+	cfg := testConfig()
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		contentType string // Empty sends no Content-Type header
+		want        int
+	}{
+		{"", http.StatusUnsupportedMediaType},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"multipart/form-data; boundary=x", http.StatusUnsupportedMediaType},
+		{"application/jsonp", http.StatusUnsupportedMediaType},
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"Application/JSON", http.StatusOK},
+		{"application/json;charset=UTF-8", http.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			body := fmt.Sprintf(`{"TransactionID":"TXN-%d","Amount":10,"Currency":"ZAR"}`, i)
+			req := httptest.NewRequest("POST", "/v1/pay", strings.NewReader(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusUnsupportedMediaType {
+				var res ErrorResponse
+				decode(t, rec, &res)
+				if res.Code != codeUnsupportedMediaType {
+					t.Errorf("code %s, want %s", res.Code, codeUnsupportedMediaType)
+				}
+			}
+		})
+	}
+	if got, want := env.mtn.calls.Load(), int32(4); got != want {
+		t.Errorf("provider called %d times, want %d (only for JSON)", got, want)
+	}
+}
+
+func TestEveryJSONRouteRejectsOtherContentTypes(t *testing.T) {
+	cfg := testConfig()
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	for _, path := range []string{"/v1/pay", "/v1/pay/async", "/v1/pay/batch"} {
+		t.Run(path, func(t *testing.T) {
+			rec := do(t, h, "POST", path, payment("TXN-1", 10), "Content-Type", "text/plain")
+			if rec.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("status %d, want 415 (body %s)", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestChainRunsMiddlewaresInOrder(t *testing.T) {
+	var seen []string
+	record := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = append(seen, name+" in")
+				next.ServeHTTP(w, r)
+				seen = append(seen, name+" out")
+			})
+		}
+	}
+	h := chain(record("a"), record("b"), record("c"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, "handler")
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := []string{"a in", "b in", "c in", "handler", "c out", "b out", "a out"}
+	if !slices.Equal(seen, want) {
+		t.Errorf("ran %v, want %v", seen, want)
+	}
+}
+
+func TestRoutesAuthenticateBeforeAnythingElse(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "ops": "ops-key"}
+	cfg.Auth.AdminClients = []string{"ops"}
+	cfg.RateLimit.Rate = 0.001
+	cfg.RateLimit.Burst = 1
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	// Rejected keys never reach the limiter, so they don't spend the client's budget
+	for range 3 {
+		if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-API-Key", "wrong-key"); rec.Code != http.StatusForbidden {
+			t.Fatalf("wrong key: status %d, want 403", rec.Code)
+		}
+	}
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-API-Key", "shop-key"); rec.Code != http.StatusOK {
+		t.Fatalf("first payment: status %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-2", 10), "X-API-Key", "shop-key"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second payment: status %d, want 429", rec.Code)
+	}
+
+	// The admin check runs after authentication, on every operator endpoint
+	adminRoutes := []struct{ method, path string }{
+		{"POST", "/v1/providers/MTN/enable"},
+		{"GET", "/debug/breakers"},
+	}
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"no API key", nil, http.StatusUnauthorized},
+		{"non-admin client", []string{"X-API-Key", "shop-key"}, http.StatusForbidden},
+		{"admin client", []string{"X-API-Key", "ops-key"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, route := range adminRoutes {
+				rec := do(t, h, route.method, route.path, nil, tt.headers...)
+				if rec.Code != tt.want {
+					t.Errorf("%s %s: status %d, want %d (body %s)", route.method, route.path, rec.Code, tt.want, rec.Body)
+				}
+			}
+		})
+	}
+}
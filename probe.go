@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// healthProber health-checks providers whose breaker isn't closed. gobreaker only
+// leaves Open once its timeout has passed, and then only on the next call, so a
+// low-traffic provider would otherwise sit half-open until a real payment became
+// its trial request. The prober makes the health check that trial instead:
+// successes close the breaker, a failure re-opens it.
+type healthProber struct {
+	a        *Aggregator
+	interval time.Duration
+
+	// stopCtx is cancelled by Close, aborting in-flight probes
+	stopCtx context.Context
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+// newHealthProber starts probing every interval; call Close to stop it.
+func newHealthProber(a *Aggregator, interval time.Duration) *healthProber {
+	stopCtx, stop := context.WithCancel(context.Background())
+	p := &healthProber{
+		a:        a,
+		interval: interval,
+		stopCtx:  stopCtx,
+		stop:     stop,
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Close stops the probe loop, cancelling any in-flight probes, and waits for it to exit.
+func (p *healthProber) Close() {
+	p.stop()
+	<-p.done
+}
+
+func (p *healthProber) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCtx.Done():
+			return
+		case <-ticker.C:
+			p.probeAll()
+		}
+	}
+}
+
+// probeAll checks every provider whose breaker is half-open, concurrently so one
+// slow provider can't delay the others' recovery.
+func (p *healthProber) probeAll() {
+	var wg sync.WaitGroup
+	for key, breaker := range p.a.Breakers {
+		// State() is also what moves an expired Open breaker to half-open
+		if breaker.State() != gobreaker.StateHalfOpen {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.probe(key)
+		}()
+	}
+	wg.Wait()
+}
+
+// probe runs one health check through the provider's bulkhead, timeout and breaker.
+func (p *healthProber) probe(key string) {
+	provider := p.a.Providers[key]
+	_, err := p.a.callGuarded(p.stopCtx, key, func(ctx context.Context) (interface{}, error) {
+		return nil, provider.HealthCheck(ctx)
+	})
+	if p.stopCtx.Err() != nil || isBreakerRejection(err) {
+		return // Shutting down, or live traffic already holds the trial slot
+	}
+	if err != nil {
+		healthProbesTotal.WithLabelValues(key, "failure").Inc()
+		slog.Warn("provider health probe failed", "provider", key, "error", err)
+		return
+	}
+	healthProbesTotal.WithLabelValues(key, "success").Inc()
+	slog.Info("provider health probe succeeded", "provider", key, "breaker_state", p.a.Breakers[key].State().String())
+}
@@ -3,25 +3,26 @@ package providers
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"time"
 )
 
 // AirtelProvider implements the PaymentProvider interface.
-type AirtelProvider struct{}
+type AirtelProvider struct {
+	Simulation
+}
 
-func NewAirtelProvider() *AirtelProvider {
-	return &AirtelProvider{}
+func NewAirtelProvider(opts ...SimulationOption) *AirtelProvider {
+	return &AirtelProvider{Simulation: newSimulation(opts)}
 }
 
 func (p *AirtelProvider) Name() string {
-	return "AIRTEL_MONEY"
+	return p.nameOr("AIRTEL_MONEY")
 }
 
 // ProcessPayment simulates interaction with the Airtel Money API.
 func (p *AirtelProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
-	// Simulate Network Latency (200ms to 800ms)
-	delay := time.Duration(rand.Intn(600)+200) * time.Millisecond
+	// Simulate Network Latency (200ms to 800ms by default)
+	delay := p.latency()
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -29,8 +30,25 @@ func (p *AirtelProvider) ProcessPayment(ctx context.Context, req PaymentRequest)
 		// Continue
 	}
 
-	// 1. Simulate external API Errors (80% chance of 500 server error)
-	if rand.Float64() < 0.80 {
+	// 0. Like the real API, a retried payment returns the original result instead of charging twice
+	if ref, ok := p.refs.lookup(req.DedupKey()); ok {
+		return &PaymentResponse{
+			Status:       "SUCCESS",
+			ReferenceID:  ref,
+			ProviderName: p.Name(),
+			IsIdempotent: true,
+			Message:      "Duplicate request; returning the original transaction.",
+		}, nil
+	}
+
+	// 1. In simulator mode a magic amount fixes the outcome (see MagicAmountSuccess)
+	forced, res, err := p.magicOutcome(ctx, req.Amount, p.Name())
+	if err != nil {
+		return res, err
+	}
+
+	// 2. Simulate external API Errors (80% chance of 500 server error by default)
+	if !forced && p.fails() {
 		// Create the response object
 		res := &PaymentResponse{
 			Status:       "FAILED",
@@ -39,15 +57,111 @@ func (p *AirtelProvider) ProcessPayment(ctx context.Context, req PaymentRequest)
 			Message:      "Airtel provider internal server error (simulated 500)",
 		}
 		// Return both the structured response AND a Go error to trip the Circuit Breaker
-		return res, fmt.Errorf("provider failure: %s", res.Message)
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
 	}
 
-	// 2. Simulate Success
+	// 3. Simulate a decline: the provider is fine, the payer's wallet said no
+	if err := p.decline(); !forced && err != nil {
+		res := &PaymentResponse{
+			Status:       StatusDeclined,
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      fmt.Sprintf("Payment declined: %s (simulated)", err),
+		}
+		return res, fmt.Errorf("provider declined: %w", err)
+	}
+
+	// 4. Simulate Success
+	ref := fmt.Sprintf("AIRTEL-%d", time.Now().UnixNano())
+	p.refs.store(req.DedupKey(), ref)
 	return &PaymentResponse{
 		Status:       "SUCCESS",
-		ReferenceID:  fmt.Sprintf("AIRTEL-%d", time.Now().UnixNano()),
+		ReferenceID:  ref,
 		ProviderName: p.Name(),
 		IsIdempotent: false,
 		Message:      "Transaction processed successfully via Airtel.",
 	}, nil
 }
+
+// Refund simulates reversing a payment through the Airtel Money API, with the same
+// latency and failure behaviour as ProcessPayment.
+func (p *AirtelProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &RefundResponse{
+			Status:       "FAILED",
+			RefundID:     "N/A",
+			ProviderName: p.Name(),
+			Message:      "Airtel provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &RefundResponse{
+		Status:       "SUCCESS",
+		RefundID:     fmt.Sprintf("AIRTEL-RF-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Refund processed successfully via Airtel.",
+	}, nil
+}
+
+// Authorize simulates placing a hold through the Airtel Money API, with the same latency
+// and failure behaviour as ProcessPayment.
+func (p *AirtelProvider) Authorize(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      "Airtel provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &PaymentResponse{
+		Status:       StatusAuthorized,
+		ReferenceID:  fmt.Sprintf("AIRTEL-AUTH-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Funds authorized via Airtel.",
+	}, nil
+}
+
+// Capture simulates settling a hold through the Airtel Money API.
+func (p *AirtelProvider) Capture(ctx context.Context, req CaptureRequest) (*PaymentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      "Airtel provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &PaymentResponse{
+		Status:       "SUCCESS",
+		ReferenceID:  fmt.Sprintf("AIRTEL-CAP-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Capture processed successfully via Airtel.",
+	}, nil
+}
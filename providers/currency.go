@@ -0,0 +1,37 @@
+package providers
+
+import "math"
+
+// zeroDecimalCurrencies have no minor unit (ISO 4217 exponent 0). Every other
+// currency is treated as having two decimals.
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true,
+	"KMF": true, "KRW": true, "PYG": true, "RWF": true, "UGX": true, "UYI": true,
+	"VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// CurrencyExponent returns the number of decimals in currency's minor unit,
+// e.g. 2 for ZAR (cents) and 0 for UGX.
+func CurrencyExponent(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+// ToMinorUnits converts a major-unit amount to an integer count of currency's minor
+// unit (ZAR 10.50 -> 1050), rounding away float noise such as 0.1+0.2. ok is false
+// when amount has more decimals than the currency does (ZAR 10.505).
+func ToMinorUnits(amount float64, currency string) (minor int64, ok bool) {
+	scaled := amount * math.Pow10(CurrencyExponent(currency))
+	rounded := math.Round(scaled)
+	// Allow for the float error of the multiplication, which grows with the amount
+	tolerance := math.Max(1e-6, math.Abs(scaled)*1e-15)
+	return int64(rounded), math.Abs(scaled-rounded) < tolerance
+}
+
+// FromMinorUnits converts an integer count of currency's minor unit back to a
+// major-unit amount (ZAR 1050 -> 10.50).
+func FromMinorUnits(minor int64, currency string) float64 {
+	return float64(minor) / math.Pow10(CurrencyExponent(currency))
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"context"
+)
+
+// DryRunMessage marks every response produced in DRY_RUN mode so nobody
+// mistakes it for a real payment.
+const DryRunMessage = "DRY_RUN"
+
+// DryRunProvider wraps a provider and never calls it: every payment returns a
+// deterministic synthetic success. It is used for load and integration testing of
+// the aggregator's own logic (idempotency, routing, breakers) without provider flakiness.
+type DryRunProvider struct {
+	inner PaymentProvider
+}
+
+// NewDryRunProvider wraps inner; the wrapper reports inner's Name().
+func NewDryRunProvider(inner PaymentProvider) *DryRunProvider {
+	return &DryRunProvider{inner: inner}
+}
+
+func (p *DryRunProvider) Name() string {
+	return p.inner.Name()
+}
+
+// ProcessPayment returns a synthetic SUCCESS. The ReferenceID is derived from the
+// TransactionID so repeated runs are reproducible.
+func (p *DryRunProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &PaymentResponse{
+		Status:       "SUCCESS",
+		ReferenceID:  "DRYRUN-" + req.TransactionID,
+		ProviderName: p.Name(),
+		IsIdempotent: false,
+		Message:      DryRunMessage,
+	}, nil
+}
+
+// Refund returns a synthetic SUCCESS with a RefundID derived from the TransactionID.
+func (p *DryRunProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &RefundResponse{
+		Status:       "SUCCESS",
+		RefundID:     "DRYRUN-RF-" + req.TransactionID,
+		ProviderName: p.Name(),
+		Message:      DryRunMessage,
+	}, nil
+}
+
+// Authorize returns a synthetic AUTHORIZED hold with an ID derived from the TransactionID.
+func (p *DryRunProvider) Authorize(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &PaymentResponse{
+		Status:       StatusAuthorized,
+		ReferenceID:  "DRYRUN-AUTH-" + req.TransactionID,
+		ProviderName: p.Name(),
+		Message:      DryRunMessage,
+	}, nil
+}
+
+// Capture returns a synthetic SUCCESS with a ReferenceID derived from the TransactionID.
+func (p *DryRunProvider) Capture(ctx context.Context, req CaptureRequest) (*PaymentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &PaymentResponse{
+		Status:       "SUCCESS",
+		ReferenceID:  "DRYRUN-CAP-" + req.TransactionID,
+		ProviderName: p.Name(),
+		Message:      DryRunMessage,
+	}, nil
+}
+
+// HealthCheck always passes: a dry run never depends on the real provider.
+func (p *DryRunProvider) HealthCheck(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,46 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StatusDeclined is the PaymentResponse status for a payment the provider refused.
+const StatusDeclined = "DECLINED"
+
+// Business errors: the provider worked and said no. They are final for the request
+// (retrying won't change the answer) and say nothing about the provider's health, so
+// they don't count towards opening its circuit breaker. Providers wrap them, e.g.
+// fmt.Errorf("%w: ...", ErrDeclined); check with errors.Is or IsBusinessError.
+var (
+	ErrDeclined          = errors.New("payment declined")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrRejected is a 4xx from the provider: the request, not the provider, is at fault
+	ErrRejected = errors.New("request rejected by provider")
+)
+
+// ErrProviderInternal is a provider fault: a 5xx, a malfunction, or a simulated
+// failure. It counts towards opening the provider's circuit breaker.
+var ErrProviderInternal = errors.New("provider failure")
+
+// ErrNoTimeLeft means a call was skipped because the request's deadline was too
+// close for it to finish. It wraps context.DeadlineExceeded so it is handled as a
+// timeout, but the provider was never contacted, so it says nothing about its health.
+var ErrNoTimeLeft = fmt.Errorf("not enough time left to call the provider: %w", context.DeadlineExceeded)
+
+// TimeLeft returns how long ctx has until its deadline; ok is false when it has none.
+func TimeLeft(ctx context.Context) (left time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// IsBusinessError reports whether err is (or wraps) one of the business errors.
+// Anything else (5xx, timeouts, transport errors) is a provider fault.
+func IsBusinessError(err error) bool {
+	return errors.Is(err, ErrDeclined) || errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrRejected)
+}
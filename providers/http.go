@@ -0,0 +1,211 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxResponseBody bounds how much of a provider's response we will read.
+const maxResponseBody = 1 << 20 // 1 MiB
+
+// IdempotencyKeyHeader carries PaymentRequest.DedupKey so the provider can
+// recognise a retried payment and return the original result instead of charging twice.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// HTTPProvider implements the PaymentProvider interface against a real HTTP API.
+// It is the reference adapter for wiring an actual payment service into the aggregator:
+// the PaymentRequest is POSTed as JSON to <BaseURL>/payments (refunds, authorizations
+// and captures to <BaseURL>/refunds, /authorizations and /captures)
+// with an Idempotency-Key header, and the provider is expected to answer with a
+// PaymentResponse-shaped JSON body.
+type HTTPProvider struct {
+	name         string
+	baseURL      string
+	client       *http.Client
+	minRemaining time.Duration // Calls with less time left than this fail fast with ErrNoTimeLeft
+}
+
+// NewHTTPProvider creates an adapter for the provider at baseURL.
+// If client is nil, http.DefaultClient is used; timeouts come from the request context.
+// A call whose context has less than minRemaining left is not sent (0 always sends).
+func NewHTTPProvider(name, baseURL string, client *http.Client, minRemaining time.Duration) *HTTPProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPProvider{
+		name:         name,
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		client:       client,
+		minRemaining: minRemaining,
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.name
+}
+
+// ProcessPayment POSTs the request to the provider and maps the HTTP result to a PaymentResponse.
+// Any non-2xx status returns a FAILED response together with an error, so it trips the Circuit Breaker.
+func (p *HTTPProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	return p.paymentCall(ctx, "/payments", req, req.DedupKey(), "SUCCESS")
+}
+
+// Authorize POSTs the request to <BaseURL>/authorizations. A 2xx without a Status
+// is taken as AUTHORIZED.
+func (p *HTTPProvider) Authorize(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	return p.paymentCall(ctx, "/authorizations", req, "auth:"+req.DedupKey(), StatusAuthorized)
+}
+
+// Capture POSTs the request to <BaseURL>/captures.
+func (p *HTTPProvider) Capture(ctx context.Context, req CaptureRequest) (*PaymentResponse, error) {
+	return p.paymentCall(ctx, "/captures", req, "capture:"+req.AuthorizationID, "SUCCESS")
+}
+
+// paymentCall POSTs payload to path and maps the result to a PaymentResponse, using
+// defaultStatus when a 2xx body doesn't carry one.
+func (p *HTTPProvider) paymentCall(ctx context.Context, path string, payload interface{}, idempotencyKey, defaultStatus string) (*PaymentResponse, error) {
+	data, status, err := p.post(ctx, path, payload, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if status < 200 || status > 299 {
+		res := &PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      fmt.Sprintf("%s returned HTTP %d", p.Name(), status),
+		}
+		if status == http.StatusPaymentRequired {
+			res.Status = StatusDeclined
+		}
+		// Return both the structured response AND a Go error; only provider faults trip the Circuit Breaker
+		return res, statusError(status, res.Message)
+	}
+
+	var res PaymentResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("decoding provider response: %w", err)
+	}
+	res.ProviderName = p.Name()
+	res.IsIdempotent = false
+	if res.Status == "" {
+		res.Status = defaultStatus
+	}
+
+	return &res, nil
+}
+
+// Refund POSTs the request to <BaseURL>/refunds and maps the HTTP result to a
+// RefundResponse, with the same error semantics as ProcessPayment.
+func (p *HTTPProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	data, status, err := p.post(ctx, "/refunds", req, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if status < 200 || status > 299 {
+		res := &RefundResponse{
+			Status:       "FAILED",
+			RefundID:     "N/A",
+			ProviderName: p.Name(),
+			Message:      fmt.Sprintf("%s returned HTTP %d", p.Name(), status),
+		}
+		return res, statusError(status, res.Message)
+	}
+
+	var res RefundResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("decoding provider response: %w", err)
+	}
+	res.ProviderName = p.Name()
+	if res.Status == "" {
+		res.Status = "SUCCESS"
+	}
+
+	return &res, nil
+}
+
+// statusError maps a non-2xx status to an error: 402 is a decline and other 4xx
+// (bar 408 and 429, which mean the provider is struggling) a rejected request, both
+// business errors; everything else is ErrProviderInternal.
+func statusError(status int, message string) error {
+	switch {
+	case status == http.StatusPaymentRequired:
+		return fmt.Errorf("%w: %s", ErrDeclined, message)
+	case status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrRejected, message)
+	default:
+		return fmt.Errorf("%w: %s", ErrProviderInternal, message)
+	}
+}
+
+// HealthCheck GETs baseURL+"/health"; any 2xx status means healthy.
+func (p *HTTPProvider) HealthCheck(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+
+	httpRes, err := p.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("provider request failed: %w", err)
+	}
+	defer httpRes.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(httpRes.Body, maxResponseBody))
+
+	if httpRes.StatusCode < 200 || httpRes.StatusCode > 299 {
+		return fmt.Errorf("%w: %s health check returned HTTP %d", ErrProviderInternal, p.Name(), httpRes.StatusCode)
+	}
+	return nil
+}
+
+// post sends payload as JSON to baseURL+path and returns the (size-limited) response body and status code.
+// A non-empty idempotencyKey is sent in the Idempotency-Key header.
+func (p *HTTPProvider) post(ctx context.Context, path string, payload interface{}, idempotencyKey string) ([]byte, int, error) {
+	// A call that can't finish before the deadline only adds load on the provider
+	if left, ok := TimeLeft(ctx); ok && left < p.minRemaining {
+		return nil, 0, fmt.Errorf("%s %s with %s left: %w", p.Name(), path, left.Round(time.Millisecond), ErrNoTimeLeft)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("encoding request: %w", err)
+	}
+
+	// NewRequestWithContext ties the call to the handler's deadline and cancellation
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("building request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	if idempotencyKey != "" {
+		httpReq.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+
+	httpRes, err := p.client.Do(httpReq)
+	if err != nil {
+		// Return the context error itself so the handler can tell a timeout from a provider fault
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("provider request failed: %w", err)
+	}
+	defer httpRes.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpRes.Body, maxResponseBody))
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading provider response: %w", err)
+	}
+	return data, httpRes.StatusCode, nil
+}
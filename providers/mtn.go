@@ -3,31 +3,25 @@ package providers
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"time"
 )
 
-func init() {
-	// Use the init function to seed the global generator exactly ONCE upon package load
-	rand.Seed(time.Now().UnixNano())
+type MTNProvider struct {
+	Simulation
 }
 
-type MTNProvider struct{}
-
-func NewMTNProvider() *MTNProvider {
-	// FIX: Seed the random number generator only once when the provider is created.
-	// This ensures that the failure logic is truly random on each server run.
-	return &MTNProvider{}
+func NewMTNProvider(opts ...SimulationOption) *MTNProvider {
+	return &MTNProvider{Simulation: newSimulation(opts)}
 }
 
 func (p *MTNProvider) Name() string {
-	return "MTN_MOMO"
+	return p.nameOr("MTN_MOMO")
 }
 
 // ProcessPayment simulates interaction with the MTN MoMo API.
 func (p *MTNProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
-	// Simulate Network Latency (200ms to 800ms)
-	delay := time.Duration(rand.Intn(600)+200) * time.Millisecond
+	// Simulate Network Latency (200ms to 800ms by default)
+	delay := p.latency()
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err() // Handle context cancellation (timeout)
@@ -35,8 +29,25 @@ func (p *MTNProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*
 		// Continue
 	}
 
-	// 1. Simulate external API Errors (80% chance of 500 server error)
-	if rand.Float64() < 0.80 {
+	// 0. Like the real API, a retried payment returns the original result instead of charging twice
+	if ref, ok := p.refs.lookup(req.DedupKey()); ok {
+		return &PaymentResponse{
+			Status:       "SUCCESS",
+			ReferenceID:  ref,
+			ProviderName: p.Name(),
+			IsIdempotent: true,
+			Message:      "Duplicate request; returning the original transaction.",
+		}, nil
+	}
+
+	// 1. In simulator mode a magic amount fixes the outcome (see MagicAmountSuccess)
+	forced, res, err := p.magicOutcome(ctx, req.Amount, p.Name())
+	if err != nil {
+		return res, err
+	}
+
+	// 2. Simulate external API Errors (80% chance of 500 server error by default)
+	if !forced && p.fails() {
 		// Create the response object
 		res := &PaymentResponse{
 			Status:       "FAILED",
@@ -45,15 +56,111 @@ func (p *MTNProvider) ProcessPayment(ctx context.Context, req PaymentRequest) (*
 			Message:      "Provider internal server error (simulated 500)",
 		}
 		// RETURN BOTH THE RESPONSE AND A NEW ERROR OBJECT
-		return res, fmt.Errorf("provider failure: %s", res.Message) // <-- CRITICAL CHANGE HERE
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	// 3. Simulate a decline: the provider is fine, the payer's wallet said no
+	if err := p.decline(); !forced && err != nil {
+		res := &PaymentResponse{
+			Status:       StatusDeclined,
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      fmt.Sprintf("Payment declined: %s (simulated)", err),
+		}
+		return res, fmt.Errorf("provider declined: %w", err)
 	}
 
-	// 2. Simulate Success
+	// 4. Simulate Success
+	ref := fmt.Sprintf("MTN-%d", time.Now().UnixNano())
+	p.refs.store(req.DedupKey(), ref)
 	return &PaymentResponse{
 		Status:       "SUCCESS",
-		ReferenceID:  fmt.Sprintf("MTN-%d", time.Now().UnixNano()),
+		ReferenceID:  ref,
 		ProviderName: p.Name(),
 		IsIdempotent: false,
 		Message:      "Transaction processed successfully.",
 	}, nil // Success returns nil error
 }
+
+// Refund simulates reversing a payment through the MTN MoMo API, with the same
+// latency and failure behaviour as ProcessPayment.
+func (p *MTNProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &RefundResponse{
+			Status:       "FAILED",
+			RefundID:     "N/A",
+			ProviderName: p.Name(),
+			Message:      "Provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &RefundResponse{
+		Status:       "SUCCESS",
+		RefundID:     fmt.Sprintf("MTN-RF-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Refund processed successfully.",
+	}, nil
+}
+
+// Authorize simulates placing a hold through the MTN MoMo API, with the same latency
+// and failure behaviour as ProcessPayment.
+func (p *MTNProvider) Authorize(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      "Provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &PaymentResponse{
+		Status:       StatusAuthorized,
+		ReferenceID:  fmt.Sprintf("MTN-AUTH-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Funds authorized.",
+	}, nil
+}
+
+// Capture simulates settling a hold through the MTN MoMo API.
+func (p *MTNProvider) Capture(ctx context.Context, req CaptureRequest) (*PaymentResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency()):
+		// Continue
+	}
+
+	if p.fails() {
+		res := &PaymentResponse{
+			Status:       "FAILED",
+			ReferenceID:  "N/A",
+			ProviderName: p.Name(),
+			Message:      "Provider internal server error (simulated 500)",
+		}
+		return res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+
+	return &PaymentResponse{
+		Status:       "SUCCESS",
+		ReferenceID:  fmt.Sprintf("MTN-CAP-%d", time.Now().UnixNano()),
+		ProviderName: p.Name(),
+		Message:      "Capture processed successfully.",
+	}, nil
+}
@@ -2,27 +2,231 @@ package providers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // PaymentRequest contains the necessary data for a transaction.
 type PaymentRequest struct {
 	TransactionID string
 	Amount        float64
+	AmountMinor   int64 // Optional alternative to Amount in the currency's minor unit, e.g. 1050 for ZAR 10.50
 	Currency      string
 	ProviderKey   string // e.g., 'MTN-12345'
+	CallbackURL   string // Optional; the final PaymentResponse is POSTed here once the payment completes
+	Country       string // Optional ISO 3166-1 alpha-2 code (e.g. "ZM"); selects a regional provider instance
+
+	// ProviderPreference optionally lists the providers to try, in order, instead of
+	// the configured route (e.g. ["AIRTEL", "MTN"]). Mutually exclusive with ProviderKey.
+	ProviderPreference []string `json:",omitempty"`
+
+	// Split allows the aggregator to divide a payment larger than any one provider
+	// accepts into several sub-payments (legs). It succeeds only if every leg does.
+	Split bool `json:",omitempty"`
+
+	// IdempotencyKey is set by the aggregator (never by clients) and passed on to the
+	// provider so it de-duplicates retries on its side too. Falls back to TransactionID.
+	IdempotencyKey string `json:"-"`
+
+	// IdempotencyTTL is how long the result is kept for replay, from the client's
+	// Idempotency-TTL header (already capped); 0 keeps the configured default.
+	IdempotencyTTL time.Duration `json:"-"`
+}
+
+// DedupKey returns the key a provider should de-duplicate this payment on.
+func (r PaymentRequest) DedupKey() string {
+	if r.IdempotencyKey != "" {
+		return r.IdempotencyKey
+	}
+	return r.TransactionID
+}
+
+// Fingerprint hashes the fields that define what a payment does (Amount, Currency,
+// ProviderKey, Country, ProviderPreference, Split), so a TransactionID reused for a different
+// payment can be told apart from a genuine retry.
+func (r PaymentRequest) Fingerprint() string {
+	canonical := strconv.FormatFloat(r.Amount, 'f', -1, 64) + "|" + r.Currency + "|" + r.ProviderKey
+	if r.Country != "" {
+		// Appended only when set, so fingerprints stored before Country existed still match
+		canonical += "|" + r.Country
+	}
+	if len(r.ProviderPreference) > 0 {
+		canonical += "|" + strings.Join(r.ProviderPreference, ",")
+	}
+	if r.Split {
+		canonical += "|split"
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeAmount fills in whichever of Amount and AmountMinor the client left out,
+// so either can be sent. Call it after normalizing Currency; Validate checks that
+// the two agree.
+func (r *PaymentRequest) NormalizeAmount() {
+	switch {
+	case r.AmountMinor != 0 && r.Amount == 0:
+		r.Amount = FromMinorUnits(r.AmountMinor, r.Currency)
+	case r.Amount != 0 && r.AmountMinor == 0:
+		if minor, ok := ToMinorUnits(r.Amount, r.Currency); ok {
+			r.AmountMinor = minor
+		}
+	}
+}
+
+// Validate checks that the request is well-formed before it is allowed to
+// consume an idempotency key or a circuit breaker slot.
+func (r PaymentRequest) Validate() error {
+	if r.TransactionID == "" {
+		return errors.New("TransactionID is required")
+	}
+	if r.Amount <= 0 {
+		return errors.New("Amount must be greater than zero")
+	}
+	if !IsCurrencyCode(r.Currency) {
+		return errors.New("Currency must be a 3-letter ISO 4217 code, e.g. 'ZAR'")
+	}
+	if minor, ok := ToMinorUnits(r.Amount, r.Currency); !ok {
+		return fmt.Errorf("Amount has more decimals than %s allows (%d)", r.Currency, CurrencyExponent(r.Currency))
+	} else if minor != r.AmountMinor {
+		return errors.New("Amount and AmountMinor disagree; send only one of them")
+	}
+	if r.Country != "" && !isCountryCode(r.Country) {
+		return errors.New("Country must be a 2-letter ISO 3166-1 code, e.g. 'ZM'")
+	}
+	if r.ProviderKey != "" && len(r.ProviderPreference) > 0 {
+		return errors.New("send either ProviderKey or ProviderPreference, not both")
+	}
+	for _, name := range r.ProviderPreference {
+		if name == "" {
+			return errors.New("ProviderPreference must not contain empty provider names")
+		}
+	}
+	if r.CallbackURL != "" && !isCallbackURL(r.CallbackURL) {
+		return errors.New("CallbackURL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// isCallbackURL reports whether s is an absolute http(s) URL with a host.
+func isCallbackURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isCountryCode reports whether s looks like an ISO 3166-1 alpha-2 code (two uppercase letters).
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// NormalizeCountry trims surrounding whitespace and upper-cases a country code.
+func NormalizeCountry(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+// NormalizeProviderPreference trims and upper-cases each provider name in names,
+// so "mtn" and "MTN" name the same provider.
+func NormalizeProviderPreference(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = strings.ToUpper(strings.TrimSpace(name))
+	}
+	return normalized
+}
+
+// NormalizeCurrency trims surrounding whitespace and upper-cases a currency code,
+// so " zar" and "ZAR" name the same currency.
+func NormalizeCurrency(s string) string {
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+// IsCurrencyCode reports whether s looks like an ISO 4217 code (three uppercase letters).
+func IsCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
 }
 
 // PaymentResponse holds the result of a transaction.
 type PaymentResponse struct {
-	Status        string // "SUCCESS", "FAILED", "TIMEOUT"
+	Status        string // "SUCCESS", "FAILED", "TIMEOUT", "AUTHORIZED" (Authorize only), or "PARTIAL" (Split only)
 	ReferenceID   string
 	ProviderName  string
 	IsIdempotent  bool
 	Message       string
+	Amount        float64 `json:",omitempty"` // Charged on SUCCESS, in the payment's currency; the most a refund may return
+	Fee           float64 `json:",omitempty"` // Charged by the provider on SUCCESS, in the payment's currency
+	NetAmount     float64 `json:",omitempty"` // Amount less Fee
+	Legs          []SplitLeg `json:",omitempty"` // The sub-payments of a Split payment
+}
+
+// SplitLeg reports one sub-payment of a Split payment.
+type SplitLeg struct {
+	TransactionID string // Derived from the parent's TransactionID
+	Amount        float64
+	ProviderName  string
+	ReferenceID   string
+	Status        string // "SUCCESS", "FAILED", "TIMEOUT", "SKIPPED" (never attempted), "REFUNDED" or "REFUND_FAILED"
+	Message       string `json:",omitempty"`
+}
+
+// RefundRequest asks a provider to reverse (part of) a completed payment.
+type RefundRequest struct {
+	TransactionID string  // The original payment's TransactionID
+	ReferenceID   string  // The provider's reference for the original payment
+	Amount        float64 // Amount to return to the payer
+}
+
+// RefundResponse holds the result of a refund.
+type RefundResponse struct {
+	Status       string // "SUCCESS", "FAILED"
+	RefundID     string
+	ProviderName string
+	Message      string
+}
+
+// StatusAuthorized is the PaymentResponse status of a successful Authorize: the
+// funds are held but not yet moved.
+const StatusAuthorized = "AUTHORIZED"
+
+// CaptureRequest asks a provider to settle a previously authorized hold.
+type CaptureRequest struct {
+	TransactionID   string  // The authorization's TransactionID
+	AuthorizationID string  // The provider's reference for the hold (the Authorize ReferenceID)
+	Amount          float64 // Amount to capture
 }
 
 // PaymentProvider defines the interface for all external payment integrations (Adapter Pattern).
 type PaymentProvider interface {
 	Name() string
 	ProcessPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error)
+	Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error)
+
+	// Authorize places a hold for req.Amount without moving money. On success the
+	// response's Status is StatusAuthorized and its ReferenceID identifies the hold.
+	Authorize(ctx context.Context, req PaymentRequest) (*PaymentResponse, error)
+	// Capture settles a hold placed by Authorize, returning a SUCCESS PaymentResponse.
+	Capture(ctx context.Context, req CaptureRequest) (*PaymentResponse, error)
+
+	// HealthCheck is a cheap probe that never moves money; nil means the provider
+	// looks ready for traffic.
+	HealthCheck(ctx context.Context) error
 }
\ No newline at end of file
@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// valid returns a well-formed ZAR 10.50 payment, changed by edit
+	valid := func(edit func(r *PaymentRequest)) PaymentRequest {
+		r := PaymentRequest{TransactionID: "TXN-1", Amount: 10.50, Currency: "ZAR"}
+		edit(&r)
+		r.NormalizeAmount()
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		req     PaymentRequest
+		wantErr string // Substring of the error; empty means valid
+	}{
+		{"valid", valid(func(r *PaymentRequest) {}), ""},
+		{"valid with every option", valid(func(r *PaymentRequest) {
+			r.Country = "ZM"
+			r.ProviderPreference = []string{"AIRTEL", "MTN"}
+			r.CallbackURL = "https://shop.example.com/hooks"
+			r.Split = true
+		}), ""},
+		{"valid zero-decimal currency", valid(func(r *PaymentRequest) { r.Amount, r.Currency = 5000, "UGX" }), ""},
+		{"valid minor units only", valid(func(r *PaymentRequest) { r.Amount, r.AmountMinor = 0, 1050 }), ""},
+
+		{"missing TransactionID", valid(func(r *PaymentRequest) { r.TransactionID = "" }), "TransactionID is required"},
+		{"zero amount", valid(func(r *PaymentRequest) { r.Amount = 0 }), "greater than zero"},
+		{"negative amount", valid(func(r *PaymentRequest) { r.Amount = -1 }), "greater than zero"},
+		{"missing currency", valid(func(r *PaymentRequest) { r.Currency = "" }), "ISO 4217"},
+		{"lowercase currency", valid(func(r *PaymentRequest) { r.Currency = "zar" }), "ISO 4217"},
+		{"four-letter currency", valid(func(r *PaymentRequest) { r.Currency = "ZARR" }), "ISO 4217"},
+		{"too many decimals", valid(func(r *PaymentRequest) { r.Amount = 10.505 }), "more decimals than ZAR"},
+		{"decimals in a zero-decimal currency", valid(func(r *PaymentRequest) { r.Amount, r.Currency = 10.5, "UGX" }), "more decimals than UGX"},
+		{"lowercase country", valid(func(r *PaymentRequest) { r.Country = "zm" }), "ISO 3166-1"},
+		{"three-letter country", valid(func(r *PaymentRequest) { r.Country = "ZMB" }), "ISO 3166-1"},
+		{"ProviderKey with ProviderPreference", valid(func(r *PaymentRequest) {
+			r.ProviderKey = "MTN"
+			r.ProviderPreference = []string{"AIRTEL"}
+		}), "not both"},
+		{"empty preference", valid(func(r *PaymentRequest) { r.ProviderPreference = []string{"MTN", ""} }), "empty provider names"},
+		{"relative callback", valid(func(r *PaymentRequest) { r.CallbackURL = "/hooks" }), "CallbackURL"},
+		{"non-http callback", valid(func(r *PaymentRequest) { r.CallbackURL = "ftp://shop.example.com/hooks" }), "CallbackURL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate() = nil, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsDisagreeingAmounts(t *testing.T) {
+	r := PaymentRequest{TransactionID: "TXN-1", Amount: 10.50, AmountMinor: 1000, Currency: "ZAR"}
+	if err := r.Validate(); err == nil || !strings.Contains(err.Error(), "disagree") {
+		t.Errorf("Validate() = %v, want Amount and AmountMinor to disagree", err)
+	}
+}
@@ -0,0 +1,256 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Default simulator behaviour: a flaky provider answering in 200-800ms.
+const (
+	DefaultFailureRate = 0.80
+	DefaultMinLatency  = 200 * time.Millisecond
+	DefaultMaxLatency  = 800 * time.Millisecond
+)
+
+// Simulation holds the tunable behaviour of the mock providers. It is embedded
+// in MTNProvider and AirtelProvider, so the fields can be read off either.
+type Simulation struct {
+	FailureRate float64       // Chance (0-1) that a call returns a simulated 500
+	DeclineRate float64       // Chance (0-1) that a payment that didn't fail is declined
+	MinLatency  time.Duration // Shortest simulated network delay
+	MaxLatency  time.Duration // Longest simulated network delay (exclusive)
+
+	name  string          // Overrides the provider's Name(), e.g. for a regional instance
+	magic bool            // Magic amounts script the outcome (see WithMagicAmounts)
+	rand  *simRand        // Drives the simulated latency and failures
+	refs  *referenceCache // Payments already accepted, by DedupKey
+}
+
+// SimulationOption customises a mock provider, e.g. NewMTNProvider(WithFailureRate(0)).
+type SimulationOption func(*Simulation)
+
+// WithFailureRate sets the chance (0-1) of a simulated failure. 0 gives a healthy
+// provider and 1 one that always fails, which is handy for tripping the breaker.
+func WithFailureRate(rate float64) SimulationOption {
+	return func(s *Simulation) {
+		s.FailureRate = rate
+	}
+}
+
+// WithDeclineRate sets the chance (0-1) that a payment is declined (a business
+// error, see ErrDeclined) instead of succeeding. Defaults to 0.
+func WithDeclineRate(rate float64) SimulationOption {
+	return func(s *Simulation) {
+		s.DeclineRate = rate
+	}
+}
+
+// WithLatency sets the range the simulated network delay is drawn from.
+// Pass the same value twice for a fixed delay.
+func WithLatency(min, max time.Duration) SimulationOption {
+	return func(s *Simulation) {
+		s.MinLatency = min
+		s.MaxLatency = max
+	}
+}
+
+// WithName overrides the provider's Name(), so regional instances of the same
+// simulator (e.g. "MTN_MOMO_ZM" and "MTN_MOMO_UG") can be told apart.
+func WithName(name string) SimulationOption {
+	return func(s *Simulation) {
+		s.name = name
+	}
+}
+
+// WithMagicAmounts makes payments for the magic amounts below return a fixed
+// outcome instead of a random one, so a test can drive every branch of the
+// aggregator on purpose. Only enabled in SIMULATOR_MODE.
+func WithMagicAmounts() SimulationOption {
+	return func(s *Simulation) {
+		s.magic = true
+	}
+}
+
+// Magic amounts, matched exactly against PaymentRequest.Amount. Each one mimics the
+// HTTP status a real provider would answer with:
+//
+//	200  success, whatever FailureRate and DeclineRate say
+//	402  declined (ErrDeclined)
+//	403  insufficient funds (ErrInsufficientFunds)
+//	408  no answer at all: the call blocks until its context is done (timeout)
+//	500  provider internal error (ErrProviderInternal), counts towards the breaker
+//	503  provider unavailable, handled like 500
+//
+// Any other amount falls through to the random simulation.
+const (
+	MagicAmountSuccess           = 200
+	MagicAmountDeclined          = 402
+	MagicAmountInsufficientFunds = 403
+	MagicAmountTimeout           = 408
+	MagicAmountInternalError     = 500
+	MagicAmountUnavailable       = 503
+)
+
+// magicOutcome returns the scripted outcome for amount. forced is false when the
+// payment should go through the random simulation; a forced success has a nil
+// error, and any other forced outcome is returned as res, err.
+func (s *Simulation) magicOutcome(ctx context.Context, amount float64, provider string) (forced bool, res *PaymentResponse, err error) {
+	if !s.magic {
+		return false, nil, nil
+	}
+	failed := func(status, message string) *PaymentResponse {
+		return &PaymentResponse{
+			Status:       status,
+			ReferenceID:  "N/A",
+			ProviderName: provider,
+			Message:      message,
+		}
+	}
+
+	switch amount {
+	case MagicAmountSuccess:
+		return true, nil, nil
+	case MagicAmountDeclined, MagicAmountInsufficientFunds:
+		reason := ErrDeclined
+		if amount == MagicAmountInsufficientFunds {
+			reason = ErrInsufficientFunds
+		}
+		res := failed(StatusDeclined, fmt.Sprintf("Payment declined: %s (magic amount %v)", reason, amount))
+		return true, res, fmt.Errorf("provider declined: %w", reason)
+	case MagicAmountTimeout:
+		<-ctx.Done()
+		return true, nil, ctx.Err()
+	case MagicAmountInternalError, MagicAmountUnavailable:
+		res := failed("FAILED", fmt.Sprintf("%s provider error (magic amount %v)", provider, amount))
+		return true, res, fmt.Errorf("%w: %s", ErrProviderInternal, res.Message)
+	}
+	return false, nil, nil
+}
+
+func newSimulation(opts []SimulationOption) Simulation {
+	s := Simulation{
+		FailureRate: DefaultFailureRate,
+		MinLatency:  DefaultMinLatency,
+		MaxLatency:  DefaultMaxLatency,
+		rand:        newSimRand(),
+		refs:        newReferenceCache(),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// nameOr returns the WithName override, or def when there is none.
+func (s *Simulation) nameOr(def string) string {
+	if s.name != "" {
+		return s.name
+	}
+	return def
+}
+
+// HealthCheck simulates a provider health endpoint. It answers after MinLatency
+// (a health check is cheaper than a payment) and fails at the configured
+// FailureRate, so a probe is as likely to fail as a payment.
+func (s *Simulation) HealthCheck(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.MinLatency):
+		// Continue
+	}
+
+	if s.fails() {
+		return fmt.Errorf("%w: health check failed (simulated 500)", ErrProviderInternal)
+	}
+	return nil
+}
+
+// latency returns a delay in [MinLatency, MaxLatency).
+func (s *Simulation) latency() time.Duration {
+	spread := s.MaxLatency - s.MinLatency
+	if spread <= 0 {
+		return s.MinLatency
+	}
+	return s.MinLatency + time.Duration(s.rand.Int64N(int64(spread)))
+}
+
+// fails reports whether this call should return a simulated failure.
+func (s *Simulation) fails() bool {
+	return s.rand.Float64() < s.FailureRate
+}
+
+// decline returns a simulated business error for this payment, or nil to let it
+// through. Declines are split evenly between ErrDeclined and ErrInsufficientFunds.
+func (s *Simulation) decline() error {
+	if s.DeclineRate <= 0 || s.rand.Float64() >= s.DeclineRate {
+		return nil
+	}
+	if s.rand.Float64() < 0.5 {
+		return ErrInsufficientFunds
+	}
+	return ErrDeclined
+}
+
+// simRand is a provider-owned random source for the simulators. Each provider
+// gets its own, so concurrent load on one never contends with the other.
+// *rand.Rand isn't safe for concurrent use, hence the mutex.
+type simRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSimRand() *simRand {
+	seed := uint64(time.Now().UnixNano())
+	return &simRand{r: rand.New(rand.NewPCG(seed, rand.Uint64()))}
+}
+
+// Int64N returns a pseudo-random int64 in [0, n).
+func (s *simRand) Int64N(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Int64N(n)
+}
+
+// Float64 returns a pseudo-random float in [0.0, 1.0).
+func (s *simRand) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Float64()
+}
+
+// maxRememberedReferences bounds the simulator's de-duplication memory. Once full
+// it starts over, which is fine for a mock whose "session" is the process lifetime.
+const maxRememberedReferences = 100_000
+
+// referenceCache models a provider's own idempotency: a payment retried with the
+// same key gets the original ReferenceID back instead of a second charge.
+type referenceCache struct {
+	mu   sync.Mutex
+	refs map[string]string
+}
+
+func newReferenceCache() *referenceCache {
+	return &referenceCache{refs: make(map[string]string)}
+}
+
+// lookup returns the ReferenceID previously stored for key, if any.
+func (c *referenceCache) lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref, ok := c.refs[key]
+	return ref, ok
+}
+
+// store remembers ref as the result of key.
+func (c *referenceCache) store(key, ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.refs) >= maxRememberedReferences {
+		c.refs = make(map[string]string)
+	}
+	c.refs[key] = ref
+}
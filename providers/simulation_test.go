@@ -0,0 +1,172 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// simulators builds each mock provider with opts, for tests that hold for both.
+var simulators = []struct {
+	name string
+	new  func(opts ...SimulationOption) PaymentProvider
+}{
+	{"MTN", func(opts ...SimulationOption) PaymentProvider { return NewMTNProvider(opts...) }},
+	{"AIRTEL", func(opts ...SimulationOption) PaymentProvider { return NewAirtelProvider(opts...) }},
+}
+
+// forEachSimulator runs test once per mock provider.
+func forEachSimulator(t *testing.T, test func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider)) {
+	for _, sim := range simulators {
+		t.Run(sim.name, func(t *testing.T) { test(t, sim.new) })
+	}
+}
+
+func testPayment(id string) PaymentRequest {
+	return PaymentRequest{TransactionID: id, Amount: 10, AmountMinor: 1000, Currency: "ZAR"}
+}
+
+func TestSimulatorSucceedsWithoutFailures(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(0), WithLatency(0, 0))
+		for i := range 20 {
+			res, err := p.ProcessPayment(context.Background(), testPayment(fmt.Sprintf("TXN-%d", i)))
+			if err != nil {
+				t.Fatalf("ProcessPayment: %v", err)
+			}
+			if res.Status != "SUCCESS" || res.ReferenceID == "" || res.ProviderName != p.Name() || res.IsIdempotent {
+				t.Fatalf("ProcessPayment = %+v, want a new SUCCESS from %s", res, p.Name())
+			}
+		}
+	})
+}
+
+func TestSimulatorAlwaysFails(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(1), WithLatency(0, 0))
+		for range 20 {
+			res, err := p.ProcessPayment(context.Background(), testPayment("TXN-1"))
+			if !errors.Is(err, ErrProviderInternal) || IsBusinessError(err) {
+				t.Fatalf("ProcessPayment error = %v, want ErrProviderInternal", err)
+			}
+			if res == nil || res.Status != "FAILED" {
+				t.Fatalf("ProcessPayment = %+v, want a FAILED response", res)
+			}
+		}
+		if err := p.HealthCheck(context.Background()); !errors.Is(err, ErrProviderInternal) {
+			t.Errorf("HealthCheck = %v, want ErrProviderInternal", err)
+		}
+	})
+}
+
+func TestSimulatorAlwaysDeclines(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(0), WithDeclineRate(1), WithLatency(0, 0))
+		res, err := p.ProcessPayment(context.Background(), testPayment("TXN-1"))
+		if !IsBusinessError(err) {
+			t.Fatalf("ProcessPayment error = %v, want a business error", err)
+		}
+		if res == nil || res.Status != StatusDeclined {
+			t.Errorf("ProcessPayment = %+v, want %s", res, StatusDeclined)
+		}
+	})
+}
+
+func TestSimulatorReplaysARetriedPayment(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(0), WithLatency(0, 0))
+		first, err := p.ProcessPayment(context.Background(), testPayment("TXN-1"))
+		if err != nil {
+			t.Fatalf("ProcessPayment: %v", err)
+		}
+		again, err := p.ProcessPayment(context.Background(), testPayment("TXN-1"))
+		if err != nil {
+			t.Fatalf("retried ProcessPayment: %v", err)
+		}
+		if !again.IsIdempotent || again.ReferenceID != first.ReferenceID {
+			t.Errorf("retry = %+v, want the original %s replayed", again, first.ReferenceID)
+		}
+	})
+}
+
+func TestSimulatorLatency(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(0), WithLatency(delay, delay))
+		start := time.Now()
+		if _, err := p.ProcessPayment(context.Background(), testPayment("TXN-1")); err != nil {
+			t.Fatalf("ProcessPayment: %v", err)
+		}
+		if took := time.Since(start); took < delay {
+			t.Errorf("answered in %s, want at least %s", took, delay)
+		}
+	})
+}
+
+func TestSimulatorStopsWhenTheContextEnds(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		p := newProvider(WithFailureRate(0), WithLatency(time.Hour, time.Hour))
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		expiring, stop := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer stop()
+
+		tests := []struct {
+			name string
+			ctx  context.Context
+			want error
+		}{
+			{"cancelled", cancelled, context.Canceled},
+			{"deadline", expiring, context.DeadlineExceeded},
+		}
+		for _, tt := range tests {
+			start := time.Now()
+			res, err := p.ProcessPayment(tt.ctx, testPayment("TXN-"+tt.name))
+			if !errors.Is(err, tt.want) || res != nil {
+				t.Errorf("%s: ProcessPayment = %+v, %v; want nil, %v", tt.name, res, err, tt.want)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("%s: returned after %s, want promptly", tt.name, took)
+			}
+		}
+	})
+}
+
+func TestMagicAmounts(t *testing.T) {
+	forEachSimulator(t, func(t *testing.T, newProvider func(opts ...SimulationOption) PaymentProvider) {
+		// FailureRate 1 shows the magic amounts override the random simulation
+		p := newProvider(WithFailureRate(1), WithLatency(0, 0), WithMagicAmounts())
+
+		tests := []struct {
+			amount   float64
+			wantErr  error // Nil for a success
+			business bool
+		}{
+			{MagicAmountSuccess, nil, false},
+			{MagicAmountDeclined, ErrDeclined, true},
+			{MagicAmountInsufficientFunds, ErrInsufficientFunds, true},
+			{MagicAmountTimeout, context.DeadlineExceeded, false},
+			{MagicAmountInternalError, ErrProviderInternal, false},
+			{MagicAmountUnavailable, ErrProviderInternal, false},
+		}
+		for _, tt := range tests {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			req := PaymentRequest{TransactionID: fmt.Sprintf("TXN-%v", tt.amount), Amount: tt.amount, Currency: "ZAR"}
+			_, err := p.ProcessPayment(ctx, req)
+			cancel()
+
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("amount %v: %v, want success", tt.amount, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("amount %v: %v, want %v", tt.amount, err, tt.wantErr)
+			}
+			if got := IsBusinessError(err); got != tt.business {
+				t.Errorf("amount %v: IsBusinessError = %v, want %v", tt.amount, got, tt.business)
+			}
+		}
+	})
+}
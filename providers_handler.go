@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"sort"
+	"strings"
+	"time"
+)
+
+// providerInfo is one entry in the GET /v1/providers listing.
+type providerInfo struct {
+	Key           string        `json:"key"`
+	Name          string        `json:"name"`
+	Enabled       bool          `json:"enabled"` // False once an operator disables it (see ProviderSwitchHandler)
+	BreakerState  string        `json:"breakerState"`
+	Counts        breakerCounts `json:"counts"`
+	Limits        AmountLimit   `json:"limits"`
+	Fees          FeeSchedule   `json:"fees"`
+	DailyCap      DailyCap      `json:"dailyCap"`
+	UsageToday    *cache.Usage  `json:"usageToday,omitempty"`    // Omitted when usage isn't tracked or can't be read
+	LatencyEWMAMs float64       `json:"latencyEwmaMs,omitempty"` // Moving-average call duration; omitted before the first call
+}
+
+// breakerCounts mirrors gobreaker.Counts for the current breaker interval.
+type breakerCounts struct {
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"totalSuccesses"`
+	TotalFailures        uint32 `json:"totalFailures"`
+	ConsecutiveSuccesses uint32 `json:"consecutiveSuccesses"`
+	ConsecutiveFailures  uint32 `json:"consecutiveFailures"`
+}
+
+// ProvidersHandler lists every registered provider with whether it is enabled, its
+// breaker state and counts, limits, today's usage against its daily cap, and its
+// moving-average latency.
+// GET /v1/providers
+func (a *Aggregator) ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	keys := make([]string, 0, len(a.Providers))
+	for key := range a.Providers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]providerInfo, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, a.describeProvider(r.Context(), key))
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// describeProvider builds the listing entry for the registered provider key.
+func (a *Aggregator) describeProvider(ctx context.Context, key string) providerInfo {
+	info := providerInfo{
+		Key:          key,
+		Name:         a.Providers[key].Name(),
+		Enabled:      !a.providerDisabled(ctx, key),
+		BreakerState: "none",
+		Limits:       a.amountLimit(key),
+		Fees:         a.feeSchedule(key),
+		DailyCap:     a.DailyCaps[key],
+	}
+	if avg, ok := a.Latency.average(key); ok {
+		info.LatencyEWMAMs = float64(avg.Microseconds()) / 1000
+	}
+	if a.Usage != nil {
+		day, _ := a.usageDay(time.Now())
+		if usage, err := a.Usage.GetUsage(ctx, key, day); err == nil {
+			info.UsageToday = &usage
+		} else {
+			slog.WarnContext(ctx, "failed to read provider usage", "provider", key, "error", err)
+		}
+	}
+	if breaker, ok := a.Breakers[key]; ok {
+		counts := breaker.Counts()
+		info.BreakerState = breaker.State().String()
+		info.Counts = breakerCounts{
+			Requests:             counts.Requests,
+			TotalSuccesses:       counts.TotalSuccesses,
+			TotalFailures:        counts.TotalFailures,
+			ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+			ConsecutiveFailures:  counts.ConsecutiveFailures,
+		}
+	}
+	return info
+}
+
+// ProviderSwitchHandler is the operator kill-switch: it takes a provider out of
+// routing (payments fall back to the next provider on their route, or get a 503
+// when none is left) or puts it back, whatever its breaker state. The flag is kept
+// in the store, so it outlives restarts and applies to every instance. Because it
+// affects every tenant, only an admin client's key may use it (see requireAdmin).
+// POST /v1/providers/{key}/disable and POST /v1/providers/{key}/enable -> the
+// provider's listing entry.
+func (a *Aggregator) ProviderSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	key, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/providers/"), "/")
+	if !ok || (action != "disable" && action != "enable") {
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, "Not Found",
+			"Use POST /v1/providers/{key}/disable or /v1/providers/{key}/enable."))
+		return
+	}
+	key = strings.ToUpper(key)
+	if _, ok := a.Providers[key]; !ok {
+		writeError(w, http.StatusNotFound, apiError(codeProviderNotFound, "Provider Not Found",
+			fmt.Sprintf("No provider is registered as %q.", key)))
+		return
+	}
+	if a.Switches == nil {
+		writeError(w, http.StatusNotImplemented, apiError(codeNotImplemented, "Not Implemented",
+			"This deployment has no store for provider kill-switches."))
+		return
+	}
+
+	disabled := action == "disable"
+	if err := a.Switches.SetProviderDisabled(r.Context(), key, disabled); err != nil {
+		slog.ErrorContext(r.Context(), "failed to switch provider", "provider", key, "disabled", disabled, "error", err)
+		writeError(w, http.StatusServiceUnavailable, apiError(codeStoreUnavailable, "Service Unavailable",
+			"The provider switch could not be saved. Please retry."))
+		return
+	}
+	if disabled {
+		slog.WarnContext(r.Context(), "provider disabled by operator", "provider", key)
+	} else {
+		slog.InfoContext(r.Context(), "provider enabled by operator", "provider", key)
+	}
+
+	writeJSON(w, http.StatusOK, a.describeProvider(r.Context(), key))
+}
+
+// providerDisabled reports whether an operator has disabled providerName. If the
+// switch can't be read the provider is treated as enabled: losing a manual override
+// for a moment is better than failing every payment while the store is down.
+func (a *Aggregator) providerDisabled(ctx context.Context, providerName string) bool {
+	if a.Switches == nil {
+		return false
+	}
+	disabled, err := a.Switches.IsProviderDisabled(ctx, providerName)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read provider switch, treating provider as enabled", "provider", providerName, "error", err)
+		return false
+	}
+	return disabled
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProviderSwitchRequiresAnAdminKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "ops": "ops-key"}
+	cfg.Auth.AdminClients = []string{"ops"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"no API key", nil, http.StatusUnauthorized},
+		{"ordinary client", []string{"X-API-Key", "shop-key"}, http.StatusForbidden},
+		{"admin client", []string{"X-API-Key", "ops-key"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "POST", "/v1/providers/MTN/disable", nil, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	// Only the admin's request may have taken effect
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-API-Key", "shop-key"); rec.Code != http.StatusOK || env.airtel.calls.Load() != 1 {
+		t.Errorf("pay with MTN disabled: status %d, AIRTEL calls %d; want 200 via AIRTEL", rec.Code, env.airtel.calls.Load())
+	}
+}
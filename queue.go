@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"payment-gateway-aggregator/requestid"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// deferPayment queues a payment that every provider on its route turned away,
+// when queuing is enabled, and returns a 202 pointing at the transaction's status
+// URL in place of the 503. Any other outcome is returned unchanged, as is the 503
+// if the payment can't be queued.
+func (a *Aggregator) deferPayment(ctx context.Context, req providers.PaymentRequest, out paymentOutcome) paymentOutcome {
+	if a.Queue == nil || !out.Deferrable {
+		return out
+	}
+
+	key := txnKey(ctx, req.TransactionID)
+	queued, err := a.Queue.IsQueued(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to check payment queue", "transaction_id", req.TransactionID, "error", err)
+		return out
+	}
+	if !queued {
+		// Release the claim first so the worker can take the key; if it went the other
+		// way round, the worker could claim it and then lose it to this Delete
+		if err := a.Store.Delete(ctx, key); err != nil && !errors.Is(err, cache.ErrNotInProgress) {
+			slog.WarnContext(ctx, "failed to release key for queued payment", "transaction_id", req.TransactionID, "error", err)
+		}
+		if err := a.Queue.Enqueue(ctx, cache.QueuedPayment{
+			Key:        key,
+			Request:    req,
+			RequestID:  requestid.FromContext(ctx),
+			EnqueuedAt: time.Now(),
+		}); err != nil {
+			slog.ErrorContext(ctx, "failed to queue payment", "transaction_id", req.TransactionID, "error", err)
+			return out
+		}
+		paymentQueueEventsTotal.WithLabelValues("enqueued").Inc()
+		slog.WarnContext(ctx, "no provider available, payment queued", "transaction_id", req.TransactionID)
+	}
+
+	statusURL := transactionStatusURL(req.TransactionID)
+	return paymentOutcome{
+		StatusCode: http.StatusAccepted,
+		Body: transactionStatus{
+			TransactionID: req.TransactionID,
+			Status:        cache.StatusQueued,
+			Message:       "No provider is available right now. The payment has been queued and will be processed once one recovers.",
+			StatusURL:     statusURL,
+		},
+		Header: http.Header{"Location": {statusURL}},
+	}
+}
+
+// queueWorker drains the payment queue whenever a breaker closes, and every
+// interval in case the recovery happened on another instance. Each payment goes
+// through pay(), so idempotency still applies: one completed meanwhile (by a client
+// retry, or because it was queued twice) is replayed rather than charged again.
+type queueWorker struct {
+	a        *Aggregator
+	interval time.Duration
+	timeout  time.Duration // Deadline for each payment, like an HTTP request's
+
+	wake chan struct{}
+
+	// stopCtx is cancelled by Close, interrupting the payment in progress
+	stopCtx context.Context
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+// newQueueWorker starts draining a.Queue; call Close to stop it. It returns nil
+// (and Close is a no-op) when queuing is disabled.
+func newQueueWorker(a *Aggregator, interval, timeout time.Duration) *queueWorker {
+	if a.Queue == nil {
+		return nil
+	}
+	stopCtx, stop := context.WithCancel(context.Background())
+	w := &queueWorker{
+		a:        a,
+		interval: interval,
+		timeout:  timeout,
+		wake:     make(chan struct{}, 1),
+		stopCtx:  stopCtx,
+		stop:     stop,
+		done:     make(chan struct{}),
+	}
+	a.BreakerEvents.Subscribe(func(event BreakerEvent) {
+		if event.To == gobreaker.StateClosed {
+			w.notify()
+		}
+	})
+	go w.run()
+	return w
+}
+
+// notify asks for a drain without blocking (a breaker listener must return quickly).
+func (w *queueWorker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+		// A drain is already pending
+	}
+}
+
+// Close stops the worker and waits for it to exit. A payment interrupted by the
+// shutdown is put back on the queue.
+func (w *queueWorker) Close() {
+	if w == nil {
+		return
+	}
+	w.stop()
+	<-w.done
+}
+
+func (w *queueWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCtx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+		w.drain()
+	}
+}
+
+// drain processes queued payments in order until the queue is empty or one still
+// can't be processed, which goes back to the head to wait for the next recovery.
+func (w *queueWorker) drain() {
+	for w.stopCtx.Err() == nil {
+		p, err := w.a.Queue.Dequeue(w.stopCtx)
+		if err != nil {
+			slog.Error("failed to read payment queue", "error", err)
+			return
+		}
+		if p == nil {
+			return
+		}
+
+		ctx := requestid.NewContext(context.WithValue(w.stopCtx, tenantKey{}, p.Key.Tenant), p.RequestID)
+		ctx, cancel := context.WithTimeout(ctx, w.timeout)
+		out := w.a.pay(ctx, p.Request)
+		cancel()
+		// The payment's deadline has passed (or shutdown cancelled it); the cleanup below still has to run
+		ctx = context.WithoutCancel(ctx)
+
+		if out.StatusCode == http.StatusServiceUnavailable || isInProgress(out) || w.stopCtx.Err() != nil {
+			// Still no provider (or no store, or someone else holds the key): try again later
+			if out.Deferrable {
+				if err := w.a.Store.Delete(ctx, p.Key); err != nil && !errors.Is(err, cache.ErrNotInProgress) {
+					slog.WarnContext(ctx, "failed to release key for queued payment", "transaction_id", p.Request.TransactionID, "error", err)
+				}
+			}
+			if err := w.a.Queue.Requeue(ctx, *p); err != nil {
+				slog.ErrorContext(ctx, "failed to requeue payment, dropping it", "transaction_id", p.Request.TransactionID, "error", err)
+				return
+			}
+			paymentQueueEventsTotal.WithLabelValues("requeued").Inc()
+			slog.InfoContext(ctx, "queued payment still cannot be processed", "transaction_id", p.Request.TransactionID, "status", out.StatusCode)
+			return
+		}
+
+		paymentQueueEventsTotal.WithLabelValues("processed").Inc()
+		slog.InfoContext(ctx, "processed queued payment", "transaction_id", p.Request.TransactionID, "status", out.StatusCode,
+			"queued_ms", time.Since(p.EnqueuedAt).Milliseconds())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"testing"
+)
+
+func TestQueuedPaymentStatusURLIsPolledWithTheSameKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.Queue.Enabled = true
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+	env.tripBreaker(t, "MTN")
+	env.tripBreaker(t, "AIRTEL")
+
+	rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-API-Key", "shop-key")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("pay: status %d, want 202 (body %s)", rec.Code, rec.Body)
+	}
+	var accepted transactionStatus
+	decode(t, rec, &accepted)
+	if accepted.Status != cache.StatusQueued || accepted.StatusURL != "/v1/transactions/TXN-1" || rec.Header().Get("Location") != accepted.StatusURL {
+		t.Fatalf("accepted %+v, Location %q; want QUEUED at /v1/transactions/TXN-1", accepted, rec.Header().Get("Location"))
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"same API key", []string{"X-API-Key", "shop-key"}, http.StatusOK},
+		{"another client's key", []string{"X-API-Key", "other-key"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "GET", accepted.StatusURL, nil, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK {
+				var status transactionStatus
+				decode(t, rec, &status)
+				if status.Status != cache.StatusQueued {
+					t.Errorf("status %q, want %s", status.Status, cache.StatusQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestPaymentIsRejectedWhenQueuingIsDisabled(t *testing.T) {
+	env := newTestEnv(t, testConfig())
+	h := env.handler(t, testConfig())
+	env.tripBreaker(t, "MTN")
+	env.tripBreaker(t, "AIRTEL")
+
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503 (body %s)", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"payment-gateway-aggregator/config"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientBucket is one client's token bucket and when it was last used.
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per client so one noisy caller can't saturate
+// a provider (and trip its breaker) for everyone else.
+type rateLimiter struct {
+	cfg config.RateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// newRateLimiter returns a limiter for cfg, or nil when rate limiting is disabled.
+// A background sweep discards buckets idle for longer than cfg.IdleTTL; call Close to stop it.
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*clientBucket),
+		stop:    make(chan struct{}),
+	}
+	go l.sweep()
+	return l
+}
+
+// Close stops the background sweep.
+func (l *rateLimiter) Close() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() { close(l.stop) })
+}
+
+// bucket returns the limiter for key, creating it with the client's override
+// (or the global rate and burst) on first use.
+func (l *rateLimiter) bucket(key, clientID string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		limit, burst := l.cfg.Rate, l.cfg.Burst
+		if override, ok := l.cfg.Clients[clientID]; ok && clientID != "" {
+			if override.Rate > 0 {
+				limit = override.Rate
+			}
+			if override.Burst > 0 {
+				burst = override.Burst
+			}
+		}
+		b = &clientBucket{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = time.Now()
+	return b.limiter
+}
+
+// minSweepInterval keeps a tiny IdleTTL (config.Load enforces a larger minimum,
+// but a limiter can be built from any config) from panicking NewTicker.
+const minSweepInterval = time.Millisecond
+
+func (l *rateLimiter) sweep() {
+	ticker := time.NewTicker(max(time.Duration(l.cfg.IdleTTL)/2, minSweepInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, b := range l.buckets {
+				if now.Sub(b.lastSeen) > time.Duration(l.cfg.IdleTTL) {
+					delete(l.buckets, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Limit wraps next with the rate limit, keyed by the authenticated client ID
+// (see requireAPIKey) or, for anonymous callers, the remote IP. Over-limit
+// requests get 429 with a Retry-After header. A nil limiter passes everything through.
+func (l *rateLimiter) Limit(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID := clientIDFromContext(r.Context())
+		key := "client:" + clientID
+		if clientID == "" {
+			key = "ip:" + remoteIP(r)
+		}
+
+		reservation := l.bucket(key, clientID).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// Don't consume a token for a request we're turning away
+			reservation.Cancel()
+
+			seconds := retryAfterSeconds(delay)
+			slog.WarnContext(r.Context(), "rate limit exceeded", "client", key, "retry_after_s", seconds)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"error":             "Too Many Requests",
+				"message":           "Rate limit exceeded. Please slow down.",
+				"retryAfterSeconds": seconds,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP returns the host part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway-aggregator/config"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter for cfg, closed when the test ends.
+func newTestLimiter(t *testing.T, cfg config.RateLimitConfig) *rateLimiter {
+	t.Helper()
+	if cfg.IdleTTL == 0 {
+		cfg.IdleTTL = config.Duration(time.Minute)
+	}
+	l := newRateLimiter(cfg)
+	t.Cleanup(l.Close)
+	return l
+}
+
+// limited is a handler behind l that answers 200 to every request it lets through.
+func limited(l *rateLimiter) http.Handler {
+	return l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// send makes one request through h as client (anonymous when empty) from remoteAddr.
+func send(h http.Handler, client, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/pay", nil)
+	req.RemoteAddr = remoteAddr
+	if client != "" {
+		req = req.WithContext(withClientID(req.Context(), client))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitRejectsOverTheBurstWithRetryAfter(t *testing.T) {
+	h := limited(newTestLimiter(t, config.RateLimitConfig{Rate: 0.5, Burst: 2}))
+
+	for i := range 2 {
+		if rec := send(h, "shop", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200 within the burst", i+1, rec.Code)
+		}
+	}
+	rec := send(h, "shop", "10.0.0.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429 past the burst", rec.Code)
+	}
+	// One token every 2s
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want \"2\"", got)
+	}
+	var body struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retryAfterSeconds"`
+	}
+	decode(t, rec, &body)
+	if body.Error != "Too Many Requests" || body.RetryAfterSeconds != 2 {
+		t.Errorf("body %+v, want Too Many Requests retrying after 2s", body)
+	}
+}
+
+func TestRateLimitRefillsOverTime(t *testing.T) {
+	h := limited(newTestLimiter(t, config.RateLimitConfig{Rate: 20, Burst: 1}))
+
+	if rec := send(h, "shop", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	if rec := send(h, "shop", "10.0.0.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("immediate retry: status %d, want 429", rec.Code)
+	}
+	// A rejected request doesn't spend a token, so one refill (50ms) is enough
+	time.Sleep(100 * time.Millisecond)
+	if rec := send(h, "shop", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("after the refill: status %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitIsPerClient(t *testing.T) {
+	h := limited(newTestLimiter(t, config.RateLimitConfig{
+		Rate:    0.001,
+		Burst:   1,
+		Clients: map[string]config.ClientRateLimit{"bulk": {Burst: 3}},
+	}))
+
+	tests := []struct {
+		name       string
+		client     string
+		remoteAddr string
+		want       []int
+	}{
+		{"client", "shop", "10.0.0.1:1234", []int{200, 429}},
+		{"another client from the same IP", "other", "10.0.0.1:1234", []int{200, 429}},
+		{"client with an override", "bulk", "10.0.0.1:1234", []int{200, 200, 200, 429}},
+		{"anonymous", "", "10.0.0.2:1234", []int{200, 429}},
+		{"anonymous from another port", "", "10.0.0.2:5678", []int{429}},
+		{"anonymous from another IP", "", "10.0.0.3:1234", []int{200, 429}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				if rec := send(h, tt.client, tt.remoteAddr); rec.Code != want {
+					t.Fatalf("request %d: status %d, want %d", i+1, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	l := newRateLimiter(config.RateLimitConfig{Rate: 0, Burst: 1})
+	if l != nil {
+		t.Fatal("newRateLimiter with Rate 0 returned a limiter, want nil")
+	}
+	h := limited(l)
+	for range 10 {
+		if rec := send(h, "shop", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200 with limiting disabled", rec.Code)
+		}
+	}
+}
+
+func TestRateLimitDiscardsIdleBuckets(t *testing.T) {
+	l := newTestLimiter(t, config.RateLimitConfig{Rate: 1, Burst: 1, IdleTTL: config.Duration(20 * time.Millisecond)})
+	send(limited(l), "shop", "10.0.0.1:1234")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		n := len(l.buckets)
+		l.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d buckets left, want the idle one discarded", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRateLimitSurvivesATinyIdleTTL(t *testing.T) {
+	// Below NewTicker's minimum once halved; the sweep must not panic
+	l := newTestLimiter(t, config.RateLimitConfig{Rate: 1, Burst: 1, IdleTTL: 1})
+	if rec := send(limited(l), "shop", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
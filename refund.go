@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"strconv"
+)
+
+// refundKeyPrefix namespaces refund idempotency keys so a refund never collides
+// with the payment it reverses.
+const refundKeyPrefix = "refund:"
+
+// refundRequest is the body of POST /v1/refund.
+type refundRequest struct {
+	TransactionID string  // The original payment's TransactionID
+	Amount        float64 // Amount to refund
+}
+
+// RefundHandler reverses a completed payment through the provider that served it.
+// Each payment can be refunded once, for at most the amount charged: the refund
+// holds its own idempotency key.
+// POST /v1/refund -> 200, 404 if the payment isn't COMPLETED, 409 if already refunded
+// (or an earlier refund's outcome is unknown, see settleRefundKey),
+// 422 if Amount exceeds the payment's.
+func (a *Aggregator) RefundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req refundRequest
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, apiError(codeBodyTooLarge,
+				"Request Body Too Large", fmt.Sprintf("Request body must not exceed %d bytes.", tooLarge.Limit)))
+			return
+		}
+		writeError(w, http.StatusBadRequest, apiError(codeInvalidBody, "Invalid Request Body", err.Error()))
+		return
+	}
+	if req.TransactionID == "" || req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, apiError(codeValidationFailed, "Validation Failed",
+			"TransactionID is required and Amount must be greater than zero"))
+		return
+	}
+
+	// Only a completed payment with a stored result (and so a provider reference) can be reversed
+	key := txnKey(ctx, req.TransactionID)
+	status, err := a.Store.GetStatus(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read transaction status", "transaction_id", req.TransactionID, "error", err)
+		writeError(w, http.StatusInternalServerError, apiError(codeStoreUnavailable, "Failed to read transaction status", ""))
+		return
+	}
+	var original *providers.PaymentResponse
+	if status == cache.StatusCompleted {
+		original, err = a.Store.GetResult(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored result", "transaction_id", req.TransactionID, "error", err)
+		}
+	}
+	if original == nil {
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, "Transaction Not Found",
+			fmt.Sprintf("No completed payment %s is available to refund.", req.TransactionID)))
+		return
+	}
+
+	// Results stored before amounts were recorded carry none, and can't be checked
+	if original.Amount > 0 && req.Amount > original.Amount {
+		writeError(w, http.StatusUnprocessableEntity, apiError(codeAmountExceedsPayment, "Amount Exceeds Payment",
+			fmt.Sprintf("Amount %.2f exceeds the %.2f charged for transaction %s.", req.Amount, original.Amount, req.TransactionID)))
+		return
+	}
+
+	providerName, ok := a.providerKeyByName(original.ProviderName)
+	if !ok {
+		writeError(w, http.StatusNotFound, apiError(codeProviderNotFound, fmt.Sprintf("Provider %s not found", original.ProviderName), ""))
+		return
+	}
+
+	// --- REFUND IDEMPOTENCY CHECK ---
+	refundKey := txnKey(ctx, refundKeyPrefix+req.TransactionID)
+	state, err := a.Store.CheckOrSetInProgress(ctx, refundKey)
+	if err != nil {
+		// Unlike payments, refunds fail closed: without the key a double refund can't be ruled out
+		slog.ErrorContext(ctx, "refund idempotency check failed", "transaction_id", req.TransactionID, "error", err)
+		writeError(w, http.StatusServiceUnavailable, apiError(codeStoreUnavailable, "Service Unavailable",
+			"Refunds are temporarily unavailable. Please retry."))
+		return
+	}
+	switch state {
+	case cache.StateInProgress:
+		seconds := a.leaseRetryAfter(ctx, refundKey)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		body := apiError(codeTransactionInProgress, "Refund in progress",
+			fmt.Sprintf("A refund for this transaction is currently being processed. Retry in %d seconds.", seconds))
+		body.RetryAfterSeconds = seconds
+		writeError(w, a.InProgressStatus, body)
+		return
+	case cache.StateCompleted:
+		writeError(w, http.StatusConflict, apiError(codeAlreadyRefunded, "Already Refunded",
+			fmt.Sprintf("Transaction %s has already been refunded.", req.TransactionID)))
+		return
+	case cache.StateFailed:
+		// Held by settleRefundKey until an operator has checked with the provider
+		writeError(w, http.StatusConflict, apiError(codeRefundNeedsReconcile, "Refund Needs Reconciliation",
+			fmt.Sprintf("The outcome of an earlier refund of transaction %s is unknown. It can be retried once it has been reconciled with the provider.", req.TransactionID)))
+		return
+	}
+
+	slog.InfoContext(ctx, "starting refund", "transaction_id", req.TransactionID, "provider", providerName, "amount", req.Amount)
+	res, err := a.callRefund(ctx, providerName, providers.RefundRequest{
+		TransactionID: req.TransactionID,
+		ReferenceID:   original.ReferenceID,
+		Amount:        req.Amount,
+	})
+	if err == nil && res.Status != "SUCCESS" {
+		err = fmt.Errorf("refund %s", res.Status)
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "refund failed", "transaction_id", req.TransactionID, "provider", providerName, "error", err)
+		a.settleRefundKey(ctx, refundKey, req.TransactionID, providerName, res, err)
+
+		var body interface{}
+		if res != nil {
+			body = res
+		}
+		a.writeCallError(w, providerName, err, body)
+		return
+	}
+
+	if err := a.Store.SetCompleted(ctx, refundKey); err != nil {
+		slog.WarnContext(ctx, "failed to mark refund completed", "transaction_id", req.TransactionID, "error", err)
+	}
+	slog.InfoContext(ctx, "refund succeeded", "transaction_id", req.TransactionID, "provider", providerName, "refund_id", res.RefundID)
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// refundRejected reports whether a failed refund call definitely moved no money:
+// the provider answered, or the call never reached it (bulkhead, breaker, too little
+// time left). After a timeout, a cancellation or a transport error the provider may
+// have refunded anyway.
+func refundRejected(res *providers.RefundResponse, err error) bool {
+	switch {
+	case errors.Is(err, errBulkheadFull), isBreakerRejection(err), errors.Is(err, providers.ErrNoTimeLeft):
+		return true
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return false
+	}
+	return res != nil || providers.IsBusinessError(err)
+}
+
+// settleRefundKey deals with the idempotency key of a failed refund. A rejected one
+// (see refundRejected) gives the key back so the refund can be retried. Any other is
+// held for reconciliation: the key is kept as FAILED for as long as a completed
+// refund's, so a retry gets a 409 rather than a second refund, until an operator has
+// checked with the provider and cleared it (DELETE /v1/transactions/refund:{id}).
+func (a *Aggregator) settleRefundKey(ctx context.Context, refundKey cache.TxnKey, transactionID, providerName string, res *providers.RefundResponse, err error) {
+	ctx = context.WithoutCancel(ctx)
+	if refundRejected(res, err) {
+		if delErr := a.Store.Delete(ctx, refundKey); delErr != nil {
+			slog.WarnContext(ctx, "failed to release refund key", "transaction_id", transactionID, "error", delErr)
+		}
+		return
+	}
+
+	slog.ErrorContext(ctx, "refund outcome unknown, holding it for reconciliation", "transaction_id", transactionID, "provider", providerName, "error", err)
+	held := &providers.PaymentResponse{
+		Status:       "UNKNOWN",
+		ReferenceID:  "N/A",
+		ProviderName: providerName,
+		Message:      fmt.Sprintf("Refund outcome unknown: %v", err),
+	}
+	if setErr := a.Store.SetFailed(cache.WithTerminalFailure(ctx), refundKey, held); setErr != nil {
+		// The IN_PROGRESS lease still holds off retries until it expires
+		slog.WarnContext(ctx, "failed to hold refund key", "transaction_id", transactionID, "error", setErr)
+	}
+}
+
+// callRefund runs a refund through the provider's bulkhead, timeout and circuit
+// breaker, so refunds and payments share the same view of the provider's health.
+func (a *Aggregator) callRefund(parent context.Context, providerName string, req providers.RefundRequest) (*providers.RefundResponse, error) {
+	provider := a.Providers[providerName]
+	result, err := a.callGuarded(parent, providerName, func(ctx context.Context) (interface{}, error) {
+		return provider.Refund(ctx, req)
+	})
+	res, _ := result.(*providers.RefundResponse)
+	return res, err
+}
+
+// callGuarded runs call under the provider's bulkhead, timeout and circuit breaker.
+// It backs the calls other than ProcessPayment (refunds, authorizations, captures).
+func (a *Aggregator) callGuarded(parent context.Context, providerName string, call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	slots := a.Bulkheads[providerName]
+	if !slots.tryAcquire() {
+		return nil, errBulkheadFull
+	}
+	defer slots.release()
+
+	ctx, cancel := context.WithTimeout(parent, a.providerTimeout(providerName))
+	defer cancel()
+
+	if breaker, ok := a.Breakers[providerName]; ok {
+		return breaker.Execute(func() (interface{}, error) { return call(ctx) })
+	}
+	return call(ctx)
+}
+
+// writeCallError maps a failed callGuarded call to a response: 503 (with Retry-After)
+// when the bulkhead or breaker rejected it, 504 on timeout, 402 when the provider
+// declined (a business error), otherwise 502. The last two carry the provider's
+// structured response (body) if there is one. Pass a nil interface, not a typed nil
+// pointer, when there isn't.
+func (a *Aggregator) writeCallError(w http.ResponseWriter, providerName string, err error, body interface{}) {
+	switch {
+	case errors.Is(err, errBulkheadFull):
+		w.Header().Set("Retry-After", "1")
+		e := apiError(codeProviderAtCapacity, "Service Unavailable", fmt.Sprintf("Provider %s is at capacity. Please retry shortly.", providerName))
+		e.RetryAfterSeconds = 1
+		writeError(w, http.StatusServiceUnavailable, e)
+	case isBreakerRejection(err):
+		seconds := retryAfterSeconds(openings.retryAfter(a.Breakers[providerName].Name()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		e := apiError(codeProviderUnavailable, "Service Unavailable",
+			fmt.Sprintf("Provider %s is currently experiencing high failure rates and has been temporarily taken offline.", providerName))
+		e.RetryAfterSeconds = seconds
+		writeError(w, http.StatusServiceUnavailable, e)
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		writeError(w, http.StatusGatewayTimeout, apiError(codeProviderTimeout, "Gateway Timeout",
+			fmt.Sprintf("Provider %s did not respond within %s.", providerName, a.providerTimeout(providerName))))
+	case providers.IsBusinessError(err) && body != nil:
+		writeJSON(w, http.StatusPaymentRequired, body)
+	case providers.IsBusinessError(err):
+		writeError(w, http.StatusPaymentRequired, apiError(codePaymentDeclined, "Payment Declined", err.Error()))
+	case body != nil:
+		writeJSON(w, http.StatusBadGateway, body)
+	default:
+		writeError(w, http.StatusBadGateway, apiError(codeProviderError, fmt.Sprintf("Processing error: %v", err), ""))
+	}
+}
+
+// providerKeyByName maps a provider's display name (as stored on a PaymentResponse,
+// e.g. "MTN_MOMO") back to its key in Providers (e.g. "MTN").
+func (a *Aggregator) providerKeyByName(name string) (string, bool) {
+	for key, p := range a.Providers {
+		if p.Name() == name {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"payment-gateway-aggregator/providers"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRefund(t *testing.T) {
+	env := newTestEnv(t, testConfig())
+	h := env.handler(t, testConfig())
+
+	for _, id := range []string{"TXN-1", "TXN-2"} {
+		if rec := do(t, h, "POST", "/v1/pay", payment(id, 10), "X-Tenant-ID", "shop"); rec.Code != http.StatusOK {
+			t.Fatalf("pay %s: status %d, body %s", id, rec.Code, rec.Body)
+		}
+	}
+
+	steps := []struct {
+		name string
+		body refundRequest
+		want int
+	}{
+		{"unknown payment", refundRequest{TransactionID: "TXN-404", Amount: 5}, http.StatusNotFound},
+		{"more than was charged", refundRequest{TransactionID: "TXN-1", Amount: 10.01}, http.StatusUnprocessableEntity},
+		{"the full amount", refundRequest{TransactionID: "TXN-1", Amount: 10}, http.StatusOK},
+		{"again", refundRequest{TransactionID: "TXN-1", Amount: 10}, http.StatusConflict},
+		{"part of the amount", refundRequest{TransactionID: "TXN-2", Amount: 2.5}, http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := do(t, h, "POST", "/v1/refund", step.body, "X-Tenant-ID", "shop")
+		if rec.Code != step.want {
+			t.Fatalf("%s: status %d, want %d (body %s)", step.name, rec.Code, step.want, rec.Body)
+		}
+	}
+
+	// Payments are per tenant, and so are refunds
+	if rec := do(t, h, "POST", "/v1/refund", refundRequest{TransactionID: "TXN-2", Amount: 1}, "X-Tenant-ID", "other"); rec.Code != http.StatusNotFound {
+		t.Errorf("another tenant's payment: status %d, want 404", rec.Code)
+	}
+}
+
+func TestFailedRefundReleasesItsKeyOnlyWhenRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		res       *providers.RefundResponse
+		err       error
+		wantRetry int // Status of a retry straight after the failure
+	}{
+		{"rejected by the provider", &providers.RefundResponse{Status: "FAILED"}, providers.ErrRejected, http.StatusOK},
+		{"structured failure", &providers.RefundResponse{Status: "FAILED"}, nil, http.StatusOK},
+		{"timed out", nil, context.DeadlineExceeded, http.StatusConflict},
+		{"transport error", nil, errors.New("connection reset by peer"), http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			env := newTestEnv(t, cfg)
+			var failed atomic.Bool
+			env.mtn.refund = func(ctx context.Context, req providers.RefundRequest) (*providers.RefundResponse, error) {
+				if failed.CompareAndSwap(false, true) {
+					return tt.res, tt.err
+				}
+				return &providers.RefundResponse{Status: "SUCCESS", RefundID: "RF-" + req.TransactionID}, nil
+			}
+			h := env.handler(t, cfg)
+			if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-Tenant-ID", "shop"); rec.Code != http.StatusOK {
+				t.Fatalf("pay: status %d, body %s", rec.Code, rec.Body)
+			}
+
+			refund := refundRequest{TransactionID: "TXN-1", Amount: 10}
+			if rec := do(t, h, "POST", "/v1/refund", refund, "X-Tenant-ID", "shop"); rec.Code == http.StatusOK {
+				t.Fatalf("failed refund: status 200, want an error")
+			}
+			rec := do(t, h, "POST", "/v1/refund", refund, "X-Tenant-ID", "shop")
+			if rec.Code != tt.wantRetry {
+				t.Fatalf("retry: status %d, want %d (body %s)", rec.Code, tt.wantRetry, rec.Body)
+			}
+			if tt.wantRetry == http.StatusOK {
+				return
+			}
+
+			// Once reconciled, an operator clears the key and the refund can go ahead
+			if rec := do(t, h, "DELETE", "/v1/transactions/refund:TXN-1", nil, "X-Tenant-ID", "shop"); rec.Code != http.StatusNoContent {
+				t.Fatalf("clear: status %d, want 204 (body %s)", rec.Code, rec.Body)
+			}
+			if rec := do(t, h, "POST", "/v1/refund", refund, "X-Tenant-ID", "shop"); rec.Code != http.StatusOK {
+				t.Errorf("retry after clearing: status %d, want 200 (body %s)", rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"slices"
+	"strings"
+	"time"
+)
+
+// providerFactory builds one provider instance from its config entry. key is the
+// instance's key in config.Providers, e.g. "MTN_ZM"; cfg carries the settings
+// shared by every instance (SimulatorMode, ProviderMinRemaining).
+type providerFactory func(key string, pc config.ProviderConfig, cfg config.Config) (providers.PaymentProvider, error)
+
+// providerTypes maps a ProviderConfig.Type to the factory that builds it. Any
+// number of instances of these types can be declared in config without code changes.
+var providerTypes = map[string]providerFactory{
+	"MTN":    newMTNProvider,
+	"AIRTEL": newAirtelProvider,
+	"HTTP":   newHTTPProvider,
+}
+
+// newProviders builds every provider declared in cfg.Providers (the built-in "MTN"
+// and "AIRTEL" entries included), keyed like the config.
+func newProviders(cfg config.Config) (map[string]providers.PaymentProvider, error) {
+	if cfg.SimulatorMode {
+		slog.Warn("SIMULATOR_MODE enabled: magic payment amounts script simulated provider outcomes")
+	}
+
+	registered := make(map[string]providers.PaymentProvider, len(cfg.Providers))
+	for key, pc := range cfg.Providers {
+		p, err := newProvider(key, pc, cfg)
+		if err != nil {
+			return nil, err
+		}
+		registered[key] = p
+	}
+	return registered, nil
+}
+
+// newProvider builds the instance keyed key with the factory for its type. An
+// unknown type is an error so a typo fails startup instead of dropping a provider.
+func newProvider(key string, pc config.ProviderConfig, cfg config.Config) (providers.PaymentProvider, error) {
+	kind := providerType(key, pc)
+	factory, ok := providerTypes[kind]
+	if !ok {
+		known := slices.Sorted(maps.Keys(providerTypes))
+		return nil, fmt.Errorf("provider %s: unknown type %q (want one of %s)", key, kind, strings.Join(known, ", "))
+	}
+	return factory(key, pc, cfg)
+}
+
+// providerType resolves an instance's type: its Type if set, else HTTP when it has
+// a BaseURL, else the part of the key before "_" ("MTN_ZM" -> "MTN").
+func providerType(key string, pc config.ProviderConfig) string {
+	switch {
+	case pc.Type != "":
+		return strings.ToUpper(pc.Type)
+	case pc.BaseURL != "":
+		return "HTTP"
+	}
+	kind, _, _ := strings.Cut(key, "_")
+	return strings.ToUpper(kind)
+}
+
+// regionSuffix returns the part of the key after "_" ("MTN_ZM" -> "ZM"), or "".
+func regionSuffix(key string) string {
+	_, region, _ := strings.Cut(key, "_")
+	return region
+}
+
+// simulationOptions returns the options every simulator instance starts from.
+func simulationOptions(cfg config.Config) []providers.SimulationOption {
+	var opts []providers.SimulationOption
+	if cfg.SimulatorMode {
+		opts = append(opts, providers.WithMagicAmounts())
+	}
+	return opts
+}
+
+func newMTNProvider(key string, _ config.ProviderConfig, cfg config.Config) (providers.PaymentProvider, error) {
+	opts := simulationOptions(cfg)
+	if region := regionSuffix(key); region != "" {
+		opts = append(opts, providers.WithName("MTN_MOMO_"+region))
+	}
+	return providers.NewMTNProvider(opts...), nil
+}
+
+func newAirtelProvider(key string, _ config.ProviderConfig, cfg config.Config) (providers.PaymentProvider, error) {
+	opts := simulationOptions(cfg)
+	if region := regionSuffix(key); region != "" {
+		opts = append(opts, providers.WithName("AIRTEL_MONEY_"+region))
+	}
+	return providers.NewAirtelProvider(opts...), nil
+}
+
+func newHTTPProvider(key string, pc config.ProviderConfig, cfg config.Config) (providers.PaymentProvider, error) {
+	if pc.BaseURL == "" {
+		return nil, fmt.Errorf("provider %s: type HTTP needs a baseURL", key)
+	}
+	return providers.NewHTTPProvider(key, pc.BaseURL, nil, time.Duration(cfg.ProviderMinRemaining)), nil
+}
@@ -0,0 +1,21 @@
+// Package requestid carries a per-request correlation ID through context.Context
+// so the HTTP layer, the idempotency store, and providers can all log it.
+package requestid
+
+import "context"
+
+// Header is the HTTP header used to receive and echo the request ID.
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// maxPooledBuffer keeps an unusually large response (a long audit log, a big batch)
+// from pinning its buffer in the pool for good.
+const maxPooledBuffer = 64 << 10 // 64 KiB
+
+// responseBuffers recycles the buffers responses are encoded into, so the hot path
+// doesn't leave a fresh one behind for the GC on every request.
+var responseBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// writeJSON encodes v into a pooled buffer, then sends it with status in a single
+// write. Encoding first means a value that can't be encoded becomes a clean 500
+// rather than a status line followed by half a body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	buf := responseBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			responseBuffers.Put(buf)
+		}
+	}()
+
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		slog.Error("failed to encode response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// messageResponse is the body of the simple errors outside the payment endpoints
+// (which use ErrorResponse).
+type messageResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// transactionStatus reports where a transaction stands, e.g. from
+// GET /v1/transactions/{id} or when a payment is accepted for later processing.
+type transactionStatus struct {
+	TransactionID string `json:"transactionID"`
+	Status        string `json:"status"`
+	Message       string `json:"message,omitempty"`
+	StatusURL     string `json:"statusURL,omitempty"`
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"payment-gateway-aggregator/providers"
+	"time"
+)
+
+// RetryPolicy controls how many times a failed provider call is retried
+// before the final outcome is reported to the circuit breaker.
+type RetryPolicy struct {
+	MaxRetries int           // Extra attempts after the first call (0 disables retries)
+	BaseDelay  time.Duration // Backoff before the first retry; doubles on each attempt
+	MaxDelay   time.Duration // Longest backoff (0 for no cap)
+}
+
+// processWithRetry calls provider.ProcessPayment, retrying transient failures (but not
+// business errors such as declines) with
+// exponential backoff and jitter. It never waits past the context deadline: when the
+// next backoff would outlast it, the last failure is returned straight away. Only
+// the last attempt's result is returned, so the breaker sees a single outcome,
+// along with the number of attempts made.
+func processWithRetry(ctx context.Context, provider providers.PaymentProvider, req providers.PaymentRequest, policy RetryPolicy) (*providers.PaymentResponse, int, error) {
+	var (
+		res *providers.PaymentResponse
+		err error
+	)
+
+	for attempt := 0; ; attempt++ {
+		res, err = provider.ProcessPayment(ctx, req)
+		if err == nil {
+			return res, attempt + 1, nil
+		}
+
+		// A cancelled or expired context will fail every further attempt too
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return res, attempt + 1, err
+		}
+
+		// A decline is the provider's final answer, not a transient failure
+		if providers.IsBusinessError(err) {
+			return res, attempt + 1, err
+		}
+
+		if attempt >= policy.MaxRetries {
+			return res, attempt + 1, err
+		}
+
+		delay := backoff(policy.BaseDelay, policy.MaxDelay, attempt)
+		if deadline, ok := ctx.Deadline(); ok && delay >= time.Until(deadline) {
+			// The retry couldn't even start before the deadline; don't hold the caller for nothing
+			slog.WarnContext(ctx, "provider attempt failed, no time left to retry",
+				"transaction_id", req.TransactionID,
+				"provider", provider.Name(),
+				"attempt", attempt+1,
+				"error", err,
+			)
+			return res, attempt + 1, err
+		}
+		slog.WarnContext(ctx, "provider attempt failed, retrying",
+			"transaction_id", req.TransactionID,
+			"provider", provider.Name(),
+			"attempt", attempt+1,
+			"retry_in_ms", delay.Milliseconds(),
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return res, attempt + 1, err
+		case <-time.After(delay):
+			// Retry
+		}
+	}
+}
+
+// backoff returns base * 2^attempt, capped at maxDelay (0 for no cap), with "equal
+// jitter": a random value in [d/2, d]. The jitter keeps many clients from retrying
+// against a struggling provider in lockstep.
+func backoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	if maxDelay <= 0 {
+		maxDelay = math.MaxInt64
+	}
+	// Double only while it stays under the cap, so a large attempt can't overflow
+	d := maxDelay
+	if attempt = max(attempt, 0); attempt < 63 && base <= maxDelay>>attempt {
+		d = base << attempt
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
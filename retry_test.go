@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"payment-gateway-aggregator/providers"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// failingFor returns a process func that fails with err for the first n calls and then succeeds.
+func failingFor(p *stubProvider, n int32, err error) func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+	return func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+		if p.calls.Load() <= n {
+			return &providers.PaymentResponse{Status: "FAILED", ReferenceID: "N/A", ProviderName: p.name}, err
+		}
+		return &providers.PaymentResponse{Status: "SUCCESS", ReferenceID: "REF-" + req.TransactionID, ProviderName: p.name}, nil
+	}
+}
+
+func TestProcessWithRetryAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}
+
+	tests := []struct {
+		name         string
+		failures     int32 // Calls that fail before one succeeds
+		err          error
+		wantAttempts int
+		wantErr      error // Nil for a success
+	}{
+		{"first call succeeds", 0, providers.ErrProviderInternal, 1, nil},
+		{"succeeds on a retry", 2, providers.ErrProviderInternal, 3, nil},
+		{"retries exhausted", 5, providers.ErrProviderInternal, 3, providers.ErrProviderInternal},
+		{"a decline is final", 5, providers.ErrDeclined, 1, providers.ErrDeclined},
+		{"a timeout is final", 5, context.DeadlineExceeded, 1, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newStubProvider("MTN_MOMO")
+			p.process = failingFor(p, tt.failures, tt.err)
+
+			_, attempts, err := processWithRetry(context.Background(), p, payment("TXN-1", 10), policy)
+			if attempts != tt.wantAttempts || int(p.calls.Load()) != tt.wantAttempts {
+				t.Errorf("attempts %d (provider called %d times), want %d", attempts, p.calls.Load(), tt.wantAttempts)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("error %v, want success", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessWithRetryStopsBeforeTheDeadline(t *testing.T) {
+	p := newStubProvider("MTN_MOMO")
+	p.err = providers.ErrProviderInternal
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The first backoff alone outlasts the deadline, so there is no retry
+	_, attempts, _ := processWithRetry(ctx, p, payment("TXN-1", 10), RetryPolicy{MaxRetries: 3, BaseDelay: time.Second})
+	if attempts != 1 {
+		t.Errorf("attempts %d, want 1", attempts)
+	}
+}
+
+func TestOpenBreakerIsNotRetried(t *testing.T) {
+	cfg := testConfig()
+	cfg.Retry.MaxRetries = 2
+	cfg.Retry.BaseDelay = 0
+	env := newTestEnv(t, cfg)
+	env.mtn.err = providers.ErrProviderInternal
+
+	// Fail until the breaker trips
+	for i := 0; env.a.Breakers["MTN"].State() != gobreaker.StateOpen; i++ {
+		if i == 10 {
+			t.Fatal("breaker never opened")
+		}
+		env.a.callProvider(context.Background(), "MTN", payment("TXN-1", 10))
+	}
+
+	before := env.mtn.calls.Load()
+	_, attempts, err := env.a.callProvider(context.Background(), "MTN", payment("TXN-2", 10))
+	if !isBreakerRejection(err) {
+		t.Fatalf("error %v, want the breaker's rejection", err)
+	}
+	if calls := env.mtn.calls.Load() - before; attempts != 0 || calls != 0 {
+		t.Errorf("%d attempts, provider called %d times; want neither", attempts, calls)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     time.Duration
+		maxDelay time.Duration
+		attempt  int
+		min, max time.Duration // Bounds of the jittered delay
+	}{
+		{"first retry", 100 * time.Millisecond, 2 * time.Second, 0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{"doubled", 100 * time.Millisecond, 2 * time.Second, 2, 200 * time.Millisecond, 400 * time.Millisecond},
+		{"past the cap", 100 * time.Millisecond, 2 * time.Second, 10, time.Second, 2 * time.Second},
+		{"shift past 64 bits", 100 * time.Millisecond, 2 * time.Second, 200, time.Second, 2 * time.Second},
+		{"overflow without a cap", time.Second, 0, 40, math.MaxInt64 / 2, math.MaxInt64},
+		{"negative attempt", 100 * time.Millisecond, 2 * time.Second, -1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{"no base", 0, 2 * time.Second, 5, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if d := backoff(tt.base, tt.maxDelay, tt.attempt); d < tt.min || d > tt.max {
+					t.Fatalf("backoff = %s, want within [%s, %s]", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"payment-gateway-aggregator/providers"
+	"strings"
+	"time"
+)
+
+// splitKeyPrefix namespaces the TransactionIDs of split legs ("split:TXN-1:2" is the
+// second leg of TXN-1), so a leg never collides with a payment a client sent.
+const splitKeyPrefix = "split:"
+
+// splitProviderName is the ProviderName reported on a split payment's own response.
+const splitProviderName = "SPLIT"
+
+// splitPartial is a split payment's Status when some of its legs could not be
+// refunded after another leg failed.
+const splitPartial = "PARTIAL"
+
+// Leg statuses set by the aggregator, next to the provider's own SUCCESS/FAILED/TIMEOUT.
+const (
+	legSkipped      = "SKIPPED"
+	legRefunded     = "REFUNDED"
+	legRefundFailed = "REFUND_FAILED"
+)
+
+// plannedLeg is one sub-payment of a split payment, before it runs.
+type plannedLeg struct {
+	provider string
+	amount   int64 // In the currency's minor unit
+}
+
+// splitLegID derives the TransactionID of leg n (1-based) of parent.
+func splitLegID(parent string, n int) string {
+	return fmt.Sprintf("%s%s:%d", splitKeyPrefix, parent, n)
+}
+
+// isSplitLeg reports whether transactionID belongs to a leg of a split payment.
+func isSplitLeg(transactionID string) bool {
+	return strings.HasPrefix(transactionID, splitKeyPrefix)
+}
+
+// processSplitPayment runs a payment sent with Split as up to SplitMaxLegs
+// sub-payments, each within one provider's amount limit. The legs run one after
+// another through processPayment, so each has its own idempotency key, fallback
+// and audit record. If a leg fails, the legs that succeeded are refunded.
+//
+// The parent holds an idempotency key too. It is COMPLETED once every leg
+// succeeded, or once legs had to be refunded (a retry then replays that failure
+// rather than charging again); it is released when nothing was charged.
+func (a *Aggregator) processSplitPayment(ctx context.Context, req providers.PaymentRequest) paymentOutcome {
+	start := time.Now()
+
+	if out, ok := a.checkPayment(&req); !ok {
+		return out
+	}
+	route, out, ok := a.splitRoute(req)
+	if !ok {
+		return out
+	}
+	plan, err := a.planSplit(req, route)
+	if err != nil {
+		return paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: apiError(codeAmountOutOfRange, "Amount Out Of Range", err.Error())}
+	}
+
+	// --- IDEMPOTENCY CHECK ---
+	// Unlike single payments, splits always fail closed: compensation relies on the claim
+	key := txnKey(ctx, req.TransactionID)
+	matched, err := a.Store.MatchFingerprint(ctx, key, req.Fingerprint())
+	if err == nil && !matched {
+		slog.WarnContext(ctx, "split payment rejected: parameters differ from the original request", "transaction_id", req.TransactionID)
+		return paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: apiError(codeIdempotencyKeyReused,
+			"Idempotency Key Reused", "idempotency key reused with different parameters")}
+	}
+	var state cache.TxnState
+	if err == nil {
+		state, err = a.Store.CheckOrSetInProgressWithInfo(ctx, key, cache.InProgressInfo{
+			Provider: splitProviderName,
+			Amount:   req.Amount,
+			Currency: req.Currency,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "idempotency store unavailable, rejecting split payment", "transaction_id", req.TransactionID, "error", err)
+		return paymentOutcome{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       apiError(codeStoreUnavailable, "Service Unavailable", "Payments are temporarily unavailable. Please retry."),
+			Header:     http.Header{"Retry-After": {"1"}},
+		}
+	}
+	switch state {
+	case cache.StateInProgress:
+		a.reportReplay(ctx, req.TransactionID, splitProviderName, replayInProgress)
+		return a.inProgressOutcome(ctx, key)
+	case cache.StateCompleted:
+		stored, err := a.Store.GetResult(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load stored result", "transaction_id", req.TransactionID, "error", err)
+		}
+		if stored != nil {
+			a.reportReplay(ctx, req.TransactionID, splitProviderName, replayServed)
+			stored.IsIdempotent = true
+			return paymentOutcome{StatusCode: splitStatusCode(stored), Body: stored, Header: idempotencyStatus(idempotencyStatusReplayed)}
+		}
+		a.reportReplay(ctx, req.TransactionID, splitProviderName, replayConflict)
+		return paymentOutcome{StatusCode: http.StatusConflict, Body: apiError(codeDuplicateTransaction,
+			"Duplicate transaction ID detected", "This transaction ID has already been successfully completed.")}
+	case cache.StateCancelled:
+		return cancelledOutcome()
+	}
+	// --- IDEMPOTENCY CHECK END ---
+
+	slog.InfoContext(ctx, "starting split payment", "transaction_id", req.TransactionID, "legs", len(plan))
+	stopLease := a.keepLease(ctx, key, req.TransactionID)
+	res, failed := a.runSplitLegs(ctx, req, route, plan)
+	stopLease()
+
+	succeeded := 0
+	for _, leg := range res.Legs {
+		if leg.Status == "SUCCESS" {
+			succeeded++
+		}
+	}
+
+	if failed != nil && succeeded == 0 {
+		// Nothing was charged, so give the key back for the client's retry
+		if err := a.Store.Delete(ctx, key); err != nil && !errors.Is(err, cache.ErrNotInProgress) {
+			slog.WarnContext(ctx, "failed to release split payment key", "transaction_id", req.TransactionID, "error", err)
+		}
+		slog.WarnContext(ctx, "split payment failed", "transaction_id", req.TransactionID, "duration_ms", time.Since(start).Milliseconds())
+		return paymentOutcome{StatusCode: failed.StatusCode, Body: res, Header: failed.Header}
+	}
+	if failed != nil {
+		a.compensateSplit(ctx, res)
+	}
+
+	// Store the result before flipping to COMPLETED so a replay always finds it
+	if err := a.Store.SetResult(ctx, key, res); err != nil {
+		slog.WarnContext(ctx, "failed to store result", "transaction_id", req.TransactionID, "error", err)
+	}
+	if err := a.Store.SetCompleted(ctx, key); err != nil {
+		slog.WarnContext(ctx, "failed to mark transaction completed", "transaction_id", req.TransactionID, "error", err)
+	}
+	a.notifyCompletion(ctx, req, res)
+
+	slog.InfoContext(ctx, "split payment finished", "transaction_id", req.TransactionID, "status", res.Status,
+		"legs", len(res.Legs), "duration_ms", time.Since(start).Milliseconds())
+	return paymentOutcome{StatusCode: splitStatusCode(res), Body: res, Header: idempotencyStatus(idempotencyStatusNew)}
+}
+
+// splitRoute returns the providers a split payment may use, in order: the
+// request's ProviderPreference, its ProviderKey alone, or its currency's route.
+func (a *Aggregator) splitRoute(req providers.PaymentRequest) ([]string, paymentOutcome, bool) {
+	switch {
+	case len(req.ProviderPreference) > 0:
+		route, err := a.preferredRoute(req.ProviderPreference, req.Country)
+		if err != nil {
+			return nil, paymentOutcome{StatusCode: http.StatusBadRequest, Body: apiError(codeValidationFailed, "Validation Failed", err.Error())}, false
+		}
+		return route, paymentOutcome{}, true
+	case req.ProviderKey != "":
+		providerName := a.regional(providerNameFromKey(req.ProviderKey), req.Country)
+		if _, ok := a.Providers[providerName]; !ok {
+			return nil, paymentOutcome{StatusCode: http.StatusNotFound, Body: apiError(codeProviderNotFound, fmt.Sprintf("Provider %s not found", providerName), "")}, false
+		}
+		return []string{providerName}, paymentOutcome{}, true
+	}
+	providerName, ok := a.CurrencyRoutes[req.Currency]
+	if !ok {
+		body := apiError(codeUnsupportedCurrency, "Unsupported Currency", fmt.Sprintf("No provider supports currency %s.", req.Currency))
+		body.SupportedCurrencies = a.supportedCurrencies()
+		return nil, paymentOutcome{StatusCode: http.StatusUnprocessableEntity, Body: body}, false
+	}
+	return a.routeFor(a.regional(providerName, req.Country)), paymentOutcome{}, true
+}
+
+// planSplit divides req's amount across route, visiting the providers in turn and
+// giving each leg as much as that provider's amount limit allows. The plan depends
+// only on the amount and the configured limits, so a retry plans the same legs.
+func (a *Aggregator) planSplit(req providers.PaymentRequest, route []string) ([]plannedLeg, error) {
+	var plan []plannedLeg
+	remaining := req.AmountMinor
+	for remaining > 0 && len(plan) < a.SplitMaxLegs {
+		progressed := false
+		for _, candidate := range route {
+			if remaining == 0 || len(plan) == a.SplitMaxLegs {
+				break
+			}
+			if _, ok := a.Providers[candidate]; !ok {
+				continue
+			}
+			limit := a.amountLimit(candidate)
+			amount := remaining
+			if limit.Max > 0 {
+				amount = min(amount, floorMinorUnits(limit.Max, req.Currency))
+			}
+			if amount <= 0 || !limit.Allows(providers.FromMinorUnits(amount, req.Currency)) {
+				continue
+			}
+			plan = append(plan, plannedLeg{provider: candidate, amount: amount})
+			remaining -= amount
+			progressed = true
+		}
+		if !progressed {
+			break
+		}
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("%s %.2f can't be split into at most %d payments within the providers' amount limits",
+			req.Currency, req.Amount, a.SplitMaxLegs)
+	}
+	return plan, nil
+}
+
+// floorMinorUnits converts amount to the currency's minor unit, rounding down.
+func floorMinorUnits(amount float64, currency string) int64 {
+	return int64(math.Floor(amount*math.Pow10(providers.CurrencyExponent(currency)) + 1e-9))
+}
+
+// runSplitLegs processes the planned legs in order and stops at the first one that
+// fails. It returns the parent's response, with a SplitLeg per planned leg, and
+// the failed leg's outcome (nil if every leg succeeded).
+func (a *Aggregator) runSplitLegs(ctx context.Context, req providers.PaymentRequest, route []string, plan []plannedLeg) (*providers.PaymentResponse, *paymentOutcome) {
+	res := &providers.PaymentResponse{
+		Status:       "SUCCESS",
+		ReferenceID:  "N/A",
+		ProviderName: splitProviderName,
+		Message:      fmt.Sprintf("Processed as %d payments.", len(plan)),
+	}
+	var failed *paymentOutcome
+	for i, planned := range plan {
+		leg := providers.SplitLeg{
+			TransactionID: splitLegID(req.TransactionID, i+1),
+			Amount:        providers.FromMinorUnits(planned.amount, req.Currency),
+			ProviderName:  a.Providers[planned.provider].Name(),
+			Status:        legSkipped,
+		}
+		if failed != nil {
+			res.Legs = append(res.Legs, leg)
+			continue
+		}
+
+		legReq := req
+		legReq.TransactionID = leg.TransactionID
+		legReq.Amount = leg.Amount
+		legReq.AmountMinor = planned.amount
+		legReq.Split = false
+		legReq.ProviderKey = ""
+		legReq.CallbackURL = ""
+		// Start at the planned provider; the rest of the route is its fallback
+		legReq.ProviderPreference = rotateRoute(route, planned.provider)
+
+		out := a.processPayment(ctx, legReq)
+		legRes, _ := out.Body.(*providers.PaymentResponse)
+		switch {
+		case legRes != nil:
+			leg.ProviderName = legRes.ProviderName
+			leg.ReferenceID = legRes.ReferenceID
+			leg.Status = legRes.Status
+			leg.Message = legRes.Message
+		case out.Body != nil:
+			leg.Status = "FAILED"
+			if e, ok := out.Body.(*ErrorResponse); ok {
+				leg.Message = strings.TrimSpace(e.Error + ". " + e.Message)
+			}
+		}
+		if out.StatusCode == http.StatusOK && leg.Status == "SUCCESS" {
+			res.Fee += legRes.Fee
+			res.NetAmount += legRes.NetAmount
+		} else {
+			if leg.Status == "SUCCESS" {
+				leg.Status = "FAILED"
+			}
+			slog.WarnContext(ctx, "split leg failed", "transaction_id", req.TransactionID, "leg", leg.TransactionID, "status", out.StatusCode)
+			failed = &out
+			res.Status = "FAILED"
+			res.Message = fmt.Sprintf("Leg %d of %d failed.", i+1, len(plan))
+		}
+		res.Legs = append(res.Legs, leg)
+	}
+	if failed != nil {
+		res.Fee, res.NetAmount = 0, 0
+	}
+	return res, failed
+}
+
+// rotateRoute returns route starting at first, followed by the providers after it
+// and then those before it.
+func rotateRoute(route []string, first string) []string {
+	for i, name := range route {
+		if name == first {
+			return append(append([]string{}, route[i:]...), route[:i]...)
+		}
+	}
+	return []string{first}
+}
+
+// compensateSplit refunds every leg of res that succeeded, after another leg failed.
+// A leg that can't be refunded is marked REFUND_FAILED and the payment PARTIAL, so
+// it can be reconciled by hand.
+func (a *Aggregator) compensateSplit(ctx context.Context, res *providers.PaymentResponse) {
+	refunded := 0
+	for i := range res.Legs {
+		leg := &res.Legs[i]
+		if leg.Status != "SUCCESS" {
+			continue
+		}
+		if err := a.refundSplitLeg(ctx, *leg); err != nil {
+			slog.ErrorContext(ctx, "failed to refund split leg", "leg", leg.TransactionID, "provider", leg.ProviderName, "error", err)
+			leg.Status = legRefundFailed
+			res.Status = splitPartial
+			continue
+		}
+		leg.Status = legRefunded
+		refunded++
+	}
+	res.Message += fmt.Sprintf(" %d completed legs refunded.", refunded)
+}
+
+// refundSplitLeg reverses one completed leg, holding the same refund idempotency
+// key as RefundHandler so the leg can't also be refunded through /v1/refund.
+func (a *Aggregator) refundSplitLeg(ctx context.Context, leg providers.SplitLeg) error {
+	providerName, ok := a.providerKeyByName(leg.ProviderName)
+	if !ok {
+		return fmt.Errorf("provider %s not found", leg.ProviderName)
+	}
+
+	refundKey := txnKey(ctx, refundKeyPrefix+leg.TransactionID)
+	state, err := a.Store.CheckOrSetInProgress(ctx, refundKey)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case cache.StateInProgress:
+		return errors.New("a refund for this leg is already in progress")
+	case cache.StateCompleted:
+		return nil
+	case cache.StateFailed:
+		return errors.New("an earlier refund of this leg needs reconciliation")
+	}
+
+	res, err := a.callRefund(ctx, providerName, providers.RefundRequest{
+		TransactionID: leg.TransactionID,
+		ReferenceID:   leg.ReferenceID,
+		Amount:        leg.Amount,
+	})
+	if err == nil && res.Status != "SUCCESS" {
+		err = fmt.Errorf("refund %s", res.Status)
+	}
+	if err != nil {
+		a.settleRefundKey(ctx, refundKey, leg.TransactionID, providerName, res, err)
+		return err
+	}
+	if err := a.Store.SetCompleted(ctx, refundKey); err != nil {
+		slog.WarnContext(ctx, "failed to mark refund completed", "transaction_id", leg.TransactionID, "error", err)
+	}
+	return nil
+}
+
+// splitStatusCode is the HTTP status for a finished split payment: 200 when every
+// leg succeeded, else 502 (the legs say which one failed and what was refunded).
+func splitStatusCode(res *providers.PaymentResponse) int {
+	if res.Status == "SUCCESS" {
+		return http.StatusOK
+	}
+	return http.StatusBadGateway
+}
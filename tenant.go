@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"strings"
+)
+
+// tenantHeader lets unauthenticated deployments scope transactions per tenant.
+const tenantHeader = "X-Tenant-ID"
+
+// maxTenantLength bounds tenant identifiers, which end up in every store key.
+const maxTenantLength = 64
+
+type tenantKey struct{}
+
+// tenantFromContext returns the tenant the request belongs to, or "" for none.
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// withTenant resolves which tenant a request belongs to and stores it in the
+// context. An authenticated client (see requireAPIKey) is its own tenant and
+// can't claim another; otherwise X-Tenant-ID is used when present.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := clientIDFromContext(r.Context())
+		if tenant == "" {
+			tenant = strings.TrimSpace(r.Header.Get(tenantHeader))
+		}
+
+		// ":" separates key segments, so allowing it would let one tenant address another's keys
+		if len(tenant) > maxTenantLength || strings.Contains(tenant, ":") {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusBadRequest, apiError(codeInvalidTenant, "Invalid Tenant",
+				"X-Tenant-ID must be at most 64 characters and must not contain ':'."))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// tenantScoped reports whether withTenant has resolved the request's tenant. It
+// writes a 500 when it hasn't: a handler that changes transactions must never fall
+// back to the tenant-less keys because its route was registered without withTenant.
+func tenantScoped(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.Context().Value(tenantKey{}).(string); ok {
+		return true
+	}
+	slog.ErrorContext(r.Context(), "route is not tenant-scoped", "path", r.URL.Path)
+	writeError(w, http.StatusInternalServerError, apiError(codeInternal, "Tenant could not be resolved", ""))
+	return false
+}
+
+// txnKey scopes transactionID to the request's tenant.
+func txnKey(ctx context.Context, transactionID string) cache.TxnKey {
+	return cache.TxnKey{Tenant: tenantFromContext(ctx), TransactionID: transactionID}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"payment-gateway-aggregator/config"
+	"sync/atomic"
+	"syscall"
+)
+
+// tlsVersions maps TLSConfig.MinVersion to the protocol constant.
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader hands the current certificate to each TLS handshake and can swap in
+// a fresh copy from disk, so certificates rotate without dropping connections.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader loads the key pair once, failing if it can't be read.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the key pair again. On failure the previous certificate stays in use.
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// watch reloads the certificate on every SIGHUP until ctx is done.
+func (c *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := c.reload(); err != nil {
+				slog.Error("TLS certificate reload failed, keeping the previous one", "error", err)
+				continue
+			}
+			slog.Info("TLS certificate reloaded", "cert_file", c.certFile)
+		}
+	}
+}
+
+// newTLSConfig builds the server's TLS settings from cfg. It returns nil (serve plain
+// HTTP) when no certificate is configured.
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, *certReloader, error) {
+	if !cfg.Enabled() {
+		return nil, nil, nil
+	}
+
+	minVersion, ok := tlsVersions[cfg.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown TLS min version %q (want 1.2 or 1.3)", cfg.MinVersion)
+	}
+	suites, err := cipherSuiteIDs(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: reloader.getCertificate,
+	}, reloader, nil
+}
+
+// cipherSuiteIDs maps suite names to their IDs. Only the suites Go considers
+// secure are accepted; nil (Go's defaults) when names is empty.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
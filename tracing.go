@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"payment-gateway-aggregator/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is resolved through the global provider, so spans are no-ops until
+// setupTracing installs a real exporter.
+var tracer = otel.Tracer("payment-gateway-aggregator")
+
+// setupTracing exports spans over OTLP/HTTP to cfg.Endpoint and accepts W3C
+// trace context from callers. With no endpoint configured tracing stays a no-op.
+// The returned function flushes pending spans and must be called on shutdown.
+func setupTracing(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	slog.Info("tracing enabled", "otlp_endpoint", cfg.Endpoint, "service_name", cfg.ServiceName)
+	return provider.Shutdown, nil
+}
+
+// paymentAttributes describes a payment on a span.
+func paymentAttributes(transactionID string, amount float64, currency string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("payment.transaction_id", transactionID),
+		attribute.Float64("payment.amount", amount),
+		attribute.String("payment.currency", currency),
+	}
+}
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"strings"
+)
+
+// cancelSuffix turns a transaction's URL into its cancel action.
+const cancelSuffix = "/cancel"
+
+// TransactionsHandler dispatches /v1/transactions/{id} by method, and
+// /v1/transactions/{id}/cancel to CancelHandler.
+func (a *Aggregator) TransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasSuffix(r.URL.Path, cancelSuffix) {
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		a.CancelHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		a.StatusHandler(w, r)
+	case "DELETE":
+		a.ClearHandler(w, r)
+	default:
+		methodNotAllowed(w, "GET", "DELETE")
+	}
+}
+
+// transactionIDFromPath extracts {id} from /v1/transactions/{id}.
+func transactionIDFromPath(path string) (string, bool) {
+	transactionID := strings.TrimPrefix(path, "/v1/transactions/")
+	if transactionID == "" || strings.Contains(transactionID, "/") {
+		return "", false
+	}
+	return transactionID, true
+}
+
+// StatusHandler reports the idempotency state of a transaction.
+// GET /v1/transactions/{id} -> {"transactionID":"...","status":"COMPLETED"}
+func (a *Aggregator) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	transactionID, ok := transactionIDFromPath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusBadRequest, apiError(codeValidationFailed, "Missing or invalid transaction ID", ""))
+		return
+	}
+
+	status, err := a.Store.GetStatus(r.Context(), txnKey(r.Context(), transactionID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read transaction status", "transaction_id", transactionID, "error", err)
+		writeError(w, http.StatusInternalServerError, apiError(codeStoreUnavailable, "Failed to read transaction status", ""))
+		return
+	}
+
+	// A payment deferred during an outage has no key until the queue worker picks it up
+	if status == "" && a.Queue != nil {
+		queued, err := a.Queue.IsQueued(r.Context(), txnKey(r.Context(), transactionID))
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to check payment queue", "transaction_id", transactionID, "error", err)
+		}
+		if queued {
+			status = cache.StatusQueued
+		}
+	}
+
+	// No key at all means we have never seen this ID (or its key has expired)
+	if status == "" {
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, fmt.Sprintf("Transaction %s not found", transactionID), ""))
+		return
+	}
+
+	body := transactionStatus{TransactionID: transactionID, Status: status}
+	// A failed async payment's status is all its client gets; say why it failed
+	if status == cache.StatusFailed {
+		if res, err := a.Store.GetResult(r.Context(), txnKey(r.Context(), transactionID)); err == nil && res != nil {
+			body.Message = res.Message
+		}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// ClearHandler is an admin operation that removes a stuck IN_PROGRESS key
+// (e.g. after a crash between CheckOrSetInProgress and SetCompleted) or a cached
+// failure, so the transaction can be retried immediately instead of waiting out the TTL.
+// It needs an API key and only reaches the caller's own tenant's transactions.
+// DELETE /v1/transactions/{id} -> 204, 404 if unknown, 409 if already COMPLETED.
+func (a *Aggregator) ClearHandler(w http.ResponseWriter, r *http.Request) {
+	if !tenantScoped(w, r) {
+		return
+	}
+	transactionID, ok := transactionIDFromPath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusBadRequest, apiError(codeValidationFailed, "Missing or invalid transaction ID", ""))
+		return
+	}
+
+	status, err := a.Store.GetStatus(r.Context(), txnKey(r.Context(), transactionID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read transaction status", "transaction_id", transactionID, "error", err)
+		writeError(w, http.StatusInternalServerError, apiError(codeStoreUnavailable, "Failed to read transaction status", ""))
+		return
+	}
+
+	switch status {
+	case "":
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, fmt.Sprintf("Transaction %s not found", transactionID), ""))
+		return
+	case cache.StatusCompleted:
+		writeError(w, http.StatusConflict, apiError(codeTransactionCompleted, "Transaction already completed",
+			"Completed transactions cannot be cleared."))
+		return
+	}
+
+	// The store re-checks the state atomically, so a transaction that completes
+	// between the GET above and this call is still protected.
+	if err := a.Store.Delete(r.Context(), txnKey(r.Context(), transactionID)); err != nil {
+		if errors.Is(err, cache.ErrNotInProgress) {
+			writeError(w, http.StatusConflict, apiError(codeTransactionChanged, "Transaction no longer in progress",
+				"The transaction changed state while being cleared. Check its status and retry."))
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to clear transaction", "transaction_id", transactionID, "error", err)
+		writeError(w, http.StatusInternalServerError, apiError(codeStoreUnavailable, "Failed to clear transaction", ""))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "cleared stuck transaction", "transaction_id", transactionID, "status", cache.StatusInProgress)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CancelHandler cancels an async payment that hasn't reached a provider yet: it is
+// marked CANCELLED, its worker drops it, and its TransactionID can't be reused.
+// POST /v1/transactions/{id}/cancel -> 200 with the CANCELLED status (also when it
+// already was), 404 if unknown, 409 once a provider may have it or it has finished.
+// Like ClearHandler it only reaches the authenticated caller's own transactions.
+func (a *Aggregator) CancelHandler(w http.ResponseWriter, r *http.Request) {
+	// The tenant is part of the key the cancel script runs on, so it must be resolved first
+	if !tenantScoped(w, r) {
+		return
+	}
+	transactionID, ok := transactionIDFromPath(strings.TrimSuffix(r.URL.Path, cancelSuffix))
+	if !ok {
+		writeError(w, http.StatusBadRequest, apiError(codeValidationFailed, "Missing or invalid transaction ID", ""))
+		return
+	}
+
+	key := txnKey(r.Context(), transactionID)
+	err := a.Store.Cancel(r.Context(), key)
+	switch {
+	case errors.Is(err, cache.ErrNotInProgress):
+		writeError(w, http.StatusNotFound, apiError(codeNotFound, fmt.Sprintf("Transaction %s not found", transactionID), ""))
+		return
+	case errors.Is(err, cache.ErrNotCancellable):
+		writeError(w, http.StatusConflict, apiError(codeNotCancellable, "Transaction cannot be cancelled",
+			"The payment has already been sent to a provider or has finished. Check its status."))
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to cancel transaction", "transaction_id", transactionID, "error", err)
+		writeError(w, http.StatusInternalServerError, apiError(codeStoreUnavailable, "Failed to cancel transaction", ""))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "cancelled pending transaction", "transaction_id", transactionID)
+	writeJSON(w, http.StatusOK, transactionStatus{
+		TransactionID: transactionID,
+		Status:        cache.StatusCancelled,
+	})
+}
+
+// cancelledOutcome answers a payment whose TransactionID was cancelled (see CancelHandler).
+func cancelledOutcome() paymentOutcome {
+	return paymentOutcome{StatusCode: http.StatusConflict, Body: apiError(codeTransactionCancelled,
+		"Transaction cancelled", "This transaction ID was cancelled. Submit the payment again with a new transaction ID.")}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"payment-gateway-aggregator/cache"
+	"testing"
+)
+
+func TestTransactionStatusIsScopedToTheAuthenticatedClient(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10), "X-API-Key", "shop-key"); rec.Code != http.StatusOK {
+		t.Fatalf("pay: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"same API key", []string{"X-API-Key", "shop-key"}, http.StatusOK},
+		{"no API key", nil, http.StatusUnauthorized},
+		{"another client's key", []string{"X-API-Key", "other-key"}, http.StatusNotFound},
+		{"another client claiming the tenant", []string{"X-API-Key", "other-key", "X-Tenant-ID", "shop"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "GET", "/v1/transactions/TXN-1", nil, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK {
+				var status transactionStatus
+				decode(t, rec, &status)
+				if status.Status != "COMPLETED" {
+					t.Errorf("status %q, want COMPLETED", status.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestClearIsAuthenticatedAndScopedToTheClient(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	// A stuck claim, as a crash mid-payment would leave it
+	key := cache.TxnKey{Tenant: "shop", TransactionID: "TXN-1"}
+	if _, err := env.store.CheckOrSetInProgress(context.Background(), key); err != nil {
+		t.Fatalf("CheckOrSetInProgress: %v", err)
+	}
+
+	steps := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"no API key", nil, http.StatusUnauthorized},
+		{"another client's key", []string{"X-API-Key", "other-key"}, http.StatusNotFound},
+		{"another client claiming the tenant", []string{"X-API-Key", "other-key", "X-Tenant-ID", "shop"}, http.StatusNotFound},
+		{"owner", []string{"X-API-Key", "shop-key"}, http.StatusNoContent},
+	}
+	for _, step := range steps {
+		rec := do(t, h, "DELETE", "/v1/transactions/TXN-1", nil, step.headers...)
+		if rec.Code != step.want {
+			t.Fatalf("%s: status %d, want %d (body %s)", step.name, rec.Code, step.want, rec.Body)
+		}
+	}
+}
+
+func TestClearRefusesARouteWithoutTenant(t *testing.T) {
+	env := newTestEnv(t, testConfig())
+	rec := do(t, http.HandlerFunc(env.a.TransactionsHandler), "DELETE", "/v1/transactions/TXN-1", nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+}
+
+func TestCancelIsScopedToTheClient(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.APIKeys = map[string]string{"shop": "shop-key", "other": "other-key"}
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	// An async payment still waiting for a worker
+	key := cache.TxnKey{Tenant: "shop", TransactionID: "TXN-1"}
+	if _, err := env.store.CheckOrSetInProgressWithInfo(context.Background(), key, cache.InProgressInfo{Stage: cache.StagePending}); err != nil {
+		t.Fatalf("CheckOrSetInProgressWithInfo: %v", err)
+	}
+
+	steps := []struct {
+		name    string
+		headers []string
+		want    int
+	}{
+		{"no API key", nil, http.StatusUnauthorized},
+		{"another client's key", []string{"X-API-Key", "other-key"}, http.StatusNotFound},
+		{"another client claiming the tenant", []string{"X-API-Key", "other-key", "X-Tenant-ID", "shop"}, http.StatusNotFound},
+		{"owner", []string{"X-API-Key", "shop-key"}, http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := do(t, h, "POST", "/v1/transactions/TXN-1/cancel", nil, step.headers...)
+		if rec.Code != step.want {
+			t.Fatalf("%s: status %d, want %d (body %s)", step.name, rec.Code, step.want, rec.Body)
+		}
+	}
+	if status, _ := env.store.GetStatus(context.Background(), key); status != cache.StatusCancelled {
+		t.Errorf("status %q, want %s", status, cache.StatusCancelled)
+	}
+}
+
+func TestErrorsShareTheErrorResponseShape(t *testing.T) {
+	cfg := testConfig()
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-1", 10)); rec.Code != http.StatusOK {
+		t.Fatalf("pay: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         any
+		headers      []string
+		want         int
+		wantCode     string
+	}{
+		{"refund with an unknown field", "POST", "/v1/refund", map[string]any{"Ammount": 5}, nil, http.StatusBadRequest, codeInvalidBody},
+		{"refund without an amount", "POST", "/v1/refund", refundRequest{TransactionID: "TXN-1"}, nil, http.StatusBadRequest, codeValidationFailed},
+		{"refund of an unknown payment", "POST", "/v1/refund", refundRequest{TransactionID: "TXN-404", Amount: 5}, nil, http.StatusNotFound, codeNotFound},
+		{"refund over the payment", "POST", "/v1/refund", refundRequest{TransactionID: "TXN-1", Amount: 50}, nil, http.StatusUnprocessableEntity, codeAmountExceedsPayment},
+		{"empty batch", "POST", "/v1/pay/batch", []any{}, nil, http.StatusBadRequest, codeInvalidBatchSize},
+		{"batch that isn't a list", "POST", "/v1/pay/batch", map[string]any{}, nil, http.StatusBadRequest, codeInvalidBody},
+		{"status of an unknown transaction", "GET", "/v1/transactions/TXN-404", nil, nil, http.StatusNotFound, codeNotFound},
+		{"clear a completed transaction", "DELETE", "/v1/transactions/TXN-1", nil, nil, http.StatusConflict, codeTransactionCompleted},
+		{"cancel a completed transaction", "POST", "/v1/transactions/TXN-1/cancel", nil, nil, http.StatusConflict, codeNotCancellable},
+		{"cancel an unknown transaction", "POST", "/v1/transactions/TXN-404/cancel", nil, nil, http.StatusNotFound, codeNotFound},
+		{"invalid tenant", "GET", "/v1/transactions/TXN-1", nil, []string{"X-Tenant-ID", "a:b"}, http.StatusBadRequest, codeInvalidTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, tt.method, tt.path, tt.body, tt.headers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			var res ErrorResponse
+			decode(t, rec, &res)
+			if res.Code != tt.wantCode || res.Error == "" || res.RequestID == "" {
+				t.Errorf("response %+v, want code %s with a title and request ID", res, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// transactionIDFormat is what a client-supplied TransactionID must look like. IDs
+// end up in store keys ("txn:<id>"), so whitespace, ':' (the key separator) and
+// unbounded lengths are turned away where a payment enters. IDs the aggregator
+// derives itself, such as split legs ("split:TXN-1:2"), never pass through it.
+type transactionIDFormat struct {
+	pattern   *regexp.Regexp // Nil accepts any characters
+	maxLength int            // In characters; 0 means no limit
+}
+
+// newTransactionIDFormat compiles pattern (empty accepts any characters) for IDs of
+// at most maxLength characters.
+func newTransactionIDFormat(pattern string, maxLength int) (transactionIDFormat, error) {
+	f := transactionIDFormat{maxLength: maxLength}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return transactionIDFormat{}, fmt.Errorf("transaction ID pattern: %w", err)
+		}
+		f.pattern = re
+	}
+	return f, nil
+}
+
+// check returns why id doesn't conform, or nil. An empty id passes, so the
+// "TransactionID is required" check can report it.
+func (f transactionIDFormat) check(id string) error {
+	if id == "" {
+		return nil
+	}
+	if f.maxLength > 0 && utf8.RuneCountInString(id) > f.maxLength {
+		return fmt.Errorf("TransactionID must be at most %d characters", f.maxLength)
+	}
+	if f.pattern != nil && !f.pattern.MatchString(id) {
+		return fmt.Errorf("TransactionID must match %s", f.pattern)
+	}
+	return nil
+}
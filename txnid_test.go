@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransactionIDFormat(t *testing.T) {
+	const uuid = `^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`
+	defaults := testConfig()
+
+	tests := []struct {
+		name      string
+		pattern   string
+		maxLength int
+		id        string
+		ok        bool
+	}{
+		{"simple", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "TXN-2024-0001", true},
+		{"exactly 64 characters", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, strings.Repeat("a", 64), true},
+		{"65 characters", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, strings.Repeat("a", 65), false},
+		{"colon", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "shop:TXN-1", false},
+		{"space", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "TXN 1", false},
+		{"trailing newline", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "TXN-1\n", false},
+		{"slash", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "TXN/1", false},
+		{"non-ASCII", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "TXN-é", false},
+		{"empty is left to the required check", defaults.TransactionIDPattern, defaults.TransactionIDMaxLength, "", true},
+
+		{"custom pattern match", uuid, 36, "123e4567-e89b-12d3-a456-426614174000", true},
+		{"custom pattern mismatch", uuid, 36, "TXN-1", false},
+		{"no pattern accepts any characters", "", 64, "shop:TXN 1/é", true},
+		{"length counts characters, not bytes", "", 4, "éééé", true},
+		{"no length limit", "", 0, strings.Repeat("a", 1000), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newTransactionIDFormat(tt.pattern, tt.maxLength)
+			if err != nil {
+				t.Fatalf("newTransactionIDFormat: %v", err)
+			}
+			if err := f.check(tt.id); (err == nil) != tt.ok {
+				t.Errorf("check(%q) = %v, want ok %v", tt.id, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTransactionIDFormatRejectsABadPattern(t *testing.T) {
+	if _, err := newTransactionIDFormat(`[unclosed`, 64); err == nil {
+		t.Error("newTransactionIDFormat accepted an invalid pattern")
+	}
+}
+
+func TestPayRejectsMalformedTransactionIDs(t *testing.T) {
+	cfg := testConfig()
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"64 characters", strings.Repeat("a", 64), http.StatusOK},
+		{"65 characters", strings.Repeat("b", 65), http.StatusBadRequest},
+		{"colon", "shop:TXN-1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "POST", "/v1/pay", payment(tt.id, 10))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusBadRequest {
+				var body ErrorResponse
+				decode(t, rec, &body)
+				if body.Code != codeValidationFailed || !strings.Contains(body.Message, "TransactionID") {
+					t.Errorf("body %+v, want a TransactionID validation error", body)
+				}
+			}
+		})
+	}
+	if calls := env.mtn.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want only for the valid ID", calls)
+	}
+
+	// The same checks apply to the Idempotency-Key header
+	if rec := do(t, h, "POST", "/v1/pay", payment("", 10), "Idempotency-Key", "shop:TXN-2"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Idempotency-Key with a colon: status %d, want 400", rec.Code)
+	}
+}
+
+func TestPayUsesAConfiguredTransactionIDPattern(t *testing.T) {
+	cfg := testConfig()
+	cfg.TransactionIDPattern = `^ORD-[0-9]+$`
+	cfg.TransactionIDMaxLength = 10
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	for id, want := range map[string]int{
+		"ORD-123":     http.StatusOK,
+		"TXN-123":     http.StatusBadRequest,
+		"ORD-1234567": http.StatusBadRequest, // 11 characters
+	} {
+		if rec := do(t, h, "POST", "/v1/pay", payment(id, 10)); rec.Code != want {
+			t.Errorf("%s: status %d, want %d (body %s)", id, rec.Code, want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"payment-gateway-aggregator/config"
+	"payment-gateway-aggregator/providers"
+	"payment-gateway-aggregator/requestid"
+	"sync"
+	"time"
+)
+
+const (
+	// signatureHeader carries "sha256=<hex HMAC of the body>" so receivers can verify the sender
+	signatureHeader     = "X-Signature"
+	transactionIDHeader = "X-Transaction-ID"
+)
+
+// webhookEvent is one pending callback. The body is marshalled when the event is
+// queued so later changes to the response don't leak into the delivery.
+type webhookEvent struct {
+	url           string
+	transactionID string
+	requestID     string
+	body          []byte
+}
+
+// WebhookDispatcher delivers completion callbacks in the background so the
+// payment response is never held up by a slow or unreachable receiver.
+type WebhookDispatcher struct {
+	cfg    config.WebhookConfig
+	client *http.Client
+	queue  chan webhookEvent
+	done   chan struct{}
+
+	// stop aborts in-progress backoff waits once Close gives up on draining
+	stopCtx context.Context
+	stop    context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+// NewWebhookDispatcher starts the delivery worker. Call Close to stop it.
+func NewWebhookDispatcher(cfg config.WebhookConfig) *WebhookDispatcher {
+	stopCtx, stop := context.WithCancel(context.Background())
+	d := &WebhookDispatcher{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		queue:   make(chan webhookEvent, cfg.QueueSize),
+		done:    make(chan struct{}),
+		stopCtx: stopCtx,
+		stop:    stop,
+	}
+	go d.run()
+	return d
+}
+
+// Enqueue schedules res for delivery to url without blocking. If the queue is
+// full the callback is dropped and logged rather than slowing down payments.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, url, transactionID string, res *providers.PaymentResponse) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to encode webhook payload", "transaction_id", transactionID, "error", err)
+		return
+	}
+
+	event := webhookEvent{url: url, transactionID: transactionID, requestID: requestid.FromContext(ctx), body: body}
+	select {
+	case d.queue <- event:
+	default:
+		slog.WarnContext(ctx, "webhook queue full, dropping callback", "transaction_id", transactionID, "url", url)
+	}
+}
+
+// Close stops accepting callbacks and waits for queued ones to be delivered,
+// abandoning whatever is left once ctx expires.
+func (d *WebhookDispatcher) Close(ctx context.Context) error {
+	d.closeOnce.Do(func() { close(d.queue) })
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		d.stop()
+		<-d.done
+		return fmt.Errorf("webhook queue not drained: %w", ctx.Err())
+	}
+}
+
+func (d *WebhookDispatcher) run() {
+	defer close(d.done)
+	for event := range d.queue {
+		if d.stopCtx.Err() != nil {
+			slog.Warn("dropping undelivered webhook on shutdown", "transaction_id", event.transactionID, "url", event.url)
+			continue
+		}
+		d.deliver(event)
+	}
+}
+
+// deliver POSTs one event, retrying with exponential backoff on transport errors
+// and non-2xx responses.
+func (d *WebhookDispatcher) deliver(event webhookEvent) {
+	ctx := requestid.NewContext(d.stopCtx, event.requestID)
+
+	var err error
+	for attempt := 0; attempt < d.cfg.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := backoff(time.Duration(d.cfg.BaseDelay), time.Duration(d.cfg.MaxDelay), attempt-1)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				slog.WarnContext(ctx, "webhook delivery abandoned", "transaction_id", event.transactionID, "url", event.url, "error", err)
+				return
+			}
+		}
+
+		if err = d.post(ctx, event); err == nil {
+			slog.InfoContext(ctx, "webhook delivered", "transaction_id", event.transactionID, "url", event.url, "attempts", attempt+1)
+			return
+		}
+		slog.WarnContext(ctx, "webhook delivery failed", "transaction_id", event.transactionID, "url", event.url, "attempt", attempt+1, "error", err)
+	}
+
+	slog.ErrorContext(ctx, "webhook delivery gave up", "transaction_id", event.transactionID, "url", event.url, "attempts", d.cfg.MaxAttempts, "error", err)
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, event webhookEvent) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, event.url, bytes.NewReader(event.body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(transactionIDHeader, event.transactionID)
+	if event.requestID != "" {
+		httpReq.Header.Set(requestid.Header, event.requestID)
+	}
+	if d.cfg.Secret != "" {
+		httpReq.Header.Set(signatureHeader, "sha256="+sign(d.cfg.Secret, event.body))
+	}
+
+	resp, err := d.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("receiver returned " + resp.Status)
+	}
+	return nil
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body under secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyCompletion queues a callback for a finished payment (SUCCESS, FAILED,
+// PARTIAL or CANCELLED; a TIMEOUT is not final and isn't reported), using the
+// request's CallbackURL or else the configured default. Does nothing when neither
+// is set, or for the legs of a split payment (its parent reports them all at once).
+func (a *Aggregator) notifyCompletion(ctx context.Context, req providers.PaymentRequest, res *providers.PaymentResponse) {
+	if a.Webhooks == nil || res == nil || isSplitLeg(req.TransactionID) {
+		return
+	}
+	url := req.CallbackURL
+	if url == "" {
+		url = a.DefaultCallbackURL
+	}
+	if url == "" {
+		return
+	}
+	a.Webhooks.Enqueue(ctx, url, req.TransactionID, res)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway-aggregator/providers"
+	"testing"
+	"time"
+)
+
+// receivedCallback is one request seen by a test webhook receiver.
+type receivedCallback struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookIsSignedAndSkipsTimeouts(t *testing.T) {
+	received := make(chan receivedCallback, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedCallback{header: r.Header.Clone(), body: body}
+	}))
+	defer receiver.Close()
+
+	cfg := testConfig()
+	cfg.Webhook.URL = receiver.URL
+	cfg.Webhook.Secret = "s3cret"
+	env := newTestEnv(t, cfg)
+	h := env.handler(t, cfg)
+
+	// Neither provider answers in time
+	timeout := func(ctx context.Context, req providers.PaymentRequest) (*providers.PaymentResponse, error) {
+		return nil, context.DeadlineExceeded
+	}
+	env.mtn.process, env.airtel.process = timeout, timeout
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-TIMEOUT", 10)); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("timed-out pay: status %d, want 504 (body %s)", rec.Code, rec.Body)
+	}
+
+	env.mtn.process, env.airtel.process = nil, nil
+	if rec := do(t, h, "POST", "/v1/pay", payment("TXN-OK", 10)); rec.Code != http.StatusOK {
+		t.Fatalf("pay: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// Callbacks are delivered in order, so the timeout's would have come first
+	var cb receivedCallback
+	select {
+	case cb = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no callback received")
+	}
+	if got := cb.header.Get(transactionIDHeader); got != "TXN-OK" {
+		t.Fatalf("first callback for %s, want TXN-OK", got)
+	}
+	if got, want := cb.header.Get(signatureHeader), "sha256="+sign("s3cret", cb.body); got != want {
+		t.Errorf("%s = %q, want %q", signatureHeader, got, want)
+	}
+	if got := cb.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// The widely published HMAC-SHA256 of this sentence under "key"
+	got := sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	want := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Errorf("sign = %s, want %s", got, want)
+	}
+}